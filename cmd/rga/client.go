@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// client issues requests against one session of a server
+type client struct {
+	base    *url.URL
+	apiKey  string
+	session string
+	http    *http.Client
+}
+
+// apiError is an error response of the server
+type apiError struct {
+	Status    int
+	ErrorType string `json:"error_type"`
+	Message   string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	if e.ErrorType == "" {
+		return fmt.Sprintf("%s (HTTP %d)", e.Message, e.Status)
+	}
+	return fmt.Sprintf("%s (%s)", e.Message, e.ErrorType)
+}
+
+func newClient(server, apiKey, session string, timeout time.Duration) (*client, error) {
+	base, err := url.Parse(strings.TrimRight(server, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL %q: %w", server, err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("invalid server URL %q: expected http:// or https://", server)
+	}
+	return &client{base: base, apiKey: apiKey, session: session, http: &http.Client{Timeout: timeout}}, nil
+}
+
+// path returns the API path of a session route; the default session uses the
+// unscoped routes
+func (c *client) path(route string) string {
+	if c.session == "" || c.session == "default" {
+		return "/api" + route
+	}
+	return "/api/sessions/" + url.PathEscape(c.session) + route
+}
+
+// do sends a request to a session route and returns the response body. Error
+// responses are returned as *apiError.
+func (c *client) do(method, route string, query url.Values, body interface{}) ([]byte, error) {
+	target := *c.base
+	target.Path += c.path(route)
+	target.RawQuery = query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, target.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		apiErr := &apiError{Status: resp.StatusCode}
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+			if apiErr.Message == "" {
+				apiErr.Message = http.StatusText(resp.StatusCode)
+			}
+		}
+		return nil, apiErr
+	}
+	return data, nil
+}
+
+// call sends a request and decodes the JSON response into result
+func (c *client) call(method, route string, query url.Values, body, result interface{}) error {
+	data, err := c.do(method, route, query, body)
+	if err != nil {
+		return err
+	}
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", route, err)
+	}
+	return nil
+}
+
+// dial opens the session's WebSocket stream
+func (c *client) dial() (*websocket.Conn, error) {
+	target := *c.base
+	target.Scheme = "ws"
+	if c.base.Scheme == "https" {
+		target.Scheme = "wss"
+	}
+	target.Path += c.path("/ws")
+
+	header := http.Header{}
+	if c.apiKey != "" {
+		header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(target.String(), header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %s", target.String(), resp.Status)
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %w", target.String(), err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// PropertyValue is a property read from the server
+type PropertyValue struct {
+	Name    string      `json:"name"`
+	Value   interface{} `json:"value"`
+	Raw     string      `json:"raw"`
+	Address string      `json:"address"`
+	Frozen  bool        `json:"frozen"`
+}
+
+func runStatus(a *app, args []string) error {
+	if len(args) != 0 {
+		return usagef("status takes no arguments")
+	}
+	var status map[string]interface{}
+	if err := a.client.call("GET", "/status", nil, nil, &status); err != nil {
+		return err
+	}
+	return a.print(status, func() { a.printFields(status) })
+}
+
+func runGet(a *app, args []string) error {
+	if len(args) != 1 {
+		return usagef("expected a property name")
+	}
+	var value PropertyValue
+	if err := a.client.call("GET", "/properties/"+url.PathEscape(args[0]), nil, nil, &value); err != nil {
+		return err
+	}
+	return a.print(value, func() { a.printProperty(value) })
+}
+
+func runSet(a *app, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("set", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return usagef("expected a property name and a value")
+	}
+	var value PropertyValue
+	body := map[string]interface{}{"value": parseValue(args[1])}
+	if err := a.client.call("PUT", "/properties/"+url.PathEscape(args[0])+"/value", nil, body, &value); err != nil {
+		return err
+	}
+	return a.print(value, func() { a.printProperty(value) })
+}
+
+func runFreeze(a *app, args []string) error {
+	fs := flag.NewFlagSet("freeze", flag.ContinueOnError)
+	off := fs.Bool("off", false, "Release the property instead")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 1 || len(args) > 2 || (*off && len(args) != 1) {
+		return usagef("expected a property name and, unless --off, an optional value")
+	}
+
+	body := map[string]interface{}{"freeze": !*off}
+	if len(args) == 2 {
+		body["value"] = parseValue(args[1])
+	}
+	var value PropertyValue
+	if err := a.client.call("POST", "/properties/"+url.PathEscape(args[0])+"/freeze", nil, body, &value); err != nil {
+		return err
+	}
+	return a.print(value, func() { a.printProperty(value) })
+}
+
+// wsMessage is a message of the WebSocket stream
+type wsMessage struct {
+	Type      string          `json:"type"`
+	Seq       uint64          `json:"seq,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+
+	raw json.RawMessage
+}
+
+func runWatch(a *app, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	types := fs.String("types", "", "Message types to receive, e.g. pokemon_diff,watchpoint_hit")
+	properties := fs.String("properties", "", "Property globs to receive, e.g. money,pokemon.*.current_hp")
+	count := fs.Int("count", 0, "Exit after this many messages (0 runs until interrupted)")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return usagef("watch takes no arguments")
+	}
+
+	conn, err := a.client.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The welcome message and subscription ack precede the filtered stream,
+	// so they are only printed when their type is asked for
+	wanted := map[string]bool{}
+	for _, t := range splitList(*types) {
+		wanted[t] = true
+	}
+	subscribed := *types != "" || *properties != ""
+	if subscribed {
+		subscribe := map[string]interface{}{
+			"type": "subscribe",
+			"data": map[string]interface{}{"types": splitList(*types), "properties": splitList(*properties)},
+		}
+		if err := conn.WriteJSON(subscribe); err != nil {
+			return fmt.Errorf("failed to subscribe: %w", err)
+		}
+	}
+
+	// Close the connection on Ctrl-C so the read loop ends cleanly
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	done := make(chan struct{})
+	defer close(done)
+	interrupted := make(chan struct{})
+	go func() {
+		select {
+		case <-interrupt:
+			close(interrupted)
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	seen := 0
+	for *count == 0 || seen < *count {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-interrupted:
+				return nil
+			default:
+			}
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return nil
+			}
+			return fmt.Errorf("stream closed: %w", err)
+		}
+
+		messages, err := decodeMessages(data)
+		if err != nil {
+			return err
+		}
+		for _, m := range messages {
+			if len(wanted) > 0 && !wanted[m.Type] || subscribed && (m.Type == "connected" || m.Type == "subscribed") && !wanted[m.Type] {
+				continue
+			}
+			a.printMessage(m)
+			if seen++; *count != 0 && seen >= *count {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// decodeMessages decodes a frame, unwrapping batch envelopes
+func decodeMessages(data []byte) ([]wsMessage, error) {
+	message := wsMessage{raw: data}
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	if message.Type != "batch" {
+		return []wsMessage{message}, nil
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(message.Data, &batch); err != nil {
+		return nil, fmt.Errorf("invalid batch: %w", err)
+	}
+	messages := make([]wsMessage, len(batch))
+	for i, data := range batch {
+		messages[i].raw = data
+		if err := json.Unmarshal(data, &messages[i]); err != nil {
+			return nil, fmt.Errorf("invalid message: %w", err)
+		}
+	}
+	return messages, nil
+}
+
+// MemoryRange is a block of memory read from the server
+type MemoryRange struct {
+	Address string `json:"address"`
+	Length  int    `json:"length"`
+	Hex     string `json:"hex"`
+}
+
+func runDump(a *app, args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	raw := fs.Bool("raw", false, "Write the bytes to stdout unformatted")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return usagef("expected a start address and a length")
+	}
+
+	query := url.Values{"start": {args[0]}, "length": {args[1]}}
+	var memory MemoryRange
+	if err := a.client.call("GET", "/memory", query, nil, &memory); err != nil {
+		return err
+	}
+	data, err := hex.DecodeString(memory.Hex)
+	if err != nil {
+		return fmt.Errorf("invalid memory in response: %w", err)
+	}
+	start, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(memory.Address), "0x"), 16, 32)
+	if err != nil {
+		return fmt.Errorf("invalid address in response: %w", err)
+	}
+
+	if *raw {
+		_, err := a.out.Write(data)
+		return err
+	}
+	return a.print(memory, func() { a.printHexDump(uint32(start), data) })
+}
+
+func runSearch(a *app, args []string) error {
+	if len(args) == 0 {
+		return usagef("expected start, filter, results, list or delete")
+	}
+	switch args[0] {
+	case "start":
+		fs := flag.NewFlagSet("search start", flag.ContinueOnError)
+		regions := fs.String("regions", "", "Regions to search, e.g. 0xD000-0xDFFF,0xA000-0xBFFF (default: the session's memory blocks)")
+		aligned := fs.Bool("aligned", false, "Only consider addresses that are a multiple of the value size")
+		rest, err := parseFlags(fs, args[1:])
+		if err != nil {
+			return err
+		}
+		if len(rest) != 1 {
+			return usagef("search start expects a type, e.g. uint8, uint16le or bcd24")
+		}
+		body := map[string]interface{}{"type": rest[0], "aligned": *aligned}
+		if *regions != "" {
+			var list []map[string]string
+			for _, region := range splitList(*regions) {
+				start, end, ok := strings.Cut(region, "-")
+				if !ok {
+					return usagef("invalid region %q: expected start-end", region)
+				}
+				list = append(list, map[string]string{"start": start, "end": end})
+			}
+			body["regions"] = list
+		}
+		var info map[string]interface{}
+		if err := a.client.call("POST", "/search", nil, body, &info); err != nil {
+			return err
+		}
+		return a.print(info, func() { a.printSearch(info) })
+
+	case "filter":
+		if len(args) < 3 || len(args) > 4 {
+			return usagef("search filter expects an ID, an operation and an optional value")
+		}
+		body := map[string]interface{}{"op": args[2]}
+		if len(args) == 4 {
+			body["value"] = parseValue(args[3])
+		}
+		var info map[string]interface{}
+		if err := a.client.call("POST", "/search/"+url.PathEscape(args[1])+"/filter", nil, body, &info); err != nil {
+			return err
+		}
+		return a.print(info, func() { a.printSearch(info) })
+
+	case "results":
+		fs := flag.NewFlagSet("search results", flag.ContinueOnError)
+		offset := fs.Int("offset", 0, "First candidate to list")
+		limit := fs.Int("limit", 100, "Candidates to list")
+		rest, err := parseFlags(fs, args[1:])
+		if err != nil {
+			return err
+		}
+		if len(rest) != 1 {
+			return usagef("search results expects an ID")
+		}
+		query := url.Values{"offset": {strconv.Itoa(*offset)}, "limit": {strconv.Itoa(*limit)}}
+		var page searchPage
+		if err := a.client.call("GET", "/search/"+url.PathEscape(rest[0]), query, nil, &page); err != nil {
+			return err
+		}
+		return a.print(page, func() { a.printResults(page) })
+
+	case "list":
+		var list struct {
+			Searches []map[string]interface{} `json:"searches"`
+		}
+		if err := a.client.call("GET", "/search", nil, nil, &list); err != nil {
+			return err
+		}
+		return a.print(list, func() {
+			for _, info := range list.Searches {
+				a.printSearch(info)
+			}
+		})
+
+	case "delete":
+		if len(args) != 2 {
+			return usagef("search delete expects an ID")
+		}
+		if _, err := a.client.do("DELETE", "/search/"+url.PathEscape(args[1]), nil, nil); err != nil {
+			return err
+		}
+		return a.print(map[string]interface{}{"deleted": args[1]}, func() { fmt.Fprintf(a.out, "deleted search %s\n", args[1]) })
+	}
+	return usagef("unknown search command %q", args[0])
+}
+
+func runRecord(a *app, args []string) error {
+	return runRecorder(a, "/recording", args)
+}
+
+func runCapture(a *app, args []string) error {
+	return runRecorder(a, "/capture", args)
+}
+
+// runRecorder starts, stops or reports a recording or capture, which share
+// their routes' shape
+func runRecorder(a *app, route string, args []string) error {
+	if len(args) == 0 {
+		return usagef("expected start, stop or status")
+	}
+
+	var status map[string]interface{}
+	var err error
+	switch {
+	case args[0] == "start" && len(args) <= 2:
+		body := map[string]interface{}{}
+		if len(args) == 2 {
+			body["name"] = args[1]
+		}
+		err = a.client.call("POST", route, nil, body, &status)
+	case args[0] == "stop" && len(args) == 1:
+		err = a.client.call("DELETE", route, nil, nil, &status)
+	case args[0] == "status" && len(args) == 1:
+		err = a.client.call("GET", route, nil, nil, &status)
+	default:
+		return usagef("expected start [name], stop or status")
+	}
+	if err != nil {
+		return err
+	}
+	return a.print(status, func() { a.printFields(status) })
+}
+
+func runReplay(a *app, args []string) error {
+	if len(args) == 0 {
+		return usagef("expected status, pause, resume, speed, seek or loop")
+	}
+
+	control := map[string]interface{}{}
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		control = nil
+	case args[0] == "pause" && len(args) == 1:
+		control["paused"] = true
+	case args[0] == "resume" && len(args) == 1:
+		control["paused"] = false
+	case args[0] == "speed" && len(args) == 2:
+		speed, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return usagef("invalid speed %q", args[1])
+		}
+		control["speed"] = speed
+	case args[0] == "seek" && len(args) == 2:
+		position, err := parseSeconds(args[1])
+		if err != nil {
+			return usagef("invalid position %q: use seconds or a duration such as 1m30s", args[1])
+		}
+		control["position"] = position
+	case args[0] == "loop" && len(args) == 2 && (args[1] == "on" || args[1] == "off"):
+		control["loop"] = args[1] == "on"
+	default:
+		return usagef("expected status, pause, resume, speed <x>, seek <seconds> or loop on|off")
+	}
+
+	var status map[string]interface{}
+	var err error
+	if control == nil {
+		err = a.client.call("GET", "/replay", nil, nil, &status)
+	} else {
+		err = a.client.call("PUT", "/replay", nil, control, &status)
+	}
+	if err != nil {
+		return err
+	}
+	return a.print(status, func() { a.printFields(status) })
+}
+
+// parseValue parses a command-line value as JSON, so numbers, booleans and
+// arrays keep their type, and falls back to the literal string
+func parseValue(arg string) interface{} {
+	var value interface{}
+	if err := json.Unmarshal([]byte(arg), &value); err != nil {
+		return arg
+	}
+	return value
+}
+
+// parseSeconds parses plain seconds or a Go duration
+func parseSeconds(arg string) (float64, error) {
+	if seconds, err := strconv.ParseFloat(arg, 64); err == nil {
+		return seconds, nil
+	}
+	d, err := time.ParseDuration(arg)
+	if err != nil {
+		return 0, err
+	}
+	return d.Seconds(), nil
+}
@@ -0,0 +1,178 @@
+// Command rga drives a RetroGameAnalysis server from the command line, for
+// shell scripts and test harnesses as much as for people. Every command
+// prints a human-readable summary, or the server's JSON with -o json.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// envPrefix matches the server's environment variables
+const envPrefix = "RGA_"
+
+// command is one subcommand
+type command struct {
+	usage string
+	help  string
+	run   func(app *app, args []string) error
+}
+
+var commands = map[string]command{
+	"status":  {"status", "Show the session's connection and poll status", runStatus},
+	"get":     {"get <property>", "Read a property", runGet},
+	"set":     {"set <property> <value>", "Write a property (the value is parsed as JSON, else used as a string)", runSet},
+	"freeze":  {"freeze [--off] <property> [value]", "Freeze a property at its current or a given value, or release it", runFreeze},
+	"watch":   {"watch [--types t,...] [--properties p,...] [--count n]", "Print messages from the session's WebSocket stream", runWatch},
+	"dump":    {"dump [--raw] <start> <length>", "Hex dump memory, or write it raw to stdout", runDump},
+	"search":  {"search start|filter|results|list|delete ...", "Run a RAM search", runSearch},
+	"record":  {"record start [name] | stop|status", "Record the session's timeline", runRecord},
+	"capture": {"capture start [name] | stop|status", "Capture the session's raw memory reads", runCapture},
+	"replay":  {"replay status|pause|resume | speed <x> | seek <seconds> | loop on|off", "Control a replay session", runReplay},
+}
+
+// commandOrder is the order commands are listed in the usage text
+var commandOrder = []string{"status", "get", "set", "freeze", "watch", "dump", "search", "record", "capture", "replay"}
+
+// app holds what every command needs
+type app struct {
+	client *client
+	out    io.Writer
+	json   bool
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run parses the global flags, runs one command and returns the exit code:
+// 0 on success, 1 when the command fails and 2 on a usage error
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("rga", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	serverURL := fs.String("server", envDefault("SERVER", "http://localhost:8080"), "Server URL (env "+envPrefix+"SERVER)")
+	apiKey := fs.String("api-key", os.Getenv(envPrefix+"API_KEY"), "API key (env "+envPrefix+"API_KEY)")
+	session := fs.String("session", envDefault("SESSION", "default"), "Session ID (env "+envPrefix+"SESSION)")
+	output := fs.String("o", envDefault("OUTPUT", "text"), "Output format: text or json (env "+envPrefix+"OUTPUT)")
+	timeout := fs.Duration("timeout", 10*time.Second, "HTTP request timeout")
+	fs.Usage = func() { usage(fs, stderr) }
+
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		usage(fs, stderr)
+		return 2
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(stderr, "rga: invalid output format %q (expected text or json)\n", *output)
+		return 2
+	}
+
+	name := fs.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "rga: unknown command %q\n", name)
+		usage(fs, stderr)
+		return 2
+	}
+
+	c, err := newClient(*serverURL, *apiKey, *session, *timeout)
+	if err != nil {
+		fmt.Fprintf(stderr, "rga: %v\n", err)
+		return 2
+	}
+
+	err = cmd.run(&app{client: c, out: stdout, json: *output == "json"}, fs.Args()[1:])
+	var usageErr *usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(stderr, "rga %s: %v\nusage: rga %s\n", name, usageErr.err, cmd.usage)
+		return 2
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	default:
+		fmt.Fprintf(stderr, "rga %s: %v\n", name, err)
+		return 1
+	}
+}
+
+func usage(fs *flag.FlagSet, w io.Writer) {
+	width := 0
+	for _, name := range commandOrder {
+		width = max(width, len(commands[name].usage))
+	}
+	fmt.Fprintf(w, "usage: rga [flags] <command> [arguments]\n\ncommands:\n")
+	for _, name := range commandOrder {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, commands[name].usage, commands[name].help)
+	}
+	fmt.Fprintf(w, "\nflags:\n")
+	fs.PrintDefaults()
+}
+
+// envDefault returns the RGA_ environment variable name, or fallback
+func envDefault(name, fallback string) string {
+	if value, ok := os.LookupEnv(envPrefix + name); ok {
+		return value
+	}
+	return fallback
+}
+
+// usageError reports arguments a command cannot use
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func usagef(format string, args ...interface{}) error {
+	return &usageError{err: fmt.Errorf(format, args...)}
+}
+
+// parseFlags parses a command's own flags, which may come before or after its
+// positional arguments, and returns the positional arguments
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return nil, err
+			}
+			return nil, &usageError{err: err}
+		}
+		// Everything after "--" is positional, e.g. a negative value
+		if consumed := len(args) - fs.NArg(); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, fs.Args()...), nil
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
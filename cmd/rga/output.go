@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// searchPage is one page of a search's candidates
+type searchPage struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Candidates int    `json:"candidates"`
+	Offset     int    `json:"offset"`
+	Limit      int    `json:"limit"`
+	Results    []struct {
+		Address  uint32  `json:"address"`
+		Value    uint64  `json:"value"`
+		Previous *uint64 `json:"previous,omitempty"`
+	} `json:"results"`
+}
+
+// print writes v as indented JSON with -o json, and calls text otherwise
+func (a *app) print(v interface{}, text func()) error {
+	if !a.json {
+		text()
+		return nil
+	}
+	encoder := json.NewEncoder(a.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// printFields writes an object as one "key: value" line per leaf, with
+// nested keys joined by dots
+func (a *app) printFields(fields map[string]interface{}) {
+	lines := flatten("", fields, nil)
+	width := 0
+	for _, line := range lines {
+		if len(line[0]) > width {
+			width = len(line[0])
+		}
+	}
+	for _, line := range lines {
+		fmt.Fprintf(a.out, "%-*s  %s\n", width+1, line[0]+":", line[1])
+	}
+}
+
+func flatten(prefix string, value interface{}, lines [][2]string) [][2]string {
+	object, ok := value.(map[string]interface{})
+	if !ok || len(object) == 0 {
+		return append(lines, [2]string{prefix, formatValue(value)})
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if prefix != "" {
+			lines = flatten(prefix+"."+key, object[key], lines)
+		} else {
+			lines = flatten(key, object[key], lines)
+		}
+	}
+	return lines
+}
+
+// formatValue renders a decoded JSON value on one line
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "-"
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return fmt.Sprint(v)
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func (a *app) printProperty(value PropertyValue) {
+	frozen := ""
+	if value.Frozen {
+		frozen = "  (frozen)"
+	}
+	fmt.Fprintf(a.out, "%s = %s  [%s: %s]%s\n", value.Name, formatValue(value.Value), value.Address, value.Raw, frozen)
+}
+
+// printMessage writes a stream message as one line, or as compact JSON
+func (a *app) printMessage(message wsMessage) {
+	if a.json {
+		fmt.Fprintln(a.out, string(message.raw))
+		return
+	}
+
+	data := string(message.Data)
+	if len(data) > 160 {
+		data = data[:157] + "..."
+	}
+	seq := ""
+	if message.Seq != 0 {
+		seq = fmt.Sprintf(" #%d", message.Seq)
+	}
+	fmt.Fprintf(a.out, "%s %s%s %s\n", message.Timestamp.Local().Format("15:04:05.000"), message.Type, seq, data)
+}
+
+// printHexDump writes 16 bytes per line with their address and printable
+// characters
+func (a *app) printHexDump(start uint32, data []byte) {
+	for offset := 0; offset < len(data); offset += 16 {
+		line := data[offset:min(offset+16, len(data))]
+
+		var hexPart, textPart strings.Builder
+		for i := 0; i < 16; i++ {
+			if i == 8 {
+				hexPart.WriteByte(' ')
+			}
+			if i >= len(line) {
+				hexPart.WriteString("   ")
+				continue
+			}
+			fmt.Fprintf(&hexPart, "%02X ", line[i])
+			if line[i] >= 0x20 && line[i] < 0x7F {
+				textPart.WriteByte(line[i])
+			} else {
+				textPart.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(a.out, "%04X  %s |%s|\n", start+uint32(offset), hexPart.String(), textPart.String())
+	}
+}
+
+func (a *app) printSearch(info map[string]interface{}) {
+	filters := 0
+	if list, ok := info["filters"].([]interface{}); ok {
+		filters = len(list)
+	}
+	fmt.Fprintf(a.out, "search %s  %s  %s candidates after %d filter(s)\n",
+		formatValue(info["id"]), formatValue(info["type"]), formatValue(info["candidates"]), filters)
+}
+
+func (a *app) printResults(page searchPage) {
+	fmt.Fprintf(a.out, "search %s  %s  %d candidates, showing %d from %d\n", page.ID, page.Type, page.Candidates, len(page.Results), page.Offset)
+	for _, result := range page.Results {
+		if result.Previous != nil {
+			fmt.Fprintf(a.out, "0x%04X  %d  (was %d)\n", result.Address, result.Value, *result.Previous)
+		} else {
+			fmt.Fprintf(a.out, "0x%04X  %d\n", result.Address, result.Value)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"RetroGameAnalysis/server"
+	"github.com/gorilla/mux"
+)
+
+// ErrorDriver is reported when RetroArch rejects or fails a read or write
+const ErrorDriver = "driver_error"
+
+// maxMemoryRead bounds a single raw memory read
+const maxMemoryRead = 0x10000
+
+// safeNamePattern restricts the names of recordings, snapshots and custom
+// UIs to ones that are safe as file names and in URLs
+var safeNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// Address is a memory address that decodes from a JSON number or a string such as "0xD158"
+type Address uint32
+
+// UnmarshalJSON accepts numbers and decimal or 0x-prefixed strings
+func (a *Address) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	number, err := toUint(value)
+	if err != nil {
+		return err
+	}
+	if number > 0xFFFFFFFF {
+		return fmt.Errorf("address %d out of range", number)
+	}
+	*a = Address(number)
+	return nil
+}
+
+// PropertyValue is a property's current value as read from memory
+type PropertyValue struct {
+	Name    string      `json:"name"`
+	Value   interface{} `json:"value"`
+	Raw     string      `json:"raw"`
+	Address string      `json:"address"`
+	Frozen  bool        `json:"frozen"`
+}
+
+// PropertyWrite is a single write in a batch
+type PropertyWrite struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// BatchRequest writes several properties, optionally all-or-nothing
+type BatchRequest struct {
+	Atomic     bool            `json:"atomic"`
+	Properties []PropertyWrite `json:"properties"`
+}
+
+// BatchResult reports the outcome of one write in a batch
+type BatchResult struct {
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// FreezeRequest freezes or releases a property. Without a value the property
+// is frozen at its current value.
+type FreezeRequest struct {
+	Name   string      `json:"name"`
+	Freeze bool        `json:"freeze"`
+	Value  interface{} `json:"value,omitempty"`
+}
+
+// MemoryRange is a block of raw memory
+type MemoryRange struct {
+	Address string `json:"address"`
+	Length  int    `json:"length"`
+	Hex     string `json:"hex"`
+}
+
+// readProperty reads a property's current value from memory
+func (s *Session) readProperty(name string) (*PropertyValue, error) {
+	property, err := lookupProperty(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.driver.ReadMemory(property.Address, property.Length)
+	if err != nil {
+		return nil, server.NewCommandError(ErrorDriver, "failed to read %s: %v", name, err)
+	}
+
+	return &PropertyValue{
+		Name:    property.Name,
+		Value:   property.Decode(data),
+		Raw:     hex.EncodeToString(data),
+		Address: fmt.Sprintf("0x%04X", property.Address),
+		Frozen:  s.freezes.isFrozen(property.Name),
+	}, nil
+}
+
+// writeProperty encodes and writes a property value. A frozen property is
+// re-frozen at the new value so the write sticks.
+func (s *Session) writeProperty(name string, value interface{}) error {
+	property, err := lookupProperty(name)
+	if err != nil {
+		return err
+	}
+
+	data, err := property.Encode(value)
+	if err != nil {
+		return err
+	}
+
+	if err := s.driver.WriteBytes(property.Address, data); err != nil {
+		return server.NewCommandError(ErrorDriver, "failed to write %s: %v", name, err)
+	}
+
+	if s.freezes.isFrozen(property.Name) {
+		s.freezes.set(property, data)
+	}
+	return nil
+}
+
+// freezeProperty freezes a property at a value (or its current value) or releases it
+func (s *Session) freezeProperty(request FreezeRequest) (*PropertyValue, error) {
+	property, err := lookupProperty(request.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !request.Freeze {
+		if s.freezes.release(property.Name) {
+			s.broadcastFreezeChange(property.Name, false, nil)
+		}
+		return s.readProperty(property.Name)
+	}
+
+	var data []byte
+	if request.Value != nil {
+		if data, err = property.Encode(request.Value); err != nil {
+			return nil, err
+		}
+		if err := s.driver.WriteBytes(property.Address, data); err != nil {
+			return nil, server.NewCommandError(ErrorDriver, "failed to write %s: %v", property.Name, err)
+		}
+	} else {
+		if data, err = s.driver.ReadMemory(property.Address, property.Length); err != nil {
+			return nil, server.NewCommandError(ErrorDriver, "failed to read %s: %v", property.Name, err)
+		}
+	}
+
+	s.freezes.set(property, data)
+	s.broadcastFreezeChange(property.Name, true, property.Decode(data))
+
+	return &PropertyValue{
+		Name:    property.Name,
+		Value:   property.Decode(data),
+		Raw:     hex.EncodeToString(data),
+		Address: fmt.Sprintf("0x%04X", property.Address),
+		Frozen:  true,
+	}, nil
+}
+
+// applyFreezes rewrites every frozen property. It runs at the start of each poll tick.
+func (s *Session) applyFreezes() {
+	for _, frozen := range s.freezes.writes() {
+		if err := s.driver.WriteBytes(frozen.property.Address, frozen.data); err != nil {
+			log.Printf("⚠️  [%s] Failed to apply freeze for %s: %v", s.id, frozen.property.Name, err)
+		}
+	}
+}
+
+// releaseFreezes unfreezes every property, e.g. on shutdown
+func (s *Session) releaseFreezes() {
+	for _, name := range s.freezes.releaseAll() {
+		log.Printf("🧊 [%s] Released freeze on %s", s.id, name)
+		s.broadcastFreezeChange(name, false, nil)
+	}
+}
+
+func (s *Session) broadcastFreezeChange(name string, frozen bool, value interface{}) {
+	s.wsManager.BroadcastMessage(server.Message{
+		Type: "property_freeze_changed",
+		Data: map[string]interface{}{
+			"property": name,
+			"frozen":   frozen,
+			"value":    value,
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+// batchWrite writes several properties. Every value is validated before anything
+// is written. With atomic set, a failed write rolls back the writes before it.
+func (s *Session) batchWrite(request BatchRequest) ([]BatchResult, error) {
+	if len(request.Properties) == 0 {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "batch contains no properties")
+	}
+
+	type plannedWrite struct {
+		property *Property
+		data     []byte
+		previous []byte
+	}
+
+	results := make([]BatchResult, len(request.Properties))
+	planned := make([]*plannedWrite, len(request.Properties))
+
+	for i, write := range request.Properties {
+		results[i].Name = write.Name
+
+		property, err := lookupProperty(write.Name)
+		if err == nil {
+			var data []byte
+			if data, err = property.Encode(write.Value); err == nil {
+				planned[i] = &plannedWrite{property: property, data: data}
+				continue
+			}
+		}
+
+		if request.Atomic {
+			return nil, err
+		}
+		results[i].Error = err.Error()
+	}
+
+	if request.Atomic {
+		// Remember the current bytes so a failed write can be rolled back
+		for _, write := range planned {
+			previous, err := s.driver.ReadMemory(write.property.Address, write.property.Length)
+			if err != nil {
+				return nil, server.NewCommandError(ErrorDriver, "failed to read %s: %v", write.property.Name, err)
+			}
+			write.previous = previous
+		}
+	}
+
+	for i, write := range planned {
+		if write == nil {
+			continue
+		}
+
+		if err := s.driver.WriteBytes(write.property.Address, write.data); err != nil {
+			if request.Atomic {
+				for j := i - 1; j >= 0; j-- {
+					if rollbackErr := s.driver.WriteBytes(planned[j].property.Address, planned[j].previous); rollbackErr != nil {
+						log.Printf("⚠️  Failed to roll back %s: %v", planned[j].property.Name, rollbackErr)
+					}
+				}
+				return nil, server.NewCommandError(ErrorDriver, "failed to write %s, batch rolled back: %v", write.property.Name, err)
+			}
+			results[i].Error = err.Error()
+			continue
+		}
+
+		if s.freezes.isFrozen(write.property.Name) {
+			s.freezes.set(write.property, write.data)
+		}
+		results[i].Success = true
+	}
+
+	return results, nil
+}
+
+// readMemoryRange reads raw memory
+func (s *Session) readMemoryRange(address uint32, length int) (*MemoryRange, error) {
+	data, err := s.readMemoryBytes(address, length)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MemoryRange{
+		Address: fmt.Sprintf("0x%04X", address),
+		Length:  length,
+		Hex:     hex.EncodeToString(data),
+	}, nil
+}
+
+// readMemoryBytes reads up to maxMemoryRead bytes of raw memory
+func (s *Session) readMemoryBytes(address uint32, length int) ([]byte, error) {
+	if length <= 0 || length > maxMemoryRead {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "length must be between 1 and %d", maxMemoryRead)
+	}
+
+	data, err := s.driver.ReadMemory(address, uint32(length))
+	if err != nil {
+		return nil, server.NewCommandError(ErrorDriver, "failed to read memory at 0x%04X: %v", address, err)
+	}
+	return data, nil
+}
+
+// registerCommands exposes the property and memory operations over WebSocket
+func (s *Session) registerCommands() {
+	s.wsManager.RegisterCommand("list_properties", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{
+			"properties": properties,
+			"frozen":     s.freezes.list(),
+		}, nil
+	})
+
+	s.wsManager.RegisterCommand("read_property", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request struct {
+			Name string `json:"name"`
+		}
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		return s.readProperty(request.Name)
+	})
+
+	s.wsManager.RegisterCommand("write_property", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request PropertyWrite
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		if err := s.writeProperty(request.Name, request.Value); err != nil {
+			return nil, err
+		}
+		return s.readProperty(request.Name)
+	})
+
+	s.wsManager.RegisterCommand("freeze_property", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request FreezeRequest
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		return s.freezeProperty(request)
+	})
+
+	s.wsManager.RegisterCommand("batch_write", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request BatchRequest
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		results, err := s.batchWrite(request)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"results": results}, nil
+	})
+
+	s.wsManager.RegisterCommand("read_memory", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request struct {
+			Address Address `json:"address"`
+			Length  int     `json:"length"`
+		}
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		return s.readMemoryRange(uint32(request.Address), request.Length)
+	})
+}
+
+// decodeParams decodes a command's data into target
+func decodeParams(params json.RawMessage, target interface{}) error {
+	if len(params) == 0 {
+		return server.NewCommandError(server.ErrorInvalidRequest, "missing data")
+	}
+	if err := json.Unmarshal(params, target); err != nil {
+		return server.NewCommandError(server.ErrorInvalidRequest, "invalid data: %v", err)
+	}
+	return nil
+}
+
+// decodeOptionalBody decodes a request body into target. An empty body
+// leaves target unchanged, whether or not the request declared its length.
+func decodeOptionalBody(r *http.Request, target interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(target); err != nil && err != io.EOF {
+		return server.NewCommandError(server.ErrorInvalidRequest, "invalid request body: %v", err)
+	}
+	return nil
+}
+
+// REST handlers for the same operations
+
+func (s *Session) handleListProperties(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"properties": properties,
+		"frozen":     s.freezes.list(),
+	})
+}
+
+func (s *Session) handleGetProperty(w http.ResponseWriter, r *http.Request) {
+	value, err := s.readProperty(mux.Vars(r)["name"])
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func (s *Session) handleSetProperty(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var request struct {
+		Value interface{} `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.writeProperty(name, request.Value); err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	s.handleGetProperty(w, r)
+}
+
+func (s *Session) handleFreezeProperty(w http.ResponseWriter, r *http.Request) {
+	var request FreezeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	request.Name = mux.Vars(r)["name"]
+
+	value, err := s.freezeProperty(request)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func (s *Session) handleBatchWrite(w http.ResponseWriter, r *http.Request) {
+	var request BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	results, err := s.batchWrite(request)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
+
+// writeCommandError maps a typed command error onto an HTTP status
+func writeCommandError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	var commandErr *server.CommandError
+	if !errors.As(err, &commandErr) {
+		commandErr = &server.CommandError{Type: server.ErrorInternal, Message: err.Error()}
+	} else {
+		switch commandErr.Type {
+		case server.ErrorInvalidRequest:
+			status = http.StatusBadRequest
+		case server.ErrorNotFound:
+			status = http.StatusNotFound
+		case server.ErrorUnauthorized:
+			status = http.StatusUnauthorized
+		case server.ErrorForbidden:
+			status = http.StatusForbidden
+		case ErrorDriver:
+			status = http.StatusBadGateway
+		case ErrorConflict:
+			status = http.StatusConflict
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error_type": commandErr.Type,
+		"message":    commandErr.Message,
+	})
+}
@@ -0,0 +1,729 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"RetroGameAnalysis/connection"
+	"RetroGameAnalysis/history"
+	"RetroGameAnalysis/server"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prepended to the upper-cased setting name for environment overrides,
+// e.g. RGA_RETROARCH_HOST overrides retroarch_host
+const envPrefix = "RGA_"
+
+// Duration is a time.Duration that is written as "16ms" in JSON and YAML
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts either a duration string or a number of nanoseconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		parsed, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+
+	var nanos int64
+	if err := json.Unmarshal(data, &nanos); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	*d = Duration(nanos)
+	return nil
+}
+
+// MarshalYAML encodes the duration as a string
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// UnmarshalYAML decodes a duration string
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// APIKeyConfig is an API key entry in the config file
+type APIKeyConfig struct {
+	Name string `json:"name" yaml:"name"`
+	Key  string `json:"key" yaml:"key"`
+	Role string `json:"role" yaml:"role"`
+}
+
+// MarshalJSON encodes the entry with the key redacted, so keys never appear
+// in logs or the /api/config response
+func (k APIKeyConfig) MarshalJSON() ([]byte, error) {
+	type redacted APIKeyConfig
+	entry := redacted(k)
+	entry.Key = "********"
+	return json.Marshal(entry)
+}
+
+// Config holds the server's effective configuration
+type Config struct {
+	// Server
+	Host string `json:"host" yaml:"host"`
+	Port int    `json:"port" yaml:"port"`
+
+	// RetroArch connection
+	RetroArchHost  string   `json:"retroarch_host" yaml:"retroarch_host"`
+	RetroArchPort  int      `json:"retroarch_port" yaml:"retroarch_port"`
+	RequestTimeout Duration `json:"request_timeout" yaml:"request_timeout"`
+	Platform       string   `json:"platform" yaml:"platform"`
+
+	// MemoryBlocks names the RAM captured by snapshots and searched by
+	// default, e.g. {"WRAM": "0xC000-0xDFFF"}; empty uses the platform's RAM
+	MemoryBlocks map[string]string `json:"memory_blocks,omitempty" yaml:"memory_blocks,omitempty"`
+
+	// Performance tuning
+	UpdateInterval Duration          `json:"update_interval" yaml:"update_interval"`
+	PollPriority   map[string]string `json:"poll_priority,omitempty" yaml:"poll_priority,omitempty"`
+
+	// WebSocket delivery
+	WSQueueSize    int      `json:"ws_queue_size" yaml:"ws_queue_size"`
+	WSSlowConsumer string   `json:"ws_slow_consumer" yaml:"ws_slow_consumer"`
+	WSCompression  bool     `json:"ws_compression" yaml:"ws_compression"`
+	WSResumeGrace  Duration `json:"ws_resume_grace" yaml:"ws_resume_grace"`
+	WSReplaySize   int      `json:"ws_replay_size" yaml:"ws_replay_size"`
+
+	// Access control
+	APIKeys        []APIKeyConfig `json:"api_keys,omitempty" yaml:"api_keys,omitempty"`
+	AllowedOrigins []string       `json:"allowed_origins,omitempty" yaml:"allowed_origins,omitempty"`
+
+	// Sessions are additional emulators monitored next to the default one.
+	// Unset fields fall back to the top-level RetroArch and polling settings.
+	Sessions []SessionConfig `json:"sessions,omitempty" yaml:"sessions,omitempty"`
+
+	// Timeline recording and replay
+	RecordKeyframeInterval Duration `json:"record_keyframe_interval" yaml:"record_keyframe_interval"`
+	Replay                 string   `json:"replay,omitempty" yaml:"replay,omitempty"`
+	ReplaySpeed            float64  `json:"replay_speed" yaml:"replay_speed"`
+	ReplayLoop             bool     `json:"replay_loop" yaml:"replay_loop"`
+
+	// Autosplitter: a split definition file and the LiveSplit Server the
+	// timer is mirrored to, both off when empty
+	Splits    string `json:"splits,omitempty" yaml:"splits,omitempty"`
+	LiveSplit string `json:"livesplit,omitempty" yaml:"livesplit,omitempty"`
+
+	// Property history
+	HistorySize      int      `json:"history_size" yaml:"history_size"`
+	HistoryDir       string   `json:"history_dir,omitempty" yaml:"history_dir,omitempty"`
+	HistoryRetention Duration `json:"history_retention" yaml:"history_retention"`
+
+	// Directories
+	MappersDir    string `json:"mappers_dir" yaml:"mappers_dir"`
+	UIsDir        string `json:"uis_dir" yaml:"uis_dir"`
+	OverlaysDir   string `json:"overlays_dir" yaml:"overlays_dir"`
+	RecordingsDir string `json:"recordings_dir" yaml:"recordings_dir"`
+	ScriptsDir    string `json:"scripts_dir" yaml:"scripts_dir"`
+
+	// ConfigFile is the file the configuration was loaded from, if any
+	ConfigFile string `json:"config_file,omitempty" yaml:"-"`
+}
+
+// DefaultConfig returns the built-in configuration
+func DefaultConfig() *Config {
+	return &Config{
+		Host:                   "0.0.0.0",
+		Port:                   8080,
+		RetroArchHost:          "localhost",
+		RetroArchPort:          55355,
+		RequestTimeout:         Duration(5 * time.Second),
+		Platform:               "GB",
+		UpdateInterval:         Duration(16 * time.Millisecond),
+		WSQueueSize:            256,
+		WSSlowConsumer:         string(server.PolicyResync),
+		WSResumeGrace:          Duration(30 * time.Second),
+		WSReplaySize:           1024,
+		RecordKeyframeInterval: Duration(time.Minute),
+		ReplaySpeed:            1,
+		HistorySize:            4096,
+		HistoryRetention:       Duration(7 * 24 * time.Hour),
+		MappersDir:             "./mappers",
+		UIsDir:                 "./uis",
+		OverlaysDir:            "./overlays",
+		RecordingsDir:          "./recordings",
+		ScriptsDir:             "./scripts",
+	}
+}
+
+// LoadConfig builds the effective configuration from, in increasing order of
+// precedence: built-in defaults, a YAML/JSON config file, RGA_* environment
+// variables and command-line flags
+func LoadConfig(args []string) (*Config, error) {
+	defaults := DefaultConfig()
+
+	fs := flag.NewFlagSet("RetroGameAnalysis", flag.ContinueOnError)
+	configFile := fs.String("config", "", "Path to a YAML or JSON config file (env "+envPrefix+"CONFIG)")
+	fs.String("host", defaults.Host, "Web server host")
+	fs.Int("port", defaults.Port, "Web server port")
+	fs.String("retroarch-host", defaults.RetroArchHost, "RetroArch host")
+	fs.Int("retroarch-port", defaults.RetroArchPort, "RetroArch UDP port")
+	fs.Duration("request-timeout", time.Duration(defaults.RequestTimeout), "RetroArch request timeout")
+	fs.String("platform", defaults.Platform, "Emulated platform used to tune chunk sizes")
+	fs.String("memory-blocks", "", "Memory blocks for snapshots and RAM search, e.g. WRAM=0xC000-0xDFFF,SRAM=0xA000-0xBFFF")
+	fs.Duration("update-interval", time.Duration(defaults.UpdateInterval), "Property monitoring rate")
+	fs.String("poll-priority", "", "Poll priority overrides, e.g. party=fast,bag=slow")
+	fs.Int("ws-queue-size", defaults.WSQueueSize, "Messages queued per WebSocket client before the slow-consumer policy applies")
+	fs.String("ws-slow-consumer", defaults.WSSlowConsumer, "Slow WebSocket client policy: conflate, resync or disconnect")
+	fs.Bool("ws-compression", defaults.WSCompression, "Enable permessage-deflate for WebSocket clients that offer it")
+	fs.Duration("ws-resume-grace", time.Duration(defaults.WSResumeGrace), "How long a disconnected WebSocket client can resume its session (0 disables)")
+	fs.Int("ws-replay-size", defaults.WSReplaySize, "Diffs kept for resumed WebSocket clients")
+	fs.String("api-keys", "", "API keys as role:key pairs, e.g. read:abc123,admin:s3cret (roles: read, write, admin)")
+	fs.String("allowed-origins", "", "Cross-origin pages allowed to use the API, e.g. http://localhost:3000 (* allows any)")
+	fs.String("mappers-dir", defaults.MappersDir, "Mapper definitions directory, e.g. the {mapper}.ui.yaml UI schemas")
+	fs.String("uis-dir", defaults.UIsDir, "Custom web UI directory, one UI per subdirectory")
+	fs.String("overlays-dir", defaults.OverlaysDir, "Directory of overlay templates, which add to or replace the built-in ones")
+	fs.String("recordings-dir", defaults.RecordingsDir, "Directory for recorded session timelines")
+	fs.String("scripts-dir", defaults.ScriptsDir, "Directory of Starlark automation scripts, reloaded as they change")
+	fs.Duration("record-keyframe-interval", time.Duration(defaults.RecordKeyframeInterval), "Longest time between full-state keyframes in recordings")
+	fs.String("replay", "", "Play back a memory capture instead of connecting to RetroArch")
+	fs.Float64("replay-speed", defaults.ReplaySpeed, "Replay playback speed, e.g. 0.5 or 4")
+	fs.Bool("replay-loop", defaults.ReplayLoop, "Restart the replay when it reaches the end")
+	fs.String("splits", "", "Autosplitter definition file (YAML or JSON)")
+	fs.String("livesplit", "", "LiveSplit Server address to mirror the autosplitter to, e.g. localhost:16834")
+	fs.Int("history-size", defaults.HistorySize, "Samples kept in memory per property history")
+	fs.String("history-dir", defaults.HistoryDir, "Directory for on-disk property history (empty disables)")
+	fs.Duration("history-retention", time.Duration(defaults.HistoryRetention), "How long on-disk property history is kept")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaults
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// Environment variables use the flag names, e.g. RGA_RETROARCH_HOST
+	var applyErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || applyErr != nil {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if err := cfg.set(f.Name, value); err != nil {
+				applyErr = fmt.Errorf("invalid %s: %w", envName(f.Name), err)
+			}
+		}
+	})
+
+	// Only flags given explicitly override the file and environment
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" || applyErr != nil {
+			return
+		}
+		if err := cfg.set(f.Name, f.Value.String()); err != nil {
+			applyErr = fmt.Errorf("invalid --%s: %w", f.Name, err)
+		}
+	})
+	if applyErr != nil {
+		return nil, applyErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// envName returns the environment variable that overrides a flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadFile merges a YAML or JSON config file into the configuration
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, c)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("unsupported config file type %q (expected .json, .yaml or .yml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	c.ConfigFile = path
+	return nil
+}
+
+// set applies a single setting given by its flag name
+func (c *Config) set(name, value string) error {
+	switch name {
+	case "host":
+		c.Host = value
+	case "port":
+		return parseInt(value, &c.Port)
+	case "retroarch-host":
+		c.RetroArchHost = value
+	case "retroarch-port":
+		return parseInt(value, &c.RetroArchPort)
+	case "request-timeout":
+		return parseDuration(value, &c.RequestTimeout)
+	case "platform":
+		c.Platform = value
+	case "memory-blocks":
+		blocks, err := ParseMemoryBlocks(value)
+		if err != nil {
+			return err
+		}
+		c.MemoryBlocks = blocks
+	case "update-interval":
+		return parseDuration(value, &c.UpdateInterval)
+	case "poll-priority":
+		priorities, err := ParsePollPriorities(value)
+		if err != nil {
+			return err
+		}
+		c.PollPriority = make(map[string]string, len(priorities))
+		for group, priority := range priorities {
+			c.PollPriority[group] = priority.String()
+		}
+	case "ws-queue-size":
+		return parseInt(value, &c.WSQueueSize)
+	case "ws-slow-consumer":
+		c.WSSlowConsumer = value
+	case "ws-compression":
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		c.WSCompression = enabled
+	case "ws-resume-grace":
+		return parseDuration(value, &c.WSResumeGrace)
+	case "ws-replay-size":
+		return parseInt(value, &c.WSReplaySize)
+	case "api-keys":
+		keys, err := parseAPIKeys(value)
+		if err != nil {
+			return err
+		}
+		c.APIKeys = keys
+	case "allowed-origins":
+		c.AllowedOrigins = splitList(value)
+	case "mappers-dir":
+		c.MappersDir = value
+	case "uis-dir":
+		c.UIsDir = value
+	case "overlays-dir":
+		c.OverlaysDir = value
+	case "recordings-dir":
+		c.RecordingsDir = value
+	case "scripts-dir":
+		c.ScriptsDir = value
+	case "splits":
+		c.Splits = value
+	case "livesplit":
+		c.LiveSplit = value
+	case "record-keyframe-interval":
+		return parseDuration(value, &c.RecordKeyframeInterval)
+	case "replay":
+		c.Replay = value
+	case "replay-speed":
+		speed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		c.ReplaySpeed = speed
+	case "replay-loop":
+		loop, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		c.ReplayLoop = loop
+	case "history-size":
+		return parseInt(value, &c.HistorySize)
+	case "history-dir":
+		c.HistoryDir = value
+	case "history-retention":
+		return parseDuration(value, &c.HistoryRetention)
+	default:
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	return nil
+}
+
+// parseAPIKeys parses comma-separated role:key pairs
+func parseAPIKeys(value string) ([]APIKeyConfig, error) {
+	var keys []APIKeyConfig
+	for i, entry := range splitList(value) {
+		role, key, ok := strings.Cut(entry, ":")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid API key entry %d: expected role:key", i+1)
+		}
+		keys = append(keys, APIKeyConfig{
+			Name: fmt.Sprintf("%s-%d", strings.TrimSpace(role), i+1),
+			Key:  key,
+			Role: role,
+		})
+	}
+	return keys, nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseInt(value string, target *int) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*target = parsed
+	return nil
+}
+
+func parseDuration(value string, target *Duration) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*target = Duration(parsed)
+	return nil
+}
+
+// Validate checks that the configuration is usable
+func (c *Config) Validate() error {
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid port %d", c.Port)
+	}
+
+	sessions := c.SessionConfigs()
+	ids := make(map[string]bool, len(sessions))
+	endpoints := make(map[string]string, len(sessions))
+	for _, session := range sessions {
+		if err := session.Validate(); err != nil {
+			return fmt.Errorf("session %q: %w", session.ID, err)
+		}
+		if ids[session.ID] {
+			return fmt.Errorf("session %q is configured twice", session.ID)
+		}
+		ids[session.ID] = true
+		if session.Replay != "" {
+			continue
+		}
+		if other, ok := endpoints[session.Endpoint()]; ok {
+			return fmt.Errorf("sessions %q and %q both use RetroArch at %s", other, session.ID, session.Endpoint())
+		}
+		endpoints[session.Endpoint()] = session.ID
+	}
+
+	if c.WSQueueSize <= 0 {
+		return fmt.Errorf("invalid WebSocket queue size %d: must be positive", c.WSQueueSize)
+	}
+	if _, err := server.ParseSlowConsumerPolicy(c.WSSlowConsumer); err != nil {
+		return err
+	}
+	if c.WSResumeGrace < 0 {
+		return fmt.Errorf("invalid WebSocket resume grace %v: must not be negative", time.Duration(c.WSResumeGrace))
+	}
+	if c.WSReplaySize <= 0 {
+		return fmt.Errorf("invalid WebSocket replay size %d: must be positive", c.WSReplaySize)
+	}
+	if _, err := c.AccessControl(); err != nil {
+		return err
+	}
+	if c.RecordKeyframeInterval <= 0 {
+		return fmt.Errorf("invalid recording keyframe interval %v: must be positive", time.Duration(c.RecordKeyframeInterval))
+	}
+	if c.HistorySize <= 0 {
+		return fmt.Errorf("invalid history size %d: must be positive", c.HistorySize)
+	}
+	if c.HistoryRetention < 0 {
+		return fmt.Errorf("invalid history retention %v: must not be negative", time.Duration(c.HistoryRetention))
+	}
+	return nil
+}
+
+// AccessControl builds the API key and origin rules
+func (c *Config) AccessControl() (*server.AccessControl, error) {
+	keys := make([]server.APIKey, 0, len(c.APIKeys))
+	for _, entry := range c.APIKeys {
+		role, err := server.ParseRole(entry.Role)
+		if err != nil {
+			return nil, fmt.Errorf("API key %q: %w", entry.Name, err)
+		}
+		keys = append(keys, server.APIKey{Name: entry.Name, Key: entry.Key, Role: role})
+	}
+	return server.NewAccessControl(keys, c.AllowedOrigins, c.Address())
+}
+
+// ManagerConfig returns the WebSocket delivery settings
+func (c *Config) ManagerConfig() server.ManagerConfig {
+	policy, _ := server.ParseSlowConsumerPolicy(c.WSSlowConsumer)
+	return server.ManagerConfig{
+		QueueSize:          c.WSQueueSize,
+		SlowConsumerPolicy: policy,
+		Compression:        c.WSCompression,
+		ResumeGrace:        time.Duration(c.WSResumeGrace),
+		ReplaySize:         c.WSReplaySize,
+	}
+}
+
+// RecordingSettings returns the timeline recording settings
+func (c *Config) RecordingSettings() RecordingSettings {
+	return RecordingSettings{
+		Dir:              c.RecordingsDir,
+		KeyframeInterval: time.Duration(c.RecordKeyframeInterval),
+	}
+}
+
+// HistoryOptions returns the property history settings for a session. Each
+// session keeps its on-disk history in its own subdirectory.
+func (c *Config) HistoryOptions(sessionID string) history.Options {
+	options := history.Options{
+		Capacity:  c.HistorySize,
+		Retention: time.Duration(c.HistoryRetention),
+	}
+	if c.HistoryDir != "" {
+		options.Dir = filepath.Join(c.HistoryDir, sessionID)
+	}
+	return options
+}
+
+// SessionConfig describes one emulator session: its RetroArch instance, the
+// mapper that interprets its memory and how often it is polled
+type SessionConfig struct {
+	ID             string            `json:"id" yaml:"id"`
+	Mapper         string            `json:"mapper,omitempty" yaml:"mapper,omitempty"`
+	RetroArchHost  string            `json:"retroarch_host,omitempty" yaml:"retroarch_host,omitempty"`
+	RetroArchPort  int               `json:"retroarch_port,omitempty" yaml:"retroarch_port,omitempty"`
+	RequestTimeout Duration          `json:"request_timeout,omitempty" yaml:"request_timeout,omitempty"`
+	Platform       string            `json:"platform,omitempty" yaml:"platform,omitempty"`
+	UpdateInterval Duration          `json:"update_interval,omitempty" yaml:"update_interval,omitempty"`
+	PollPriority   map[string]string `json:"poll_priority,omitempty" yaml:"poll_priority,omitempty"`
+	MemoryBlocks   map[string]string `json:"memory_blocks,omitempty" yaml:"memory_blocks,omitempty"`
+	MappersDir     string            `json:"mappers_dir,omitempty" yaml:"mappers_dir,omitempty"`
+	ScriptsDir     string            `json:"scripts_dir,omitempty" yaml:"scripts_dir,omitempty"`
+	Splits         string            `json:"splits,omitempty" yaml:"splits,omitempty"`
+	LiveSplit      string            `json:"livesplit,omitempty" yaml:"livesplit,omitempty"`
+
+	// Replay plays back a memory capture instead of connecting to RetroArch
+	Replay      string  `json:"replay,omitempty" yaml:"replay,omitempty"`
+	ReplaySpeed float64 `json:"replay_speed,omitempty" yaml:"replay_speed,omitempty"`
+	ReplayLoop  bool    `json:"replay_loop,omitempty" yaml:"replay_loop,omitempty"`
+}
+
+// DefaultSession returns the session described by the top-level settings,
+// which the unscoped /api routes and /ws use
+func (c *Config) DefaultSession() SessionConfig {
+	return SessionConfig{
+		ID:             DefaultSessionID,
+		Mapper:         DefaultMapper,
+		RetroArchHost:  c.RetroArchHost,
+		RetroArchPort:  c.RetroArchPort,
+		RequestTimeout: c.RequestTimeout,
+		Platform:       c.Platform,
+		UpdateInterval: c.UpdateInterval,
+		PollPriority:   c.PollPriority,
+		MemoryBlocks:   c.MemoryBlocks,
+		MappersDir:     c.MappersDir,
+		ScriptsDir:     c.ScriptsDir,
+		Splits:         c.Splits,
+		LiveSplit:      c.LiveSplit,
+		Replay:         c.Replay,
+		ReplaySpeed:    c.ReplaySpeed,
+		ReplayLoop:     c.ReplayLoop,
+	}
+}
+
+// SessionConfigs returns the default session followed by the configured
+// sessions, with unset fields filled in
+func (c *Config) SessionConfigs() []SessionConfig {
+	sessions := []SessionConfig{c.DefaultSession()}
+	for _, session := range c.Sessions {
+		sessions = append(sessions, c.WithSessionDefaults(session))
+	}
+	return sessions
+}
+
+// WithSessionDefaults fills a session's unset fields from the top-level settings
+func (c *Config) WithSessionDefaults(session SessionConfig) SessionConfig {
+	defaults := c.DefaultSession()
+	if session.Mapper == "" {
+		session.Mapper = defaults.Mapper
+	}
+	if session.RetroArchHost == "" {
+		session.RetroArchHost = defaults.RetroArchHost
+	}
+	if session.RetroArchPort == 0 {
+		session.RetroArchPort = defaults.RetroArchPort
+	}
+	if session.RequestTimeout == 0 {
+		session.RequestTimeout = defaults.RequestTimeout
+	}
+	if session.Platform == "" {
+		session.Platform = defaults.Platform
+	}
+	if session.UpdateInterval == 0 {
+		session.UpdateInterval = defaults.UpdateInterval
+	}
+	if session.PollPriority == nil {
+		session.PollPriority = defaults.PollPriority
+	}
+	if session.MemoryBlocks == nil {
+		session.MemoryBlocks = defaults.MemoryBlocks
+	}
+	if session.MappersDir == "" {
+		session.MappersDir = defaults.MappersDir
+	}
+	if session.ScriptsDir == "" {
+		session.ScriptsDir = defaults.ScriptsDir
+	}
+	if session.Splits == "" {
+		session.Splits = defaults.Splits
+	}
+	if session.LiveSplit == "" {
+		session.LiveSplit = defaults.LiveSplit
+	}
+	if session.ReplaySpeed == 0 {
+		session.ReplaySpeed = 1
+	}
+	return session
+}
+
+// Validate checks that the session is usable
+func (s SessionConfig) Validate() error {
+	if !sessionIDPattern.MatchString(s.ID) {
+		return fmt.Errorf("invalid session ID %q: use 1-32 lowercase letters, digits, '-' or '_'", s.ID)
+	}
+	if s.Mapper != DefaultMapper {
+		return fmt.Errorf("unknown mapper %q (available: %s)", s.Mapper, DefaultMapper)
+	}
+	if s.RetroArchPort <= 0 || s.RetroArchPort > 65535 {
+		return fmt.Errorf("invalid RetroArch port %d", s.RetroArchPort)
+	}
+	if s.RequestTimeout <= 0 {
+		return fmt.Errorf("invalid request timeout %v: must be positive", time.Duration(s.RequestTimeout))
+	}
+	if s.UpdateInterval <= 0 {
+		return fmt.Errorf("invalid update interval %v: must be positive", time.Duration(s.UpdateInterval))
+	}
+	if _, err := s.PollPriorities(); err != nil {
+		return err
+	}
+	if _, err := s.Blocks(); err != nil {
+		return err
+	}
+	if s.LiveSplit != "" {
+		if _, _, err := net.SplitHostPort(s.LiveSplit); err != nil {
+			return fmt.Errorf("invalid LiveSplit address %q: %v", s.LiveSplit, err)
+		}
+	}
+	if s.Replay != "" && (s.ReplaySpeed <= 0 || s.ReplaySpeed > connection.MaxReplaySpeed) {
+		return fmt.Errorf("invalid replay speed %g: must be above 0 and at most %d", s.ReplaySpeed, connection.MaxReplaySpeed)
+	}
+	return nil
+}
+
+// Endpoint returns the RetroArch host:port the session talks to, or the
+// capture it replays
+func (s SessionConfig) Endpoint() string {
+	if s.Replay != "" {
+		return "replay:" + s.Replay
+	}
+	return fmt.Sprintf("%s:%d", s.RetroArchHost, s.RetroArchPort)
+}
+
+// Source describes where the session's memory comes from, for logging
+func (s SessionConfig) Source() string {
+	if s.Replay != "" {
+		return "replay of " + s.Replay
+	}
+	return "RetroArch at " + s.Endpoint()
+}
+
+// PollPriorities returns the parsed poll priority overrides
+func (s SessionConfig) PollPriorities() (map[string]PollPriority, error) {
+	priorities := make(map[string]PollPriority, len(s.PollPriority))
+	for group, name := range s.PollPriority {
+		if !isPollGroup(group) {
+			return nil, fmt.Errorf("unknown poll group %q", group)
+		}
+		priority, err := ParsePollPriority(name)
+		if err != nil {
+			return nil, err
+		}
+		priorities[group] = priority
+	}
+	return priorities, nil
+}
+
+// Blocks returns the configured memory blocks sorted by address
+func (s SessionConfig) Blocks() ([]connection.MemoryBlock, error) {
+	blocks := make([]connection.MemoryBlock, 0, len(s.MemoryBlocks))
+	for name, value := range s.MemoryBlocks {
+		block, err := parseMemoryBlock(name, value)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Start < blocks[j].Start })
+	for i := 1; i < len(blocks); i++ {
+		if blocks[i].Start <= blocks[i-1].End {
+			return nil, fmt.Errorf("memory blocks %s and %s overlap", blocks[i-1].Name, blocks[i].Name)
+		}
+	}
+	return blocks, nil
+}
+
+// Address returns the host:port the web server listens on
+func (c *Config) Address() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// String renders the configuration for startup logging
+func (c *Config) String() string {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%+v", *c)
+	}
+	return string(data)
+}
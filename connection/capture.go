@@ -0,0 +1,397 @@
+package connection
+
+import (
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"RetroGameAnalysis/recording"
+)
+
+// CaptureVersion is written in every capture header
+const CaptureVersion = 1
+
+// CaptureExtension is the file extension of memory captures
+const CaptureExtension = ".capture.gz"
+
+// Capture entry types
+const (
+	captureHeader   = "header"
+	captureKeyframe = "keyframe"
+	captureDelta    = "delta"
+	captureEnd      = "end"
+)
+
+// captureEntry is one line of a capture: gzip-compressed JSON lines holding
+// the memory a driver read, keyed by the time since the capture started
+type captureEntry struct {
+	Type   string        `json:"type"`
+	T      time.Duration `json:"t"`
+	Memory []memoryPatch `json:"memory,omitempty"`
+
+	// Header fields
+	Version  int       `json:"version,omitempty"`
+	Platform string    `json:"platform,omitempty"`
+	Started  time.Time `json:"started,omitempty"`
+}
+
+// memoryPatch is a run of bytes at an address
+type memoryPatch struct {
+	Address uint32 `json:"address"`
+	Data    string `json:"data"` // Hex
+}
+
+// CaptureOptions configures a capture
+type CaptureOptions struct {
+	// Platform is stored in the header so replays can report it
+	Platform string
+
+	// KeyframeInterval is the longest time between full memory keyframes
+	KeyframeInterval time.Duration
+}
+
+// CaptureStats reports a capture's progress
+type CaptureStats struct {
+	Path      string    `json:"path"`
+	Started   time.Time `json:"started"`
+	Duration  string    `json:"duration"`
+	Keyframes uint64    `json:"keyframes"`
+	Deltas    uint64    `json:"deltas"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// CaptureDriver wraps a driver and, while a capture is running, writes the
+// memory returned by every read to a capture file that a ReplayDriver can
+// play back. Only bytes that changed since the last read of the same address
+// are written, plus a keyframe of all memory seen so far at each interval.
+type CaptureDriver struct {
+	Driver
+
+	mu      sync.Mutex
+	capture *captureWriter
+}
+
+// NewCaptureDriver wraps driver so its reads can be captured
+func NewCaptureDriver(driver Driver) *CaptureDriver {
+	return &CaptureDriver{Driver: driver}
+}
+
+// Unwrap returns the wrapped driver
+func (d *CaptureDriver) Unwrap() Driver {
+	return d.Driver
+}
+
+// StartCapture begins writing reads to a new capture file at path
+func (d *CaptureDriver) StartCapture(path string, options CaptureOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.capture != nil {
+		return fmt.Errorf("already capturing to %s", d.capture.writer.Path())
+	}
+
+	capture, err := createCapture(path, options)
+	if err != nil {
+		return err
+	}
+	d.capture = capture
+	return nil
+}
+
+// StopCapture finishes the current capture
+func (d *CaptureDriver) StopCapture() (CaptureStats, error) {
+	d.mu.Lock()
+	capture := d.capture
+	d.capture = nil
+	d.mu.Unlock()
+
+	if capture == nil {
+		return CaptureStats{}, fmt.Errorf("not capturing")
+	}
+	err := capture.close()
+	return capture.stats(), err
+}
+
+// CaptureStats returns the current capture's progress, or false when no
+// capture is running
+func (d *CaptureDriver) CaptureStats() (CaptureStats, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.capture == nil {
+		return CaptureStats{}, false
+	}
+	return d.capture.stats(), true
+}
+
+// ReadMemoryBlocks reads through the wrapped driver and captures the result
+func (d *CaptureDriver) ReadMemoryBlocks(blocks []MemoryBlock) (map[uint32][]byte, error) {
+	result, err := d.Driver.ReadMemoryBlocks(blocks)
+	if err == nil {
+		for address, data := range result {
+			d.record(address, data)
+		}
+	}
+	return result, err
+}
+
+// ReadMemory reads through the wrapped driver and captures the result
+func (d *CaptureDriver) ReadMemory(address uint32, length uint32) ([]byte, error) {
+	data, err := d.Driver.ReadMemory(address, length)
+	if err == nil {
+		d.record(address, data)
+	}
+	return data, err
+}
+
+func (d *CaptureDriver) record(address uint32, data []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.capture != nil {
+		d.capture.record(address, data)
+	}
+}
+
+// captureWriter appends reads to a capture file. Writes happen under the
+// CaptureDriver's lock; a ticker flushes the compressor once a second.
+type captureWriter struct {
+	writer *recording.Writer
+
+	mu        sync.Mutex
+	image     *memoryImage
+	started   time.Time
+	keyframes recording.KeyframeClock
+	deltas    uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func createCapture(path string, options CaptureOptions) (*captureWriter, error) {
+	if options.KeyframeInterval <= 0 {
+		options.KeyframeInterval = time.Minute
+	}
+
+	started := time.Now()
+	writer, err := recording.CreateWriter(path, captureEntry{
+		Type:     captureHeader,
+		Version:  CaptureVersion,
+		Platform: options.Platform,
+		Started:  started,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &captureWriter{
+		writer:    writer,
+		image:     newMemoryImage(),
+		started:   started,
+		keyframes: recording.KeyframeClock{Interval: options.KeyframeInterval},
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go c.flushLoop()
+	return c, nil
+}
+
+// record writes the bytes of a read that differ from what was last seen. The
+// first record and each one after the keyframe interval write a keyframe.
+func (c *captureWriter) record(address uint32, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	t := now.Sub(c.started)
+	changed := c.image.write(address, data)
+
+	if c.keyframes.Due(now) {
+		c.writer.Write(captureEntry{Type: captureKeyframe, T: t, Memory: c.image.patches()})
+		c.keyframes.Mark(now)
+		return
+	}
+
+	if !changed {
+		return
+	}
+	c.writer.Write(captureEntry{Type: captureDelta, T: t, Memory: []memoryPatch{{Address: address, Data: hex.EncodeToString(data)}}})
+	c.deltas++
+}
+
+func (c *captureWriter) flushLoop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			c.writer.Flush()
+			c.mu.Unlock()
+
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *captureWriter) close() error {
+	close(c.stop)
+	<-c.done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.writer.Close(captureEntry{Type: captureEnd, T: time.Since(c.started)})
+}
+
+func (c *captureWriter) stats() CaptureStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CaptureStats{
+		Path:      c.writer.Path(),
+		Started:   c.started,
+		Duration:  time.Since(c.started).Round(time.Second).String(),
+		Keyframes: c.keyframes.Count(),
+		Deltas:    c.deltas,
+		Bytes:     c.writer.Bytes(),
+	}
+}
+
+// readCapture loads every entry of a capture file
+func readCapture(path string) (*captureEntry, []captureEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s is not a capture file: %w", path, err)
+	}
+	defer gz.Close()
+
+	decoder := json.NewDecoder(gz)
+	var header captureEntry
+	if err := decoder.Decode(&header); err != nil || header.Type != captureHeader {
+		return nil, nil, fmt.Errorf("%s is not a capture file", path)
+	}
+	if header.Version > CaptureVersion {
+		return nil, nil, fmt.Errorf("%s has capture version %d, newer than the supported %d", path, header.Version, CaptureVersion)
+	}
+
+	var entries []captureEntry
+	for {
+		var entry captureEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// A capture that was not stopped cleanly ends mid-entry; play
+			// back everything before that point
+			if err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if entry.Type == captureKeyframe || entry.Type == captureDelta || entry.Type == captureEnd {
+			entries = append(entries, entry)
+		}
+	}
+	return &header, entries, nil
+}
+
+// memoryPageSize is the granularity of a memory image
+const memoryPageSize = 256
+
+// memoryImage is a sparse copy of emulator memory built from reads
+type memoryImage struct {
+	pages map[uint32]*[memoryPageSize]byte
+}
+
+func newMemoryImage() *memoryImage {
+	return &memoryImage{pages: make(map[uint32]*[memoryPageSize]byte)}
+}
+
+// write stores data at address and reports whether any byte changed
+func (m *memoryImage) write(address uint32, data []byte) bool {
+	changed := false
+	for i, b := range data {
+		addr := address + uint32(i)
+		page, ok := m.pages[addr/memoryPageSize]
+		if !ok {
+			page = new([memoryPageSize]byte)
+			m.pages[addr/memoryPageSize] = page
+			changed = true
+		}
+		if page[addr%memoryPageSize] != b {
+			page[addr%memoryPageSize] = b
+			changed = true
+		}
+	}
+	return changed
+}
+
+// read returns length bytes at address; memory never seen reads as zero
+func (m *memoryImage) read(address uint32, length uint32) []byte {
+	data := make([]byte, length)
+	for i := range data {
+		addr := address + uint32(i)
+		if page, ok := m.pages[addr/memoryPageSize]; ok {
+			data[i] = page[addr%memoryPageSize]
+		}
+	}
+	return data
+}
+
+// apply writes hex-encoded patches into the image
+func (m *memoryImage) apply(patches []memoryPatch) error {
+	for _, patch := range patches {
+		data, err := hex.DecodeString(patch.Data)
+		if err != nil {
+			return fmt.Errorf("invalid data at 0x%X: %w", patch.Address, err)
+		}
+		m.write(patch.Address, data)
+	}
+	return nil
+}
+
+// patches returns the whole image as runs of contiguous pages
+func (m *memoryImage) patches() []memoryPatch {
+	indexes := make([]uint32, 0, len(m.pages))
+	for index := range m.pages {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	var patches []memoryPatch
+	var run []byte
+	var start uint32
+	for i, index := range indexes {
+		if i > 0 && index != indexes[i-1]+1 {
+			patches = append(patches, memoryPatch{Address: start, Data: hex.EncodeToString(run)})
+			run = nil
+		}
+		if run == nil {
+			start = index * memoryPageSize
+		}
+		run = append(run, m.pages[index][:]...)
+	}
+	if run != nil {
+		patches = append(patches, memoryPatch{Address: start, Data: hex.EncodeToString(run)})
+	}
+	return patches
+}
@@ -8,6 +8,9 @@ type Driver interface {
 	// ReadMemoryBlocks reads multiple memory blocks
 	ReadMemoryBlocks(blocks []MemoryBlock) (map[uint32][]byte, error)
 
+	// ReadMemory reads length bytes starting at address
+	ReadMemory(address uint32, length uint32) ([]byte, error)
+
 	// WriteBytes writes data to a specific memory address
 	WriteBytes(address uint32, data []byte) error
 
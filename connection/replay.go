@@ -0,0 +1,282 @@
+package connection
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaxReplaySpeed is the fastest supported playback speed
+const MaxReplaySpeed = 64
+
+// ReplayStatus reports a replay's playback position
+type ReplayStatus struct {
+	Path     string  `json:"path"`
+	Platform string  `json:"platform,omitempty"`
+	Position string  `json:"position"`
+	Seconds  float64 `json:"seconds"`
+	Duration string  `json:"duration"`
+	Length   float64 `json:"length"`
+	Speed    float64 `json:"speed"`
+	Paused   bool    `json:"paused"`
+	Loop     bool    `json:"loop"`
+	Ended    bool    `json:"ended"`
+}
+
+// ReplayDriver serves reads from a capture file as if it were a live
+// emulator. Playback follows the wall clock at the chosen speed and can be
+// paused, sped up, slowed down and seeked. Writes change the replayed memory
+// until the capture next overwrites the same bytes.
+type ReplayDriver struct {
+	path string
+
+	mu        sync.Mutex
+	platform  string
+	entries   []captureEntry
+	keyframes []int // Indexes of keyframe entries
+	length    time.Duration
+	loaded    bool
+
+	// image holds the memory at the time of entries[cursor-1]
+	image  *memoryImage
+	cursor int
+
+	// Playback clock: the position was origin at wall time since and
+	// advances at speed unless paused
+	origin time.Duration
+	since  time.Time
+	speed  float64
+	paused bool
+	loop   bool
+}
+
+// NewReplayDriver creates a driver that plays back the capture at path
+func NewReplayDriver(path string) *ReplayDriver {
+	return &ReplayDriver{path: path, speed: 1, image: newMemoryImage()}
+}
+
+// Connect loads the capture and starts playback from the beginning
+func (d *ReplayDriver) Connect() error {
+	header, entries, err := readCapture(d.path)
+	if err != nil {
+		return err
+	}
+
+	keyframes := make([]int, 0)
+	var length time.Duration
+	for i, entry := range entries {
+		if entry.Type == captureKeyframe {
+			keyframes = append(keyframes, i)
+		}
+		if entry.T > length {
+			length = entry.T
+		}
+	}
+	if len(keyframes) == 0 {
+		return fmt.Errorf("%s has no keyframes", d.path)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.platform = header.Platform
+	d.entries = entries
+	d.keyframes = keyframes
+	d.length = length
+	d.loaded = true
+	d.origin = 0
+	d.since = time.Now()
+	d.seekKeyframe(0)
+	return nil
+}
+
+// Platform returns the platform recorded in the capture
+func (d *ReplayDriver) Platform() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.platform
+}
+
+// ReadMemoryBlocks reads multiple memory blocks at the playback position
+func (d *ReplayDriver) ReadMemoryBlocks(blocks []MemoryBlock) (map[uint32][]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.sync(); err != nil {
+		return nil, err
+	}
+
+	result := make(map[uint32][]byte, len(blocks))
+	for _, block := range blocks {
+		result[block.Start] = d.image.read(block.Start, block.End-block.Start+1)
+	}
+	return result, nil
+}
+
+// ReadMemory reads memory at the playback position
+func (d *ReplayDriver) ReadMemory(address uint32, length uint32) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.sync(); err != nil {
+		return nil, err
+	}
+	return d.image.read(address, length), nil
+}
+
+// WriteBytes changes the replayed memory until the capture overwrites it
+func (d *ReplayDriver) WriteBytes(address uint32, data []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.sync(); err != nil {
+		return err
+	}
+	d.image.write(address, data)
+	return nil
+}
+
+// Close stops playback
+func (d *ReplayDriver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.loaded = false
+	d.entries, d.keyframes = nil, nil
+	d.image = newMemoryImage()
+	d.cursor = 0
+	return nil
+}
+
+// SetSpeed changes the playback speed, e.g. 0.5 for half speed
+func (d *ReplayDriver) SetSpeed(speed float64) error {
+	if speed <= 0 || speed > MaxReplaySpeed {
+		return fmt.Errorf("invalid replay speed %g: must be above 0 and at most %d", speed, MaxReplaySpeed)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.rebase()
+	d.speed = speed
+	return nil
+}
+
+// SetPaused pauses or resumes playback
+func (d *ReplayDriver) SetPaused(paused bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.rebase()
+	d.paused = paused
+}
+
+// SetLoop makes playback restart from the beginning when it reaches the end
+func (d *ReplayDriver) SetLoop(loop bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.rebase()
+	d.loop = loop
+}
+
+// Seek moves playback to a position from the start of the capture
+func (d *ReplayDriver) Seek(position time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.loaded {
+		return fmt.Errorf("replay not loaded")
+	}
+	if position < 0 || position > d.length {
+		return fmt.Errorf("invalid position %v: the capture is %v long", position, d.length)
+	}
+
+	d.origin = position
+	d.since = time.Now()
+	return d.sync()
+}
+
+// Status returns the playback position and settings
+func (d *ReplayDriver) Status() ReplayStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	position := d.position()
+	return ReplayStatus{
+		Path:     d.path,
+		Platform: d.platform,
+		Position: position.Round(time.Millisecond).String(),
+		Seconds:  position.Seconds(),
+		Duration: d.length.Round(time.Millisecond).String(),
+		Length:   d.length.Seconds(),
+		Speed:    d.speed,
+		Paused:   d.paused,
+		Loop:     d.loop,
+		Ended:    !d.loop && d.loaded && position >= d.length,
+	}
+}
+
+// position returns the current playback position; mu must be held
+func (d *ReplayDriver) position() time.Duration {
+	position := d.origin
+	if !d.paused {
+		position += time.Duration(float64(time.Since(d.since)) * d.speed)
+	}
+
+	if position >= d.length {
+		if d.loop && d.length > 0 {
+			return position % d.length
+		}
+		return d.length
+	}
+	return position
+}
+
+// rebase restarts the clock from the current position so speed and pause
+// changes apply from now on; mu must be held
+func (d *ReplayDriver) rebase() {
+	d.origin = d.position()
+	d.since = time.Now()
+}
+
+// sync brings the memory image to the playback position; mu must be held
+func (d *ReplayDriver) sync() error {
+	if !d.loaded {
+		return fmt.Errorf("replay not loaded")
+	}
+
+	position := d.position()
+
+	// Moving backwards or far ahead restarts from the nearest keyframe
+	if (d.cursor > 0 && d.entries[d.cursor-1].T > position) || d.keyframeAt(position) > d.cursor {
+		d.seekKeyframe(position)
+	}
+
+	for d.cursor < len(d.entries) && d.entries[d.cursor].T <= position {
+		if err := d.image.apply(d.entries[d.cursor].Memory); err != nil {
+			return fmt.Errorf("corrupt capture entry %d: %w", d.cursor, err)
+		}
+		d.cursor++
+	}
+	return nil
+}
+
+// keyframeAt returns the index of the last keyframe at or before position
+func (d *ReplayDriver) keyframeAt(position time.Duration) int {
+	i := sort.Search(len(d.keyframes), func(i int) bool {
+		return d.entries[d.keyframes[i]].T > position
+	})
+	if i == 0 {
+		return d.keyframes[0]
+	}
+	return d.keyframes[i-1]
+}
+
+// seekKeyframe restarts the image from the keyframe before position; mu must
+// be held
+func (d *ReplayDriver) seekKeyframe(position time.Duration) {
+	d.cursor = d.keyframeAt(position)
+	d.image = newMemoryImage()
+}
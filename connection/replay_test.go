@@ -0,0 +1,174 @@
+package connection
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"RetroGameAnalysis/recording"
+)
+
+// writeCapture writes a capture file holding entries and returns its path
+func writeCapture(t *testing.T, entries ...captureEntry) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "run"+CaptureExtension)
+	writer, err := recording.CreateWriter(path, captureEntry{Type: captureHeader, Version: CaptureVersion, Platform: "gb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries[:len(entries)-1] {
+		writer.Write(entry)
+	}
+	if err := writer.Close(entries[len(entries)-1]); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func entry(kind string, seconds float64, patches ...memoryPatch) captureEntry {
+	return captureEntry{Type: kind, T: time.Duration(seconds * float64(time.Second)), Memory: patches}
+}
+
+// testCapture is five seconds of two bytes at 0x100, with a keyframe at 0s
+// and 3s
+func testCapture(t *testing.T) string {
+	return writeCapture(t,
+		entry(captureKeyframe, 0, memoryPatch{Address: 0x100, Data: "0100"}),
+		entry(captureDelta, 1, memoryPatch{Address: 0x100, Data: "02"}),
+		entry(captureDelta, 2, memoryPatch{Address: 0x101, Data: "aa"}),
+		entry(captureKeyframe, 3, memoryPatch{Address: 0x100, Data: "03bb"}),
+		entry(captureDelta, 4, memoryPatch{Address: 0x100, Data: "04"}),
+		entry(captureEnd, 5),
+	)
+}
+
+// pausedReplay connects a paused replay of path
+func pausedReplay(t *testing.T, path string) *ReplayDriver {
+	t.Helper()
+
+	driver := NewReplayDriver(path)
+	if err := driver.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	driver.SetPaused(true)
+	if err := driver.Seek(0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { driver.Close() })
+	return driver
+}
+
+func checkMemory(t *testing.T, driver *ReplayDriver, want []byte) {
+	t.Helper()
+	got, err := driver.ReadMemory(0x100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("memory at %s is % X, want % X", driver.Status().Position, got, want)
+	}
+}
+
+func TestReplaySeek(t *testing.T) {
+	driver := pausedReplay(t, testCapture(t))
+
+	if status := driver.Status(); status.Platform != "gb" || status.Length != 5 || !status.Paused {
+		t.Errorf("status %+v", status)
+	}
+
+	steps := []struct {
+		seconds float64
+		want    []byte
+	}{
+		{0, []byte{0x01, 0x00}},
+		{1.5, []byte{0x02, 0x00}},
+		{2.5, []byte{0x02, 0xAA}},
+		{4, []byte{0x04, 0xBB}},
+		{1, []byte{0x02, 0x00}}, // Backwards from the first keyframe
+		{3.5, []byte{0x03, 0xBB}},
+		{5, []byte{0x04, 0xBB}},
+	}
+	for _, step := range steps {
+		if err := driver.Seek(time.Duration(step.seconds * float64(time.Second))); err != nil {
+			t.Fatalf("seek to %gs: %v", step.seconds, err)
+		}
+		checkMemory(t, driver, step.want)
+	}
+	if !driver.Status().Ended {
+		t.Error("replay at the end of the capture has not ended")
+	}
+
+	for _, position := range []time.Duration{-time.Second, 6 * time.Second} {
+		if err := driver.Seek(position); err == nil {
+			t.Errorf("seek to %v succeeded", position)
+		}
+	}
+}
+
+func TestReplayStartsFromKeyframe(t *testing.T) {
+	driver := pausedReplay(t, testCapture(t))
+
+	// A write lasts until the replay moves past a keyframe, which rebuilds
+	// memory from scratch
+	if err := driver.WriteBytes(0x100, []byte{0xFF}); err != nil {
+		t.Fatal(err)
+	}
+	checkMemory(t, driver, []byte{0xFF, 0x00})
+
+	if err := driver.Seek(3500 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	driver.mu.Lock()
+	cursor := driver.cursor
+	driver.mu.Unlock()
+	if cursor != 4 {
+		t.Errorf("cursor %d after seeking past the second keyframe, want 4", cursor)
+	}
+	checkMemory(t, driver, []byte{0x03, 0xBB})
+}
+
+func TestReplayLoop(t *testing.T) {
+	driver := pausedReplay(t, testCapture(t))
+	driver.SetLoop(true)
+
+	// The end of a looping replay is its start
+	if err := driver.Seek(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	checkMemory(t, driver, []byte{0x01, 0x00})
+	if status := driver.Status(); status.Ended || status.Seconds != 0 {
+		t.Errorf("looping replay status %+v", status)
+	}
+
+	driver.SetLoop(false)
+	if err := driver.Seek(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !driver.Status().Ended {
+		t.Error("replay did not end once looping stopped")
+	}
+}
+
+func TestReplaySpeedAndErrors(t *testing.T) {
+	driver := pausedReplay(t, testCapture(t))
+	for _, speed := range []float64{0, -1, MaxReplaySpeed + 1} {
+		if err := driver.SetSpeed(speed); err == nil {
+			t.Errorf("speed %g accepted", speed)
+		}
+	}
+	if err := driver.SetSpeed(MaxReplaySpeed); err != nil {
+		t.Errorf("speed %d: %v", MaxReplaySpeed, err)
+	}
+
+	driver.Close()
+	if _, err := driver.ReadMemory(0x100, 1); err == nil {
+		t.Error("read from a closed replay succeeded")
+	}
+
+	noKeyframes := writeCapture(t, entry(captureDelta, 0, memoryPatch{Address: 0x100, Data: "01"}), entry(captureEnd, 1))
+	if err := NewReplayDriver(noKeyframes).Connect(); err == nil {
+		t.Error("capture without keyframes loaded")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -13,7 +14,11 @@ type AdaptiveRetroArchDriver struct {
 	host           string
 	port           int
 	requestTimeout time.Duration
-	conn           *net.UDPConn
+
+	// mu guards conn and serializes request/response exchanges, since
+	// responses carry no request ID to match them by
+	mu   sync.Mutex
+	conn *net.UDPConn
 
 	// Adaptive chunking parameters
 	maxChunkSize  uint32 // Maximum bytes to read in one request
@@ -98,12 +103,13 @@ func (d *AdaptiveRetroArchDriver) Connect() error {
 		fmt.Printf("⚠️  Warning: failed to set write buffer to %d: %v\n", d.bufferSize, err)
 	}
 
+	d.mu.Lock()
 	d.conn = conn
+	d.mu.Unlock()
 
 	// Test connection and auto-detect optimal chunk size
 	if err := d.testConnection(); err != nil {
-		d.conn.Close()
-		d.conn = nil
+		d.Close()
 		return fmt.Errorf("failed to communicate with RetroArch: %w", err)
 	}
 
@@ -157,7 +163,7 @@ func (d *AdaptiveRetroArchDriver) testConnection() error {
 
 // ReadMemoryBlocks reads multiple memory blocks from RetroArch
 func (d *AdaptiveRetroArchDriver) ReadMemoryBlocks(blocks []MemoryBlock) (map[uint32][]byte, error) {
-	if d.conn == nil {
+	if !d.isConnected() {
 		if err := d.Connect(); err != nil {
 			return nil, err
 		}
@@ -178,7 +184,7 @@ func (d *AdaptiveRetroArchDriver) ReadMemoryBlocks(blocks []MemoryBlock) (map[ui
 
 // ReadMemory reads memory using adaptive chunking
 func (d *AdaptiveRetroArchDriver) ReadMemory(address uint32, length uint32) ([]byte, error) {
-	if d.conn == nil {
+	if !d.isConnected() {
 		return nil, fmt.Errorf("not connected to RetroArch")
 	}
 
@@ -265,7 +271,7 @@ func (d *AdaptiveRetroArchDriver) readChunk(address uint32, length uint32) ([]by
 
 // WriteBytes writes bytes to RetroArch
 func (d *AdaptiveRetroArchDriver) WriteBytes(address uint32, data []byte) error {
-	if d.conn == nil {
+	if !d.isConnected() {
 		return fmt.Errorf("not connected to RetroArch")
 	}
 
@@ -320,6 +326,9 @@ func (d *AdaptiveRetroArchDriver) writeChunk(address uint32, data []byte) error
 
 // Close closes the connection
 func (d *AdaptiveRetroArchDriver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if d.conn != nil {
 		err := d.conn.Close()
 		d.conn = nil
@@ -328,8 +337,18 @@ func (d *AdaptiveRetroArchDriver) Close() error {
 	return nil
 }
 
+// isConnected reports whether the driver currently has a connection
+func (d *AdaptiveRetroArchDriver) isConnected() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.conn != nil
+}
+
 // sendCommand sends a command to RetroArch and returns the response
 func (d *AdaptiveRetroArchDriver) sendCommand(command string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if d.conn == nil {
 		return "", fmt.Errorf("not connected")
 	}
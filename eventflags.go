@@ -0,0 +1,475 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/bits"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"RetroGameAnalysis/connection"
+	"RetroGameAnalysis/server"
+)
+
+// Sizes of the event flag arrays in bytes
+const (
+	eventFlagBytes      = 0x140
+	hiddenItemFlagBytes = 14
+	hiddenCoinFlagBytes = 2
+)
+
+// Event flag categories
+const (
+	FlagStory   = "story"   // Story milestones
+	FlagGym     = "gym"     // Gym leaders beaten
+	FlagGift    = "gift"    // Items and TMs received
+	FlagTrainer = "trainer" // Trainers beaten
+)
+
+// Kinds of flag reported in event_flags messages
+const (
+	FlagKindEvent      = "event"
+	FlagKindHiddenItem = "hidden_item"
+	FlagKindHiddenCoin = "hidden_coin"
+)
+
+// EventFlag is one flag of a flag array. Flags in the named table carry
+// their name, label, category and location; others only their position.
+type EventFlag struct {
+	Index    int    `json:"index"`
+	Address  string `json:"address"`
+	Bit      int    `json:"bit"`
+	Name     string `json:"name,omitempty"`
+	Label    string `json:"label,omitempty"`
+	Category string `json:"category,omitempty"`
+	Location string `json:"location,omitempty"`
+}
+
+// namedFlag is an entry of the event flag table
+type namedFlag struct {
+	index    int
+	name     string
+	label    string
+	category string
+	location string
+}
+
+// milestoneFlags are the story, gym and gift flags in the order a run
+// reaches them
+var milestoneFlags = []namedFlag{
+	{0x022, "got_starter", "Chose a starter Pokemon", FlagStory, "Oak's Lab"},
+	{0x023, "battled_rival_in_oaks_lab", "Battled the rival in Oak's Lab", FlagStory, "Oak's Lab"},
+	{0x039, "got_oaks_parcel", "Got Oak's Parcel", FlagStory, "Viridian Mart"},
+	{0x038, "oak_got_parcel", "Delivered Oak's Parcel", FlagStory, "Oak's Lab"},
+	{0x025, "got_pokedex", "Got the Pokedex", FlagGift, "Oak's Lab"},
+	{0x018, "got_town_map", "Got the Town Map", FlagGift, "Blue's House"},
+	{0x077, "beat_brock", "Beat Brock", FlagGym, "Pewter Gym"},
+	{0x076, "got_tm34", "Got TM34 (Bide)", FlagGift, "Pewter Gym"},
+	{0x0BF, "beat_misty", "Beat Misty", FlagGym, "Cerulean Gym"},
+	{0x0BE, "got_tm11", "Got TM11 (Bubblebeam)", FlagGift, "Cerulean Gym"},
+	{0x167, "beat_lt_surge", "Beat Lt. Surge", FlagGym, "Vermilion Gym"},
+	{0x166, "got_tm24", "Got TM24 (Thunderbolt)", FlagGift, "Vermilion Gym"},
+	{0x1A9, "beat_erika", "Beat Erika", FlagGym, "Celadon Gym"},
+	{0x1A8, "got_tm21", "Got TM21 (Mega Drain)", FlagGift, "Celadon Gym"},
+	{0x47F, "beat_route12_snorlax", "Cleared the Snorlax on Route 12", FlagStory, "Route 12"},
+	{0x4BF, "beat_route16_snorlax", "Cleared the Snorlax on Route 16", FlagStory, "Route 16"},
+	{0x259, "beat_koga", "Beat Koga", FlagGym, "Fuchsia Gym"},
+	{0x258, "got_tm06", "Got TM06 (Toxic)", FlagGift, "Fuchsia Gym"},
+	{0x361, "beat_sabrina", "Beat Sabrina", FlagGym, "Saffron Gym"},
+	{0x360, "got_tm46", "Got TM46 (Psywave)", FlagGift, "Saffron Gym"},
+	{0x299, "beat_blaine", "Beat Blaine", FlagGym, "Cinnabar Gym"},
+	{0x298, "got_tm38", "Got TM38 (Fire Blast)", FlagGift, "Cinnabar Gym"},
+	{0x051, "beat_giovanni", "Beat Giovanni", FlagGym, "Viridian Gym"},
+	{0x050, "got_tm27", "Got TM27 (Fissure)", FlagGift, "Viridian Gym"},
+	{0x8C1, "beat_lorelei", "Beat Lorelei", FlagStory, "Lorelei's Room"},
+	{0x8C9, "beat_bruno", "Beat Bruno", FlagStory, "Bruno's Room"},
+	{0x8D1, "beat_agatha", "Beat Agatha", FlagStory, "Agatha's Room"},
+	{0x8DE, "beat_lance", "Beat Lance", FlagStory, "Lance's Room"},
+	{0x901, "beat_champion", "Beat the Champion", FlagStory, "Champion's Room"},
+}
+
+// trainerBlock is a run of trainer flags, one per trainer of a location
+type trainerBlock struct {
+	location string
+	name     string // flag name prefix, e.g. route_3
+	first    int
+	count    int
+}
+
+// routeFlagBase is the first flag of Route 1. Every route has 16 flags;
+// its trainers start at the second.
+const routeFlagBase = 0x3C0
+
+// routeTrainers returns the trainer block of a route
+func routeTrainers(route, count int) trainerBlock {
+	return trainerBlock{
+		location: fmt.Sprintf("Route %d", route),
+		name:     fmt.Sprintf("route_%d", route),
+		first:    routeFlagBase + (route-1)*16 + 1,
+		count:    count,
+	}
+}
+
+// trainerBlocks lists the trainers of every route and gym
+var trainerBlocks = []trainerBlock{
+	routeTrainers(3, 8),
+	routeTrainers(4, 1),
+	routeTrainers(6, 6),
+	routeTrainers(8, 9),
+	routeTrainers(9, 9),
+	routeTrainers(10, 6),
+	routeTrainers(11, 10),
+	routeTrainers(12, 7),
+	routeTrainers(13, 10),
+	routeTrainers(14, 10),
+	routeTrainers(15, 10),
+	routeTrainers(16, 6),
+	routeTrainers(17, 10),
+	routeTrainers(18, 3),
+	routeTrainers(19, 10),
+	routeTrainers(20, 10),
+	routeTrainers(21, 9),
+	routeTrainers(24, 6),
+	routeTrainers(25, 9),
+	{"Pewter Gym", "pewter_gym", 0x072, 1},
+	{"Cerulean Gym", "cerulean_gym", 0x0BA, 2},
+	{"Vermilion Gym", "vermilion_gym", 0x162, 3},
+	{"Celadon Gym", "celadon_gym", 0x1AA, 7},
+	{"Fuchsia Gym", "fuchsia_gym", 0x25A, 6},
+	{"Saffron Gym", "saffron_gym", 0x362, 7},
+	{"Viridian Gym", "viridian_gym", 0x052, 8},
+}
+
+// eventFlagTable holds every named event flag by index
+var eventFlagTable = buildEventFlagTable()
+
+func buildEventFlagTable() map[int]EventFlag {
+	table := make(map[int]EventFlag)
+	add := func(named namedFlag) {
+		flag := flagAt(EVENT_FLAGS_ADDR, named.index)
+		flag.Name, flag.Label, flag.Category, flag.Location = named.name, named.label, named.category, named.location
+		table[named.index] = flag
+	}
+
+	for _, named := range milestoneFlags {
+		add(named)
+	}
+	for _, block := range trainerBlocks {
+		for i := 0; i < block.count; i++ {
+			add(namedFlag{
+				index:    block.first + i,
+				name:     fmt.Sprintf("beat_%s_trainer_%d", block.name, i),
+				label:    fmt.Sprintf("Beat %s trainer %d", block.location, i+1),
+				category: FlagTrainer,
+				location: block.location,
+			})
+		}
+	}
+	return table
+}
+
+// eventFlagPrefix names an event flag in split conditions, e.g.
+// event_flags.beat_brock
+const eventFlagPrefix = "event_flags."
+
+// eventFlagIndex holds the index of every named event flag by name
+var eventFlagIndex = buildEventFlagIndex()
+
+func buildEventFlagIndex() map[string]int {
+	index := make(map[string]int, len(eventFlagTable))
+	for i, flag := range eventFlagTable {
+		index[flag.Name] = i
+	}
+	return index
+}
+
+// lookupEventFlag finds the index of a property such as event_flags.beat_brock
+func lookupEventFlag(property string) (int, bool) {
+	name, ok := strings.CutPrefix(property, eventFlagPrefix)
+	if !ok {
+		return 0, false
+	}
+	index, ok := eventFlagIndex[name]
+	return index, ok
+}
+
+// flagAt describes flag index of the array at base
+func flagAt(base uint32, index int) EventFlag {
+	return EventFlag{
+		Index:   index,
+		Address: fmt.Sprintf("0x%04X", base+uint32(index/8)),
+		Bit:     index % 8,
+	}
+}
+
+// eventFlag describes an event flag, named if the table has it
+func eventFlag(index int) EventFlag {
+	if flag, ok := eventFlagTable[index]; ok {
+		return flag
+	}
+	return flagAt(EVENT_FLAGS_ADDR, index)
+}
+
+// EventFlags is a read of the flag arrays
+type EventFlags struct {
+	Events      []byte
+	HiddenItems []byte
+	HiddenCoins []byte
+}
+
+// flagSet reports whether flag index of a bit array is set
+func flagSet(array []byte, index int) bool {
+	return index/8 < len(array) && array[index/8]&(1<<(index%8)) != 0
+}
+
+// setFlags lists the set flags of a bit array
+func setFlags(array []byte) []int {
+	flags := []int{}
+	for i, b := range array {
+		for bit := 0; bit < 8; bit++ {
+			if b&(1<<bit) != 0 {
+				flags = append(flags, i*8+bit)
+			}
+		}
+	}
+	return flags
+}
+
+// countFlags counts the set flags of a bit array
+func countFlags(array []byte) int {
+	count := 0
+	for _, b := range array {
+		count += bits.OnesCount8(b)
+	}
+	return count
+}
+
+// flippedFlags lists the flags that differ between two reads of a bit array
+func flippedFlags(old, new []byte) []int {
+	var flipped []int
+	for i := range new {
+		if i >= len(old) || old[i] == new[i] {
+			continue
+		}
+		diff := old[i] ^ new[i]
+		for bit := 0; bit < 8; bit++ {
+			if diff&(1<<bit) != 0 {
+				flipped = append(flipped, i*8+bit)
+			}
+		}
+	}
+	return flipped
+}
+
+// readEventFlags reads the event, hidden item and hidden coin flags. The
+// hidden item and coin arrays are adjacent, so they are read as one block.
+func (s *Session) readEventFlags() (*EventFlags, error) {
+	hiddenEnd := uint32(HIDDEN_COIN_FLAGS_ADDR + hiddenCoinFlagBytes - 1)
+	data, err := s.driver.ReadMemoryBlocks([]connection.MemoryBlock{
+		{Name: "event flags", Start: EVENT_FLAGS_ADDR, End: EVENT_FLAGS_ADDR + eventFlagBytes - 1},
+		{Name: "hidden item flags", Start: HIDDEN_ITEM_FLAGS_ADDR, End: hiddenEnd},
+	})
+	if err != nil {
+		return nil, server.NewCommandError(ErrorDriver, "failed to read event flags: %v", err)
+	}
+
+	events, hidden := data[EVENT_FLAGS_ADDR], data[HIDDEN_ITEM_FLAGS_ADDR]
+	if len(events) < eventFlagBytes || len(hidden) < int(hiddenEnd-HIDDEN_ITEM_FLAGS_ADDR+1) {
+		return nil, server.NewCommandError(ErrorDriver, "short read of event flags")
+	}
+	coins := HIDDEN_COIN_FLAGS_ADDR - HIDDEN_ITEM_FLAGS_ADDR
+	return &EventFlags{
+		Events:      events[:eventFlagBytes],
+		HiddenItems: hidden[:hiddenItemFlagBytes],
+		HiddenCoins: hidden[coins : coins+hiddenCoinFlagBytes],
+	}, nil
+}
+
+// FlagState is a flag with whether it is set
+type FlagState struct {
+	EventFlag
+	Set bool `json:"set"`
+}
+
+// TrainerProgress counts the trainers beaten at one location
+type TrainerProgress struct {
+	Location string      `json:"location"`
+	Defeated int         `json:"defeated"`
+	Total    int         `json:"total"`
+	Trainers []FlagState `json:"trainers"`
+}
+
+// HiddenProgress lists the hidden items or coins picked up, by flag index
+type HiddenProgress struct {
+	Collected int   `json:"collected"`
+	Flags     []int `json:"flags"`
+}
+
+// Progress is the story progress decoded from the event flags
+type Progress struct {
+	Milestones       []FlagState       `json:"milestones"`
+	TrainersDefeated int               `json:"trainers_defeated"`
+	Trainers         []TrainerProgress `json:"trainers"`
+	HiddenItems      HiddenProgress    `json:"hidden_items"`
+	HiddenCoins      HiddenProgress    `json:"hidden_coins"`
+	EventFlagsSet    int               `json:"event_flags_set"`
+}
+
+// decodeProgress summarizes a read of the flag arrays
+func decodeProgress(flags *EventFlags) *Progress {
+	progress := &Progress{
+		Milestones:    make([]FlagState, 0, len(milestoneFlags)),
+		Trainers:      make([]TrainerProgress, 0, len(trainerBlocks)),
+		HiddenItems:   HiddenProgress{Collected: countFlags(flags.HiddenItems), Flags: setFlags(flags.HiddenItems)},
+		HiddenCoins:   HiddenProgress{Collected: countFlags(flags.HiddenCoins), Flags: setFlags(flags.HiddenCoins)},
+		EventFlagsSet: countFlags(flags.Events),
+	}
+
+	for _, named := range milestoneFlags {
+		progress.Milestones = append(progress.Milestones, FlagState{eventFlag(named.index), flagSet(flags.Events, named.index)})
+	}
+	for _, block := range trainerBlocks {
+		trainers := TrainerProgress{Location: block.location, Total: block.count, Trainers: make([]FlagState, 0, block.count)}
+		for i := 0; i < block.count; i++ {
+			state := FlagState{eventFlag(block.first + i), flagSet(flags.Events, block.first+i)}
+			if state.Set {
+				trainers.Defeated++
+			}
+			trainers.Trainers = append(trainers.Trainers, state)
+		}
+		progress.TrainersDefeated += trainers.Defeated
+		progress.Trainers = append(progress.Trainers, trainers)
+	}
+	return progress
+}
+
+// EventFlagChange is a flag that was set or cleared since the previous poll
+type EventFlagChange struct {
+	Kind string `json:"kind"` // event, hidden_item or hidden_coin
+	EventFlag
+	Set bool `json:"set"`
+}
+
+// eventFlagTracker keeps the last read of the flag arrays so the poll loop
+// can report the flags that flip
+type eventFlagTracker struct {
+	mu   sync.Mutex
+	last *EventFlags
+}
+
+// update stores a read and returns the flags that changed since the
+// previous one. The first read only sets the baseline.
+func (t *eventFlagTracker) update(flags *EventFlags) []EventFlagChange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	previous := t.last
+	t.last = flags
+	if previous == nil {
+		return nil
+	}
+
+	var changes []EventFlagChange
+	for _, array := range []struct {
+		kind     string
+		base     uint32
+		old, new []byte
+	}{
+		{FlagKindEvent, EVENT_FLAGS_ADDR, previous.Events, flags.Events},
+		{FlagKindHiddenItem, HIDDEN_ITEM_FLAGS_ADDR, previous.HiddenItems, flags.HiddenItems},
+		{FlagKindHiddenCoin, HIDDEN_COIN_FLAGS_ADDR, previous.HiddenCoins, flags.HiddenCoins},
+	} {
+		for _, index := range flippedFlags(array.old, array.new) {
+			flag := flagAt(array.base, index)
+			if array.kind == FlagKindEvent {
+				flag = eventFlag(index)
+			}
+			changes = append(changes, EventFlagChange{Kind: array.kind, EventFlag: flag, Set: flagSet(array.new, index)})
+		}
+	}
+	return changes
+}
+
+// pollEventFlags reads the flag arrays and broadcasts the flags that flipped
+// as an event_flags message. It is the read of the "events" poll group; the
+// flags are kept out of the game data, whose diffs would carry every bit.
+func (s *Session) pollEventFlags(_ *GameData) {
+	flags, err := s.readEventFlags()
+	if err != nil {
+		return
+	}
+	changes := s.eventFlags.update(flags)
+	if len(changes) == 0 {
+		return
+	}
+
+	for _, change := range changes {
+		if change.Set && change.Category != "" && change.Category != FlagTrainer {
+			log.Printf("🚩 [%s] %s", s.id, change.Label)
+		}
+	}
+	s.wsManager.BroadcastMessage(server.Message{
+		Type:      "event_flags",
+		Data:      map[string]interface{}{"changes": changes},
+		Timestamp: time.Now(),
+	})
+}
+
+// progress reads and decodes the flag arrays
+func (s *Session) progress() (*Progress, error) {
+	flags, err := s.readEventFlags()
+	if err != nil {
+		return nil, err
+	}
+	return decodeProgress(flags), nil
+}
+
+// setEventFlags lists every set event flag, named or not
+func (s *Session) setEventFlags() (map[string]interface{}, error) {
+	flags, err := s.readEventFlags()
+	if err != nil {
+		return nil, err
+	}
+	set := []EventFlag{}
+	for _, index := range setFlags(flags.Events) {
+		set = append(set, eventFlag(index))
+	}
+	return map[string]interface{}{"count": len(set), "flags": set}, nil
+}
+
+func (s *Session) registerProgressCommands() {
+	s.wsManager.RegisterCommand("progress_get", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		return s.progress()
+	})
+
+	s.wsManager.RegisterCommand("progress_flags", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		return s.setEventFlags()
+	})
+}
+
+// REST handlers for story progress
+
+func (s *Session) handleGetProgress(w http.ResponseWriter, r *http.Request) {
+	progress, err := s.progress()
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(progress)
+}
+
+func (s *Session) handleGetEventFlags(w http.ResponseWriter, r *http.Request) {
+	flags, err := s.setEventFlags()
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flags)
+}
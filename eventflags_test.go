@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestEventFlagTable(t *testing.T) {
+	names := make(map[string]int)
+	count := len(milestoneFlags)
+	for _, block := range trainerBlocks {
+		count += block.count
+	}
+	if len(eventFlagTable) != count {
+		t.Errorf("table holds %d flags, want %d: two entries share an index", len(eventFlagTable), count)
+	}
+
+	for index, flag := range eventFlagTable {
+		if index >= eventFlagBytes*8 {
+			t.Errorf("%s is flag 0x%X, past the end of the event flags", flag.Name, index)
+		}
+		if other, ok := names[flag.Name]; ok {
+			t.Errorf("flags 0x%X and 0x%X are both named %s", other, index, flag.Name)
+		}
+		names[flag.Name] = index
+	}
+
+	for _, name := range []string{"beat_giovanni", "beat_lorelei", "beat_lance", "beat_champion", "beat_saffron_gym_trainer_6"} {
+		if _, ok := lookupEventFlag(eventFlagPrefix + name); !ok {
+			t.Errorf("%s is not a named event flag", name)
+		}
+	}
+	if _, ok := lookupEventFlag(eventFlagPrefix + "beat_saffron_gym_trainer_7"); ok {
+		t.Error("Saffron Gym has an eighth trainer")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"RetroGameAnalysis/history"
+	"RetroGameAnalysis/server"
+	"RetroGameAnalysis/state"
+	"github.com/gorilla/mux"
+)
+
+// defaultHistoryWindow is the range queried when from is not given
+const defaultHistoryWindow = time.Hour
+
+// maxHistoryBuckets bounds the number of buckets a query may return
+const maxHistoryBuckets = 10000
+
+// HistoryResponse is a property's history over a time range. Without a step
+// the raw samples are returned; with one, the range is downsampled into
+// buckets.
+type HistoryResponse struct {
+	Property string           `json:"property"`
+	From     time.Time        `json:"from"`
+	To       time.Time        `json:"to"`
+	Step     string           `json:"step,omitempty"`
+	Start    *history.Sample  `json:"start,omitempty"` // Value held at from
+	Samples  []history.Sample `json:"samples,omitempty"`
+	Buckets  []history.Bucket `json:"buckets,omitempty"`
+}
+
+// recordHistory adds every numeric value in a set of changes to its series.
+// Series are named after the dotted game state path, e.g.
+// pokemon.0.current_hp, matching the property names.
+func (s *Session) recordHistory(changes []state.Change, at time.Time) {
+	for _, change := range changes {
+		if change.Op == state.OpRemove {
+			continue
+		}
+		s.recordHistoryValue(server.PropertyName(change.Path), change.Value, at)
+	}
+}
+
+// recordHistorySnapshot starts every series from the first published version,
+// so values that never change still have a history
+func (s *Session) recordHistorySnapshot(data *GameData) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	var tree map[string]interface{}
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		return
+	}
+	delete(tree, "last_updated")
+
+	for key, value := range tree {
+		s.recordHistoryValue(key, value, data.LastUpdated)
+	}
+}
+
+// recordHistoryValue records a numeric or boolean value, or every such value
+// inside an object or array
+func (s *Session) recordHistoryValue(name string, value interface{}, at time.Time) {
+	switch v := value.(type) {
+	case float64:
+		s.history.Record(name, at, v)
+	case bool:
+		if v {
+			s.history.Record(name, at, 1)
+		} else {
+			s.history.Record(name, at, 0)
+		}
+	case map[string]interface{}:
+		for key, child := range v {
+			s.recordHistoryValue(name+"."+key, child, at)
+		}
+	case []interface{}:
+		for i, child := range v {
+			s.recordHistoryValue(name+"."+strconv.Itoa(i), child, at)
+		}
+	}
+}
+
+// queryHistory returns a property's samples or buckets between from and to
+func (s *Session) queryHistory(name string, from, to time.Time, step time.Duration) (*HistoryResponse, error) {
+	if !from.Before(to) {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "from must be before to")
+	}
+	if step > 0 && to.Sub(from)/step > maxHistoryBuckets {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "step %v gives more than %d buckets; use a larger step or a shorter range", step, maxHistoryBuckets)
+	}
+
+	start, samples, err := s.history.Samples(name, from, to)
+	if errors.Is(err, history.ErrUnknownSeries) {
+		return nil, server.NewCommandError(server.ErrorNotFound, "no history for %q", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response := &HistoryResponse{Property: name, From: from, To: to, Start: start}
+	if step > 0 {
+		response.Step = step.String()
+		response.Buckets = history.Downsample(start, samples, from, to, step)
+	} else {
+		response.Samples = samples
+		if response.Samples == nil {
+			response.Samples = []history.Sample{}
+		}
+	}
+	return response, nil
+}
+
+// parseHistoryTime parses an RFC 3339 time, Unix seconds, or a negative
+// duration relative to now such as -15m
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if strings.HasPrefix(value, "-") {
+		if offset, err := time.ParseDuration(value); err == nil {
+			return now.Add(offset), nil
+		}
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		whole, fraction := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(fraction*1e9)), nil
+	}
+	if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return parsed, nil
+	}
+	return time.Time{}, server.NewCommandError(server.ErrorInvalidRequest, "invalid time %q (expected RFC 3339, Unix seconds or a duration such as -15m)", value)
+}
+
+// REST handlers for property history
+
+func (s *Session) handleListHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"series": s.history.Series()})
+}
+
+func (s *Session) handleGetHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	now := time.Now()
+
+	to := now
+	if value := query.Get("to"); value != "" {
+		parsed, err := parseHistoryTime(value, now)
+		if err != nil {
+			writeCommandError(w, err)
+			return
+		}
+		to = parsed
+	}
+
+	from := to.Add(-defaultHistoryWindow)
+	if value := query.Get("from"); value != "" {
+		parsed, err := parseHistoryTime(value, now)
+		if err != nil {
+			writeCommandError(w, err)
+			return
+		}
+		from = parsed
+	}
+
+	var step time.Duration
+	if value := query.Get("step"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "invalid step %q (expected a positive duration such as 10s)", value))
+			return
+		}
+		step = parsed
+	}
+
+	response, err := s.queryHistory(mux.Vars(r)["property"], from, to, step)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,73 @@
+package history
+
+import "time"
+
+// Bucket summarizes a series over one step. Avg is weighted by how long each
+// value was held; Count is the number of samples recorded in the bucket.
+type Bucket struct {
+	Time  time.Time `json:"t"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Last  float64   `json:"last"`
+	Count int       `json:"count"`
+}
+
+// Downsample splits [from, to) into buckets of step and summarizes the
+// series in each. before is the value at the start of the range, if known;
+// buckets before the series' first value are omitted and buckets without
+// samples carry the previous value forward.
+func Downsample(before *Sample, samples []Sample, from, to time.Time, step time.Duration) []Bucket {
+	buckets := []Bucket{}
+	current := before
+	i := 0
+
+	for start := from; start.Before(to); start = start.Add(step) {
+		end := start.Add(step)
+		if end.After(to) {
+			end = to
+		}
+
+		bucket := Bucket{Time: start}
+		var weighted, covered float64
+		cursor := start
+		if current != nil {
+			bucket.Min, bucket.Max = current.Value, current.Value
+		}
+
+		for i < len(samples) && (samples[i].Time.Before(end) || (end.Equal(to) && !samples[i].Time.After(to))) {
+			sample := samples[i]
+			if current != nil {
+				held := sample.Time.Sub(cursor).Seconds()
+				weighted += current.Value * held
+				covered += held
+			}
+			if current == nil || sample.Value < bucket.Min {
+				bucket.Min = sample.Value
+			}
+			if current == nil || sample.Value > bucket.Max {
+				bucket.Max = sample.Value
+			}
+			bucket.Count++
+			current = &samples[i]
+			cursor = sample.Time
+			i++
+		}
+
+		if current == nil {
+			continue
+		}
+
+		held := end.Sub(cursor).Seconds()
+		weighted += current.Value * held
+		covered += held
+
+		bucket.Last = current.Value
+		bucket.Avg = current.Value
+		if covered > 0 {
+			bucket.Avg = weighted / covered
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets
+}
@@ -0,0 +1,79 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDownsample(t *testing.T) {
+	base := time.Unix(1000, 0)
+	at := func(seconds int) time.Time { return base.Add(time.Duration(seconds) * time.Second) }
+
+	tests := []struct {
+		name    string
+		before  *Sample
+		samples []Sample
+		to      int
+		want    []Bucket
+	}{
+		{
+			name: "no value yet",
+			to:   20,
+			want: []Bucket{},
+		},
+		{
+			name: "buckets before the first value are omitted",
+			samples: []Sample{
+				{Time: at(15), Value: 4},
+			},
+			to: 30,
+			want: []Bucket{
+				{Time: at(10), Min: 4, Max: 4, Avg: 4, Last: 4, Count: 1},
+				{Time: at(20), Min: 4, Max: 4, Avg: 4, Last: 4},
+			},
+		},
+		{
+			name:   "average is weighted by time held",
+			before: &Sample{Time: at(-5), Value: 10},
+			samples: []Sample{
+				{Time: at(5), Value: 20},
+				{Time: at(15), Value: 0},
+			},
+			to: 20,
+			want: []Bucket{
+				{Time: at(0), Min: 10, Max: 20, Avg: 15, Last: 20, Count: 1},
+				{Time: at(10), Min: 0, Max: 20, Avg: 10, Last: 0, Count: 1},
+			},
+		},
+		{
+			name:   "partial last bucket includes a sample at the end",
+			before: &Sample{Time: at(-1), Value: 1},
+			samples: []Sample{
+				{Time: at(15), Value: 7},
+			},
+			to: 15,
+			want: []Bucket{
+				{Time: at(0), Min: 1, Max: 1, Avg: 1, Last: 1},
+				{Time: at(10), Min: 1, Max: 7, Avg: 1, Last: 7, Count: 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Downsample(test.before, test.samples, at(0), at(test.to), 10*time.Second)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d buckets %+v, want %d", len(got), got, len(test.want))
+			}
+			for i := range got {
+				if !got[i].Time.Equal(test.want[i].Time) {
+					t.Errorf("bucket %d starts at %v, want %v", i, got[i].Time, test.want[i].Time)
+				}
+				got[i].Time = test.want[i].Time
+				if got[i] != test.want[i] {
+					t.Errorf("bucket %d = %+v, want %+v", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
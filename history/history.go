@@ -0,0 +1,386 @@
+// Package history keeps time series of numeric game values: an in-memory ring
+// buffer per series and, optionally, daily files on disk for longer retention.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownSeries is returned when a series has no samples
+var ErrUnknownSeries = errors.New("unknown series")
+
+// flushInterval is how often samples are flushed to disk
+const flushInterval = time.Second
+
+// segmentLayout names the daily files on disk, one per UTC day
+const segmentLayout = "2006-01-02"
+
+// Sample is a series value at a point in time. Series only record changes, so
+// a value holds until the next sample.
+type Sample struct {
+	Time  time.Time `json:"t"`
+	Value float64   `json:"v"`
+}
+
+// SeriesInfo describes a series held in memory
+type SeriesInfo struct {
+	Name    string    `json:"name"`
+	Samples int       `json:"samples"`
+	Oldest  time.Time `json:"oldest"`
+	Newest  time.Time `json:"newest"`
+	Last    float64   `json:"last"`
+}
+
+// Options configures a store
+type Options struct {
+	// Capacity is the number of samples kept in memory per series
+	Capacity int
+
+	// Dir enables on-disk retention when set
+	Dir string
+
+	// Retention is how long daily files are kept on disk
+	Retention time.Duration
+}
+
+// Store holds every series of one session
+type Store struct {
+	options Options
+
+	mu     sync.RWMutex
+	series map[string]*ring
+
+	// On-disk retention; diskMu serializes file access
+	diskMu    sync.Mutex
+	file      *os.File
+	writer    *bufio.Writer
+	day       string
+	lastFlush time.Time
+	diskErr   error
+}
+
+// NewStore creates a store, creating the on-disk directory if retention is
+// enabled
+func NewStore(options Options) (*Store, error) {
+	if options.Capacity <= 0 {
+		options.Capacity = 4096
+	}
+	if options.Dir != "" {
+		if err := os.MkdirAll(options.Dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create history directory: %w", err)
+		}
+	}
+	return &Store{options: options, series: make(map[string]*ring)}, nil
+}
+
+// Record appends a sample to a series
+func (s *Store) Record(name string, at time.Time, value float64) {
+	s.mu.Lock()
+	series, ok := s.series[name]
+	if !ok {
+		series = newRing(s.options.Capacity)
+		s.series[name] = series
+	}
+	series.add(Sample{Time: at, Value: value})
+	s.mu.Unlock()
+
+	if s.options.Dir != "" {
+		s.persist(name, at, value)
+	}
+}
+
+// Series lists the series held in memory, sorted by name
+func (s *Store) Series() []SeriesInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make([]SeriesInfo, 0, len(s.series))
+	for name, series := range s.series {
+		oldest, newest := series.oldest(), series.newest()
+		infos = append(infos, SeriesInfo{
+			Name:    name,
+			Samples: series.count,
+			Oldest:  oldest.Time,
+			Newest:  newest.Time,
+			Last:    newest.Value,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Samples returns a series' samples in [from, to] together with the last
+// sample before from, which gives the value at the start of the range. Older
+// samples come from disk when they are no longer held in memory.
+func (s *Store) Samples(name string, from, to time.Time) (*Sample, []Sample, error) {
+	s.mu.RLock()
+	series, ok := s.series[name]
+	var covered bool
+	var before *Sample
+	var samples []Sample
+	if ok {
+		covered = !series.oldest().Time.After(from)
+		before, samples = series.between(from, to)
+	}
+	s.mu.RUnlock()
+
+	if ok && (covered || s.options.Dir == "") {
+		return before, samples, nil
+	}
+	if s.options.Dir == "" {
+		return nil, nil, ErrUnknownSeries
+	}
+
+	before, samples, err := s.readDisk(name, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	if before == nil && len(samples) == 0 {
+		return nil, nil, ErrUnknownSeries
+	}
+	return before, samples, nil
+}
+
+// Close flushes and closes the on-disk file
+func (s *Store) Close() error {
+	s.diskMu.Lock()
+	defer s.diskMu.Unlock()
+	return s.closeSegment()
+}
+
+// diskSample is one line of a daily file
+type diskSample struct {
+	Name  string  `json:"p"`
+	Time  int64   `json:"t"` // Unix milliseconds
+	Value float64 `json:"v"`
+}
+
+func (s *Store) persist(name string, at time.Time, value float64) {
+	s.diskMu.Lock()
+	defer s.diskMu.Unlock()
+
+	if s.diskErr != nil {
+		return
+	}
+
+	day := at.UTC().Format(segmentLayout)
+	if day != s.day {
+		if err := s.openSegment(day); err != nil {
+			s.diskErr = err
+			return
+		}
+		s.prune(at)
+	}
+
+	line, _ := json.Marshal(diskSample{Name: name, Time: at.UnixMilli(), Value: value})
+	if _, err := s.writer.Write(append(line, '\n')); err != nil {
+		s.diskErr = fmt.Errorf("failed to write history: %w", err)
+		return
+	}
+	if time.Since(s.lastFlush) >= flushInterval {
+		s.writer.Flush()
+		s.lastFlush = time.Now()
+	}
+}
+
+// Err returns the error that stopped on-disk retention, if any
+func (s *Store) Err() error {
+	s.diskMu.Lock()
+	defer s.diskMu.Unlock()
+	return s.diskErr
+}
+
+// openSegment switches to the daily file for day; diskMu must be held
+func (s *Store) openSegment(day string) error {
+	if err := s.closeSegment(); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(s.options.Dir, day+".jsonl"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	s.file = file
+	s.writer = bufio.NewWriter(file)
+	s.day = day
+	return nil
+}
+
+// closeSegment flushes and closes the current daily file; diskMu must be held
+func (s *Store) closeSegment() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.writer.Flush()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file, s.writer, s.day = nil, nil, ""
+	return err
+}
+
+// prune deletes daily files older than the retention; diskMu must be held
+func (s *Store) prune(now time.Time) {
+	if s.options.Retention <= 0 {
+		return
+	}
+
+	cutoff := now.Add(-s.options.Retention).UTC().Format(segmentLayout)
+	for _, day := range s.segments() {
+		if day < cutoff {
+			os.Remove(filepath.Join(s.options.Dir, day+".jsonl"))
+		}
+	}
+}
+
+// segments lists the days with a file on disk, oldest first
+func (s *Store) segments() []string {
+	entries, err := os.ReadDir(s.options.Dir)
+	if err != nil {
+		return nil
+	}
+
+	var days []string
+	for _, entry := range entries {
+		day, ok := strings.CutSuffix(entry.Name(), ".jsonl")
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(segmentLayout, day); err == nil {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days
+}
+
+// readDisk reads a series' samples from the daily files covering the range,
+// plus the latest file before it for the value at the start of the range
+func (s *Store) readDisk(name string, from, to time.Time) (*Sample, []Sample, error) {
+	s.diskMu.Lock()
+	if s.writer != nil {
+		s.writer.Flush()
+	}
+	days := s.segments()
+	s.diskMu.Unlock()
+
+	first, last := from.UTC().Format(segmentLayout), to.UTC().Format(segmentLayout)
+	var selected []string
+	for i, day := range days {
+		if day > last {
+			break
+		}
+		// Of the files before the range, only the latest is needed
+		if day < first && i+1 < len(days) && days[i+1] < first {
+			continue
+		}
+		selected = append(selected, day)
+	}
+
+	var before *Sample
+	var samples []Sample
+	for _, day := range selected {
+		err := readSegment(filepath.Join(s.options.Dir, day+".jsonl"), name, func(sample Sample) {
+			switch {
+			case sample.Time.Before(from):
+				before = &sample
+			case !sample.Time.After(to):
+				samples = append(samples, sample)
+			}
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return before, samples, nil
+}
+
+// readSegment calls fn with each sample of the named series in a daily file
+func readSegment(path, name string, fn func(Sample)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry diskSample
+			// A line cut short by a crash is skipped
+			if json.Unmarshal(line, &entry) == nil && entry.Name == name {
+				fn(Sample{Time: time.UnixMilli(entry.Time), Value: entry.Value})
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+}
+
+// ring is a bounded buffer of a series' most recent samples. It grows as
+// samples arrive, so a series that rarely changes stays small, and wraps
+// once it holds capacity samples.
+type ring struct {
+	samples  []Sample
+	capacity int
+	start    int
+	count    int
+}
+
+func newRing(capacity int) *ring {
+	return &ring{capacity: capacity}
+}
+
+func (r *ring) add(sample Sample) {
+	if r.count < r.capacity {
+		r.samples = append(r.samples, sample)
+		r.count++
+		return
+	}
+	r.samples[r.start] = sample
+	r.start = (r.start + 1) % r.count
+}
+
+func (r *ring) at(i int) Sample {
+	return r.samples[(r.start+i)%len(r.samples)]
+}
+
+func (r *ring) oldest() Sample {
+	return r.at(0)
+}
+
+func (r *ring) newest() Sample {
+	return r.at(r.count - 1)
+}
+
+// between returns the samples in [from, to] and the last one before from
+func (r *ring) between(from, to time.Time) (*Sample, []Sample) {
+	first := sort.Search(r.count, func(i int) bool { return !r.at(i).Time.Before(from) })
+
+	var before *Sample
+	if first > 0 {
+		sample := r.at(first - 1)
+		before = &sample
+	}
+
+	var samples []Sample
+	for i := first; i < r.count && !r.at(i).Time.After(to); i++ {
+		samples = append(samples, r.at(i))
+	}
+	return before, samples
+}
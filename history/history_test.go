@@ -0,0 +1,30 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRingWraps(t *testing.T) {
+	base := time.Unix(1000, 0)
+	r := newRing(3)
+
+	for i := 0; i < 5; i++ {
+		r.add(Sample{Time: base.Add(time.Duration(i) * time.Second), Value: float64(i)})
+		if want := min(i+1, 3); r.count != want || len(r.samples) != want {
+			t.Fatalf("after %d samples the ring holds %d in %d slots, want %d", i+1, r.count, len(r.samples), want)
+		}
+	}
+
+	if oldest, newest := r.oldest().Value, r.newest().Value; oldest != 2 || newest != 4 {
+		t.Errorf("ring holds %v to %v, want 2 to 4", oldest, newest)
+	}
+
+	before, samples := r.between(base.Add(3*time.Second), base.Add(10*time.Second))
+	if before == nil || before.Value != 2 {
+		t.Errorf("sample before the range is %+v, want 2", before)
+	}
+	if len(samples) != 2 || samples[0].Value != 3 || samples[1].Value != 4 {
+		t.Errorf("samples in the range are %+v, want 3 and 4", samples)
+	}
+}
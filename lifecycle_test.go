@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"RetroGameAnalysis/connection"
+	"RetroGameAnalysis/server"
+	"github.com/gorilla/websocket"
+)
+
+// fakeDriver serves zeroed memory and records writes
+type fakeDriver struct {
+	mu        sync.Mutex
+	connected bool
+	connects  int
+	closes    int
+	memory    map[uint32]byte
+}
+
+func newFakeDriver() *fakeDriver {
+	return &fakeDriver{memory: make(map[uint32]byte)}
+}
+
+func (d *fakeDriver) Connect() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.connected = true
+	d.connects++
+	return nil
+}
+
+func (d *fakeDriver) ReadMemoryBlocks(blocks []connection.MemoryBlock) (map[uint32][]byte, error) {
+	result := make(map[uint32][]byte, len(blocks))
+	for _, block := range blocks {
+		data, err := d.ReadMemory(block.Start, block.End-block.Start+1)
+		if err != nil {
+			return nil, err
+		}
+		result[block.Start] = data
+	}
+	return result, nil
+}
+
+func (d *fakeDriver) ReadMemory(address uint32, length uint32) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = d.memory[address+uint32(i)]
+	}
+	return data, nil
+}
+
+func (d *fakeDriver) WriteBytes(address uint32, data []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, b := range data {
+		d.memory[address+uint32(i)] = b
+	}
+	return nil
+}
+
+func (d *fakeDriver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.connected = false
+	d.closes++
+	return nil
+}
+
+func (d *fakeDriver) counts() (connects, closes int, connected bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.connects, d.closes, d.connected
+}
+
+// newTestServer builds a server whose default session reads from driver and
+// keeps its files in a temporary directory
+func newTestServer(t *testing.T, driver connection.Driver) *PokemonWebServer {
+	t.Helper()
+
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.Host = "127.0.0.1"
+	config.Port = 0
+	config.UIsDir = dir + "/uis"
+	config.OverlaysDir = dir + "/overlays"
+	config.RecordingsDir = dir + "/recordings"
+	config.ScriptsDir = dir + "/scripts"
+
+	srv, err := NewPokemonWebServer(config)
+	if err != nil {
+		t.Fatalf("NewPokemonWebServer: %v", err)
+	}
+
+	session, err := srv.session(DefaultSessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.capture = connection.NewCaptureDriver(driver)
+	session.driver = session.capture
+	return srv
+}
+
+// serveOnce starts srv on a loopback listener, checks that HTTP and WebSocket
+// clients are served, then cancels it and checks that everything shut down
+func serveOnce(t *testing.T, srv *PokemonWebServer) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := listener.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ctx, listener)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get("http://" + addr + "/api/sessions")
+	if err != nil {
+		t.Fatalf("GET /api/sessions: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/sessions: status %d", resp.StatusCode)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial /ws: %v", err)
+	}
+	defer conn.Close()
+
+	var welcome server.Message
+	if err := conn.ReadJSON(&welcome); err != nil {
+		t.Fatalf("read welcome: %v", err)
+	}
+	if welcome.Type != "connected" {
+		t.Fatalf("first message is %q, want connected", welcome.Type)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Serve: %v", err)
+		}
+	case <-time.After(shutdownTimeout):
+		t.Fatal("Serve did not return after cancel")
+	}
+
+	// The client receives a close frame once pending messages are flushed
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var message server.Message
+		err := conn.ReadJSON(&message)
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Fatalf("read after shutdown: %v, want close frame", err)
+		}
+		break
+	}
+
+	if _, err := client.Get("http://" + addr + "/api/sessions"); err == nil {
+		t.Fatal("server still accepts requests after Serve returned")
+	}
+}
+
+func TestServeRestart(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	driver := newFakeDriver()
+	srv := newTestServer(t, driver)
+
+	const runs = 3
+	for i := 0; i < runs; i++ {
+		serveOnce(t, srv)
+	}
+
+	connects, closes, connected := driver.counts()
+	if connects != runs || closes != runs || connected {
+		t.Errorf("driver connected %d times and closed %d times (still connected: %v), want %d each", connects, closes, connected, runs)
+	}
+
+	checkGoroutines(t, before)
+}
+
+// checkGoroutines fails the test if goroutines started since before are
+// still running once they have had time to exit
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if runtime.NumGoroutine() <= before {
+			return
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	t.Errorf("%d goroutines running after shutdown, %d before:\n%s",
+		runtime.NumGoroutine(), before, strings.TrimSpace(string(buf)))
+}
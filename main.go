@@ -2,16 +2,23 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
-	"RetroGameAnalysis/connection"
 	"RetroGameAnalysis/server"
+	"RetroGameAnalysis/state"
 	"github.com/gorilla/mux"
 )
 
@@ -76,96 +83,284 @@ type Badge struct {
 	Obtained bool   `json:"obtained"`
 }
 
-// PokemonWebServer handles the web server and Pokemon data
+// PokemonWebServer serves the web interface and API for one or more
+// emulator sessions
 type PokemonWebServer struct {
-	wsManager *server.WebSocketManager
-	driver    *connection.AdaptiveRetroArchDriver
-	gameData  *GameData
-	router    *mux.Router
+	router        *mux.Router
+	access        *server.AccessControl
+	managerConfig server.ManagerConfig
+	recordings    RecordingSettings
+	config        *Config
+
+	// sessions holds every session by ID; serving is set while Serve runs
+	// so sessions added at runtime are started immediately
+	sessionsMu sync.RWMutex
+	sessions   map[string]*Session
+	serving    bool
 }
 
-func NewPokemonWebServer() *PokemonWebServer {
-	wsManager := server.NewWebSocketManager()
+func NewPokemonWebServer(config *Config) (*PokemonWebServer, error) {
+	access, err := config.AccessControl()
+	if err != nil {
+		return nil, err
+	}
 
-	driver := connection.NewAdaptiveRetroArchDriver("localhost", 55355, 5*time.Second)
-	driver.SetPlatform("GB")
+	managerConfig := config.ManagerConfig()
+	managerConfig.Access = access
 
-	return &PokemonWebServer{
-		wsManager: wsManager,
-		driver:    driver,
-		gameData:  &GameData{},
-		router:    mux.NewRouter(),
+	s := &PokemonWebServer{
+		router:        mux.NewRouter(),
+		access:        access,
+		managerConfig: managerConfig,
+		recordings:    config.RecordingSettings(),
+		config:        config,
+		sessions:      make(map[string]*Session),
 	}
+
+	for _, sessionConfig := range config.SessionConfigs() {
+		session, err := NewSession(sessionConfig, managerConfig, s.recordings, config.HistoryOptions(sessionConfig.ID))
+		if err != nil {
+			return nil, fmt.Errorf("session %q: %w", sessionConfig.ID, err)
+		}
+		s.sessions[sessionConfig.ID] = session
+	}
+	s.setupRoutes()
+
+	return s, nil
 }
 
-func (s *PokemonWebServer) Start(port string) {
-	// Start WebSocket manager
-	s.wsManager.Start()
+// shutdownTimeout bounds how long Run waits for clients and requests to drain
+const shutdownTimeout = 10 * time.Second
 
-	// Connect to RetroArch
-	if err := s.driver.Connect(); err != nil {
-		log.Fatalf("Failed to connect to RetroArch: %v", err)
+// Run listens on the configured address and serves until ctx is cancelled
+func (s *PokemonWebServer) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.config.Address())
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.config.Address(), err)
 	}
+	return s.Serve(ctx, listener)
+}
 
-	log.Println("✅ Connected to RetroArch")
-
-	// Setup routes
-	s.setupRoutes()
+// Serve starts every session and serves HTTP on listener until ctx is
+// cancelled, then shuts everything down in order: polling stops, WebSocket
+// clients receive close frames, in-flight requests drain and the drivers are
+// closed. Serve may be called again after it returns.
+func (s *PokemonWebServer) Serve(ctx context.Context, listener net.Listener) error {
+	log.Printf("⚙️  Effective configuration:\n%s", s.config)
+	if !s.access.Enabled() {
+		log.Println("⚠️  No API keys configured: loopback clients have admin access, others read access")
+	}
 
-	// Start Pokemon data monitoring
-	go s.monitorPokemonData()
+	// Connect every session to its RetroArch instance
+	s.sessionsMu.Lock()
+	for _, session := range s.sessions {
+		if err := session.Start(); err != nil {
+			s.sessionsMu.Unlock()
+			s.stopSessions(context.Background())
+			listener.Close()
+			return err
+		}
+	}
+	s.serving = true
+	s.sessionsMu.Unlock()
 
 	// Start server
-	log.Printf("🌐 Pokemon Web Server starting on port %s", port)
-	log.Printf("📱 WebSocket endpoint: ws://localhost:%s/ws", port)
-	log.Printf("🌍 Web interface: http://localhost:%s", port)
-	log.Printf("📡 REST API: http://localhost:%s/api/", port)
+	httpServer := &http.Server{Handler: s.router}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.Serve(listener)
+	}()
+
+	addr := listener.Addr().String()
+	log.Printf("🌐 Pokemon Web Server listening on %s", addr)
+	log.Printf("📱 WebSocket endpoint: ws://%s/ws", addr)
+	log.Printf("🌍 Web interface: http://%s", addr)
+	log.Printf("📡 REST API: http://%s/api/", addr)
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		log.Println("🛑 Shutting down...")
+	case err := <-serveErr:
+		runErr = fmt.Errorf("HTTP server failed: %w", err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	s.sessionsMu.Lock()
+	s.serving = false
+	s.sessionsMu.Unlock()
+	s.stopSessions(shutdownCtx)
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil && runErr == nil {
+		runErr = fmt.Errorf("failed to drain HTTP server: %w", err)
+	}
+
+	for _, session := range s.sortedSessions() {
+		if err := session.Close(); err != nil {
+			log.Printf("⚠️  [%s] Failed to close RetroArch connection: %v", session.id, err)
+		}
+	}
 
-	log.Fatal(http.ListenAndServe(":"+port, s.router))
+	log.Println("👋 Server stopped")
+	return runErr
+}
+
+// stopSessions stops polling in every session, releases freezes and closes
+// WebSocket clients
+func (s *PokemonWebServer) stopSessions(ctx context.Context) {
+	for _, session := range s.sortedSessions() {
+		if err := session.Stop(ctx); err != nil {
+			log.Printf("⚠️  [%s] WebSocket clients did not close cleanly: %v", session.id, err)
+		}
+	}
 }
 
 // setupRoutes configures all HTTP routes
 func (s *PokemonWebServer) setupRoutes() {
-	// WebSocket endpoint
-	s.router.HandleFunc("/ws", s.wsManager.HandleWebSocket)
+	// WebSocket endpoint of the default session
+	s.router.HandleFunc("/ws", s.withSession(server.RoleRead, (*Session).handleWebSocket))
 
 	// REST API endpoints
 	api := s.router.PathPrefix("/api").Subrouter()
-	api.HandleFunc("/gamedata", s.handleGetGameData).Methods("GET")
-	api.HandleFunc("/pokemon", s.handleGetPokemon).Methods("GET")
-	api.HandleFunc("/pokemon/{id:[0-9]+}", s.handleGetPokemonByID).Methods("GET")
-	api.HandleFunc("/player", s.handleGetPlayer).Methods("GET")
-	api.HandleFunc("/items", s.handleGetItems).Methods("GET")
-	api.HandleFunc("/badges", s.handleGetBadges).Methods("GET")
-	api.HandleFunc("/status", s.handleGetStatus).Methods("GET")
+	api.HandleFunc("/config", s.access.Require(server.RoleAdmin, s.handleGetConfig)).Methods("GET")
+
+	// Session management
+	api.HandleFunc("/sessions", s.access.Require(server.RoleRead, s.handleListSessions)).Methods("GET")
+	api.HandleFunc("/sessions", s.access.Require(server.RoleAdmin, s.handleCreateSession)).Methods("POST")
+	api.HandleFunc("/sessions/{session}", s.withSession(server.RoleRead, (*Session).handleGetSession)).Methods("GET")
+	api.HandleFunc("/sessions/{session}", s.access.Require(server.RoleAdmin, s.handleDeleteSession)).Methods("DELETE")
+
+	// Recorded timelines of every session
+	api.HandleFunc("/recordings", s.access.Require(server.RoleRead, s.handleListRecordings)).Methods("GET")
+	api.HandleFunc("/recordings/{name}", s.access.Require(server.RoleRead, s.handleDownloadRecording)).Methods("GET")
+	api.HandleFunc("/captures", s.access.Require(server.RoleRead, s.handleListCaptures)).Methods("GET")
+	api.HandleFunc("/captures/{name}", s.access.Require(server.RoleRead, s.handleDownloadCapture)).Methods("GET")
+
+	// Custom web UIs and stream overlays
+	api.HandleFunc("/uis", s.access.Require(server.RoleRead, s.handleListUIs)).Methods("GET")
+	api.HandleFunc("/overlays", s.access.Require(server.RoleRead, s.handleListOverlays)).Methods("GET")
+
+	// Every session's routes live under /api/sessions/{session}; the
+	// unscoped /api routes address the default session
+	s.setupSessionRoutes(api.PathPrefix("/sessions/{session}").Subrouter())
+	s.setupSessionRoutes(api)
 
 	// Static files and web interface
-	s.router.HandleFunc("/", s.handleHomePage).Methods("GET")
-	s.router.HandleFunc("/live", s.handleLivePage).Methods("GET")
+	s.router.HandleFunc("/", pageHandler("index.html")).Methods("GET")
+	s.router.HandleFunc("/live", pageHandler("live.html")).Methods("GET")
+	s.router.HandleFunc("/memory", pageHandler("memory.html")).Methods("GET")
+	s.router.PathPrefix("/assets/").Handler(assetHandler())
+	s.router.HandleFunc("/overlay/{name}", s.handleOverlay).Methods("GET")
+	s.router.HandleFunc("/ui/{name}", s.handleCustomUI).Methods("GET")
+	s.router.PathPrefix("/ui/{name}/").HandlerFunc(s.handleCustomUI).Methods("GET")
 	s.router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
 
-	// Enable CORS for API
-	s.router.Use(corsMiddleware)
+	// Enable CORS for allowed origins
+	s.router.Use(s.access.CORS)
+}
+
+// setupSessionRoutes registers the session-scoped routes on router
+func (s *PokemonWebServer) setupSessionRoutes(router *mux.Router) {
+	router.HandleFunc("/ws", s.withSession(server.RoleRead, (*Session).handleWebSocket))
+	router.HandleFunc("/gamedata", s.withSession(server.RoleRead, (*Session).handleGetGameData)).Methods("GET")
+	router.HandleFunc("/pokemon", s.withSession(server.RoleRead, (*Session).handleGetPokemon)).Methods("GET")
+	router.HandleFunc("/pokemon/{id:[0-9]+}", s.withSession(server.RoleRead, (*Session).handleGetPokemonByID)).Methods("GET")
+	router.HandleFunc("/player", s.withSession(server.RoleRead, (*Session).handleGetPlayer)).Methods("GET")
+	router.HandleFunc("/items", s.withSession(server.RoleRead, (*Session).handleGetItems)).Methods("GET")
+	router.HandleFunc("/badges", s.withSession(server.RoleRead, (*Session).handleGetBadges)).Methods("GET")
+	router.HandleFunc("/status", s.withSession(server.RoleRead, (*Session).handleGetStatus)).Methods("GET")
+	router.HandleFunc("/poll", s.withSession(server.RoleRead, (*Session).handleGetPollStats)).Methods("GET")
+	router.HandleFunc("/ws/clients", s.withSession(server.RoleAdmin, (*Session).handleGetWebSocketClients)).Methods("GET")
+	router.HandleFunc("/ui", s.withSession(server.RoleRead, (*Session).handleGetUI)).Methods("GET")
+
+	// Story progress from the event flags
+	router.HandleFunc("/progress", s.withSession(server.RoleRead, (*Session).handleGetProgress)).Methods("GET")
+	router.HandleFunc("/progress/flags", s.withSession(server.RoleRead, (*Session).handleGetEventFlags)).Methods("GET")
+
+	// Property access
+	router.HandleFunc("/properties", s.withSession(server.RoleRead, (*Session).handleListProperties)).Methods("GET")
+	router.HandleFunc("/properties/batch", s.withSession(server.RoleWrite, (*Session).handleBatchWrite)).Methods("PUT")
+	router.HandleFunc("/properties/{name}", s.withSession(server.RoleRead, (*Session).handleGetProperty)).Methods("GET")
+	router.HandleFunc("/properties/{name}/value", s.withSession(server.RoleWrite, (*Session).handleSetProperty)).Methods("PUT")
+	router.HandleFunc("/properties/{name}/freeze", s.withSession(server.RoleWrite, (*Session).handleFreezeProperty)).Methods("POST")
+
+	// Timeline recording
+	router.HandleFunc("/recording", s.withSession(server.RoleRead, (*Session).handleGetRecording)).Methods("GET")
+	router.HandleFunc("/recording", s.withSession(server.RoleWrite, (*Session).handleStartRecording)).Methods("POST")
+	router.HandleFunc("/recording", s.withSession(server.RoleWrite, (*Session).handleStopRecording)).Methods("DELETE")
+
+	// Raw memory capture and replay playback
+	router.HandleFunc("/capture", s.withSession(server.RoleRead, (*Session).handleGetCapture)).Methods("GET")
+	router.HandleFunc("/capture", s.withSession(server.RoleWrite, (*Session).handleStartCapture)).Methods("POST")
+	router.HandleFunc("/capture", s.withSession(server.RoleWrite, (*Session).handleStopCapture)).Methods("DELETE")
+	router.HandleFunc("/replay", s.withSession(server.RoleRead, (*Session).handleGetReplay)).Methods("GET")
+	router.HandleFunc("/replay", s.withSession(server.RoleWrite, (*Session).handleControlReplay)).Methods("PUT")
+
+	// Property history
+	router.HandleFunc("/history", s.withSession(server.RoleRead, (*Session).handleListHistory)).Methods("GET")
+	router.HandleFunc("/history/{property}", s.withSession(server.RoleRead, (*Session).handleGetHistory)).Methods("GET")
+
+	// Raw memory
+	router.HandleFunc("/memory", s.withSession(server.RoleRead, (*Session).handleGetMemory)).Methods("GET")
+	router.HandleFunc("/memory/annotations", s.withSession(server.RoleRead, (*Session).handleGetMemoryAnnotations)).Methods("GET")
+
+	// Autosplitter
+	router.HandleFunc("/splits", s.withSession(server.RoleRead, (*Session).handleGetSplits)).Methods("GET")
+	router.HandleFunc("/splits", s.withSession(server.RoleWrite, (*Session).handleLoadSplits)).Methods("PUT")
+	router.HandleFunc("/splits/{action}", s.withSession(server.RoleWrite, (*Session).handleControlSplits)).Methods("POST")
+
+	// Watchpoints
+	router.HandleFunc("/watchpoints", s.withSession(server.RoleRead, (*Session).handleListWatchpoints)).Methods("GET")
+	router.HandleFunc("/watchpoints", s.withSession(server.RoleWrite, (*Session).handleAddWatchpoint)).Methods("POST")
+	router.HandleFunc("/watchpoints/{id}", s.withSession(server.RoleRead, (*Session).handleGetWatchpoint)).Methods("GET")
+	router.HandleFunc("/watchpoints/{id}", s.withSession(server.RoleWrite, (*Session).handleRemoveWatchpoint)).Methods("DELETE")
+
+	// Memory snapshots
+	router.HandleFunc("/snapshots", s.withSession(server.RoleRead, (*Session).handleListSnapshots)).Methods("GET")
+	router.HandleFunc("/snapshots", s.withSession(server.RoleWrite, (*Session).handleSaveSnapshot)).Methods("POST")
+	router.HandleFunc("/snapshots/{name}", s.withSession(server.RoleRead, (*Session).handleGetSnapshot)).Methods("GET")
+	router.HandleFunc("/snapshots/{name}", s.withSession(server.RoleWrite, (*Session).handleDeleteSnapshot)).Methods("DELETE")
+	router.HandleFunc("/snapshots/{name}/diff/{other}", s.withSession(server.RoleRead, (*Session).handleDiffSnapshots)).Methods("GET")
+
+	// Scripts
+	router.HandleFunc("/scripts", s.withSession(server.RoleRead, (*Session).handleListScripts)).Methods("GET")
+	router.HandleFunc("/scripts/{name}", s.withSession(server.RoleRead, (*Session).handleGetScript)).Methods("GET")
+	router.HandleFunc("/scripts/{name}/restart", s.withSession(server.RoleWrite, (*Session).handleRestartScript)).Methods("POST")
+
+	// RAM search
+	router.HandleFunc("/search", s.withSession(server.RoleRead, (*Session).handleListSearches)).Methods("GET")
+	router.HandleFunc("/search", s.withSession(server.RoleWrite, (*Session).handleStartSearch)).Methods("POST")
+	router.HandleFunc("/search/{id}", s.withSession(server.RoleRead, (*Session).handleGetSearch)).Methods("GET")
+	router.HandleFunc("/search/{id}", s.withSession(server.RoleWrite, (*Session).handleDeleteSearch)).Methods("DELETE")
+	router.HandleFunc("/search/{id}/filter", s.withSession(server.RoleWrite, (*Session).handleFilterSearch)).Methods("POST")
 }
 
 // REST API Handlers
-func (s *PokemonWebServer) handleGetGameData(w http.ResponseWriter, r *http.Request) {
+func (s *Session) handleGetGameData(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.gameState.Load()
+
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(s.gameData); err != nil {
+	w.Header().Set("X-State-Seq", strconv.FormatUint(snapshot.Seq, 10))
+	if err := json.NewEncoder(w).Encode(snapshot.Data); err != nil {
 		http.Error(w, "Failed to encode game data", http.StatusInternalServerError)
 		return
 	}
 }
 
-func (s *PokemonWebServer) handleGetPokemon(w http.ResponseWriter, r *http.Request) {
+func (s *Session) handleGetPokemon(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.gameState.Load()
+
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(s.gameData.Pokemon); err != nil {
+	w.Header().Set("X-State-Seq", strconv.FormatUint(snapshot.Seq, 10))
+	if err := json.NewEncoder(w).Encode(snapshot.Data.Pokemon); err != nil {
 		http.Error(w, "Failed to encode Pokemon data", http.StatusInternalServerError)
 		return
 	}
 }
 
-func (s *PokemonWebServer) handleGetPokemonByID(w http.ResponseWriter, r *http.Request) {
+func (s *Session) handleGetPokemonByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -173,712 +368,227 @@ func (s *PokemonWebServer) handleGetPokemonByID(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	if id < 1 || id > len(s.gameData.Pokemon) {
+	snapshot := s.gameState.Load()
+	party := snapshot.Data.Pokemon
+
+	if id < 1 || id > len(party) {
 		http.Error(w, "Pokemon not found", http.StatusNotFound)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(s.gameData.Pokemon[id-1]); err != nil {
+	w.Header().Set("X-State-Seq", strconv.FormatUint(snapshot.Seq, 10))
+	if err := json.NewEncoder(w).Encode(party[id-1]); err != nil {
 		http.Error(w, "Failed to encode Pokemon data", http.StatusInternalServerError)
 		return
 	}
 }
 
-func (s *PokemonWebServer) handleGetPlayer(w http.ResponseWriter, r *http.Request) {
+func (s *Session) handleGetPlayer(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.gameState.Load()
+	data := snapshot.Data
+
 	playerData := map[string]interface{}{
-		"name":           s.gameData.PlayerName,
-		"id":             s.gameData.PlayerID,
-		"money":          s.gameData.Money,
-		"location":       s.gameData.LocationName,
-		"x":              s.gameData.PlayerX,
-		"y":              s.gameData.PlayerY,
-		"hours":          s.gameData.Hours,
-		"minutes":        s.gameData.Minutes,
-		"pokedex_seen":   s.gameData.PokedexSeen,
-		"pokedex_caught": s.gameData.PokedexCaught,
+		"name":           data.PlayerName,
+		"id":             data.PlayerID,
+		"money":          data.Money,
+		"location":       data.LocationName,
+		"x":              data.PlayerX,
+		"y":              data.PlayerY,
+		"hours":          data.Hours,
+		"minutes":        data.Minutes,
+		"pokedex_seen":   data.PokedexSeen,
+		"pokedex_caught": data.PokedexCaught,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-State-Seq", strconv.FormatUint(snapshot.Seq, 10))
 	json.NewEncoder(w).Encode(playerData)
 }
 
-func (s *PokemonWebServer) handleGetItems(w http.ResponseWriter, r *http.Request) {
+func (s *Session) handleGetItems(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.gameState.Load()
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(s.gameData.BagItems)
+	w.Header().Set("X-State-Seq", strconv.FormatUint(snapshot.Seq, 10))
+	json.NewEncoder(w).Encode(snapshot.Data.BagItems)
 }
 
-func (s *PokemonWebServer) handleGetBadges(w http.ResponseWriter, r *http.Request) {
+func (s *Session) handleGetBadges(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.gameState.Load()
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(s.gameData.Badges)
+	w.Header().Set("X-State-Seq", strconv.FormatUint(snapshot.Seq, 10))
+	json.NewEncoder(w).Encode(snapshot.Data.Badges)
 }
 
-func (s *PokemonWebServer) handleGetStatus(w http.ResponseWriter, r *http.Request) {
+func (s *Session) handleGetStatus(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.gameState.Load()
+
 	status := map[string]interface{}{
+		"session":           s.id,
 		"connected":         true,
-		"last_updated":      s.gameData.LastUpdated,
+		"seq":               snapshot.Seq,
+		"last_updated":      snapshot.Data.LastUpdated,
 		"websocket_clients": s.wsManager.GetClientCount(),
-		"game_loaded":       s.gameData.PlayerName != "",
+		"game_loaded":       snapshot.Data.PlayerName != "",
+		"poll":              s.poller.stats(),
+	}
+	if s.replay != nil {
+		status["replay"] = s.replay.Status()
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(status)
 }
 
-// Web Interface Handlers
-func (s *PokemonWebServer) handleHomePage(w http.ResponseWriter, r *http.Request) {
-	tmpl := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Pokemon Red/Blue Analyzer</title>
-    <style>
-        * { margin: 0; padding: 0; box-sizing: border-box; }
-        
-        body {
-            font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif;
-            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
-            min-height: 100vh;
-            color: #fff;
-        }
-        
-        .container {
-            max-width: 1200px;
-            margin: 0 auto;
-            padding: 20px;
-        }
-        
-        .header {
-            text-align: center;
-            margin-bottom: 40px;
-        }
-        
-        .header h1 {
-            font-size: 3rem;
-            margin-bottom: 10px;
-            text-shadow: 2px 2px 4px rgba(0,0,0,0.3);
-        }
-        
-        .status-card {
-            background: rgba(255,255,255,0.1);
-            backdrop-filter: blur(10px);
-            border-radius: 15px;
-            padding: 30px;
-            margin-bottom: 30px;
-            border: 1px solid rgba(255,255,255,0.2);
-        }
-        
-        .player-info {
-            display: grid;
-            grid-template-columns: repeat(auto-fit, minmax(250px, 1fr));
-            gap: 20px;
-            margin-bottom: 30px;
-        }
-        
-        .info-card {
-            background: rgba(255,255,255,0.1);
-            padding: 20px;
-            border-radius: 10px;
-            border: 1px solid rgba(255,255,255,0.2);
-        }
-        
-        .info-card h3 {
-            margin-bottom: 15px;
-            color: #FFD700;
-        }
-        
-        .pokemon-grid {
-            display: grid;
-            grid-template-columns: repeat(auto-fit, minmax(300px, 1fr));
-            gap: 20px;
-            margin-bottom: 30px;
-        }
-        
-        .pokemon-card {
-            background: rgba(255,255,255,0.15);
-            border-radius: 15px;
-            padding: 20px;
-            border: 1px solid rgba(255,255,255,0.3);
-            transition: transform 0.3s ease;
-        }
-        
-        .pokemon-card:hover {
-            transform: translateY(-5px);
-            background: rgba(255,255,255,0.2);
-        }
-        
-        .pokemon-header {
-            display: flex;
-            justify-content: space-between;
-            align-items: center;
-            margin-bottom: 15px;
-        }
-        
-        .pokemon-name {
-            font-size: 1.4rem;
-            font-weight: bold;
-            color: #FFD700;
-        }
-        
-        .pokemon-level {
-            background: #4CAF50;
-            color: white;
-            padding: 5px 10px;
-            border-radius: 20px;
-            font-size: 0.9rem;
-        }
-        
-        .hp-bar {
-            background: #333;
-            border-radius: 10px;
-            height: 20px;
-            margin: 10px 0;
-            overflow: hidden;
-        }
-        
-        .hp-fill {
-            height: 100%;
-            border-radius: 10px;
-            transition: width 0.3s ease;
-        }
-        
-        .hp-excellent { background: #4CAF50; }
-        .hp-good { background: #FFC107; }
-        .hp-okay { background: #FF9800; }
-        .hp-critical { background: #F44336; }
-        .hp-fainted { background: #9E9E9E; }
-        
-        .stats-grid {
-            display: grid;
-            grid-template-columns: repeat(2, 1fr);
-            gap: 10px;
-            margin: 15px 0;
-        }
-        
-        .stat {
-            background: rgba(0,0,0,0.2);
-            padding: 8px;
-            border-radius: 5px;
-        }
-        
-        .moves {
-            margin-top: 15px;
-        }
-        
-        .move-tag {
-            display: inline-block;
-            background: #6C63FF;
-            color: white;
-            padding: 4px 8px;
-            border-radius: 15px;
-            font-size: 0.8rem;
-            margin: 2px;
-        }
-        
-        .badges {
-            display: grid;
-            grid-template-columns: repeat(4, 1fr);
-            gap: 10px;
-            margin: 20px 0;
-        }
-        
-        .badge {
-            text-align: center;
-            padding: 10px;
-            border-radius: 10px;
-            border: 2px solid;
-        }
-        
-        .badge.obtained {
-            background: rgba(76, 175, 80, 0.3);
-            border-color: #4CAF50;
-            color: #4CAF50;
-        }
-        
-        .badge.not-obtained {
-            background: rgba(158, 158, 158, 0.3);
-            border-color: #9E9E9E;
-            color: #9E9E9E;
-        }
-        
-        .nav-buttons {
-            display: flex;
-            gap: 15px;
-            justify-content: center;
-            margin: 30px 0;
-        }
-        
-        .btn {
-            background: #6C63FF;
-            color: white;
-            padding: 12px 24px;
-            border: none;
-            border-radius: 25px;
-            cursor: pointer;
-            text-decoration: none;
-            font-size: 1rem;
-            transition: all 0.3s ease;
-        }
-        
-        .btn:hover {
-            background: #5A52E5;
-            transform: translateY(-2px);
-        }
-        
-        .loading {
-            text-align: center;
-            padding: 50px;
-            font-size: 1.2rem;
-        }
-        
-        .error {
-            background: rgba(244, 67, 54, 0.2);
-            border: 1px solid #F44336;
-            color: #F44336;
-            padding: 20px;
-            border-radius: 10px;
-            margin: 20px 0;
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <div class="header">
-            <h1>🎮 Pokemon Red/Blue Analyzer</h1>
-            <p>Real-time Pokemon game data analysis</p>
-        </div>
-        
-        <div class="nav-buttons">
-            <a href="/" class="btn">📊 Overview</a>
-            <a href="/live" class="btn">📡 Live Data</a>
-            <a href="/api/gamedata" class="btn" target="_blank">🔗 JSON API</a>
-        </div>
-        
-        <div id="content" class="loading">
-            Loading Pokemon data...
-        </div>
-    </div>
-
-    <script>
-        async function loadGameData() {
-            try {
-                const response = await fetch('/api/gamedata');
-                const data = await response.json();
-                displayGameData(data);
-            } catch (error) {
-                displayError('Failed to load game data: ' + error.message);
-            }
-        }
-        
-        function displayGameData(data) {
-            const content = document.getElementById('content');
-            
-            if (!data.player_name) {
-                content.innerHTML = '<div class="error">No Pokemon game detected. Make sure RetroArch is running with Pokemon Red/Blue.</div>';
-                return;
-            }
-            
-            const hpColor = (percent) => {
-                if (percent > 75) return 'hp-excellent';
-                if (percent > 50) return 'hp-good';
-                if (percent > 25) return 'hp-okay';
-                if (percent > 0) return 'hp-critical';
-                return 'hp-fainted';
-            };
-            
-            let html = ` + "`" + `
-                <div class="status-card">
-                    <h2>🎮 Game Status</h2>
-                    <div class="player-info">
-                        <div class="info-card">
-                            <h3>👤 Trainer</h3>
-                            <p><strong>Name:</strong> ${data.player_name}</p>
-                            <p><strong>ID:</strong> ${data.player_id}</p>
-                            <p><strong>Money:</strong> $${data.money.toLocaleString()}</p>
-                        </div>
-                        <div class="info-card">
-                            <h3>📍 Location</h3>
-                            <p><strong>Area:</strong> ${data.location_name}</p>
-                            <p><strong>Position:</strong> (${data.player_x}, ${data.player_y})</p>
-                        </div>
-                        <div class="info-card">
-                            <h3>⏰ Playtime</h3>
-                            <p><strong>Time:</strong> ${String(data.hours).padStart(2, '0')}:${String(data.minutes).padStart(2, '0')}</p>
-                        </div>
-                        <div class="info-card">
-                            <h3>📚 Pokedex</h3>
-                            <p><strong>Seen:</strong> ${data.pokedex_seen}</p>
-                            <p><strong>Caught:</strong> ${data.pokedex_caught}</p>
-                        </div>
-                    </div>
-                </div>
-            ` + "`" + `;
-            
-            if (data.badges && data.badges.length > 0) {
-                html += ` + "`" + `
-                    <div class="status-card">
-                        <h2>🏆 Gym Badges</h2>
-                        <div class="badges">
-                            ${data.badges.map(badge => ` + "`" + `
-                                <div class="badge ${badge.obtained ? 'obtained' : 'not-obtained'}">
-                                    ${badge.obtained ? '✅' : '❌'}<br>
-                                    ${badge.name.replace(' Badge', '')}
-                                </div>
-                            ` + "`" + `).join('')}
-                        </div>
-                    </div>
-                ` + "`" + `;
-            }
-            
-            if (data.pokemon && data.pokemon.length > 0) {
-                html += ` + "`" + `
-                    <div class="status-card">
-                        <h2>🐾 Pokemon Team</h2>
-                        <div class="pokemon-grid">
-                            ${data.pokemon.map(pokemon => ` + "`" + `
-                                <div class="pokemon-card">
-                                    <div class="pokemon-header">
-                                        <div class="pokemon-name">${pokemon.name}</div>
-                                        <div class="pokemon-level">Lv. ${pokemon.level}</div>
-                                    </div>
-                                    <p><strong>Type:</strong> ${pokemon.type1_name}${pokemon.type1_name !== pokemon.type2_name ? '/' + pokemon.type2_name : ''}</p>
-                                    <p><strong>Status:</strong> ${pokemon.status_name}</p>
-                                    
-                                    <div class="hp-bar">
-                                        <div class="hp-fill ${hpColor(pokemon.hp_percent)}" style="width: ${pokemon.hp_percent}%"></div>
-                                    </div>
-                                    <p><strong>HP:</strong> ${pokemon.current_hp}/${pokemon.max_hp} (${pokemon.hp_percent.toFixed(1)}%)</p>
-                                    
-                                    <div class="stats-grid">
-                                        <div class="stat"><strong>ATK:</strong> ${pokemon.attack}</div>
-                                        <div class="stat"><strong>DEF:</strong> ${pokemon.defense}</div>
-                                        <div class="stat"><strong>SPD:</strong> ${pokemon.speed}</div>
-                                        <div class="stat"><strong>SPC:</strong> ${pokemon.special}</div>
-                                    </div>
-                                    
-                                    <p><strong>EXP:</strong> ${pokemon.exp_points.toLocaleString()}</p>
-                                    
-                                    ${pokemon.moves && pokemon.moves.length > 0 ? ` + "`" + `
-                                        <div class="moves">
-                                            <strong>Moves:</strong><br>
-                                            ${pokemon.moves.map(move => ` + "`" + `<span class="move-tag">${move.name}</span>` + "`" + `).join('')}
-                                        </div>
-                                    ` + "`" + ` : ''}
-                                </div>
-                            ` + "`" + `).join('')}
-                        </div>
-                    </div>
-                ` + "`" + `;
-            }
-            
-            if (data.bag_items && data.bag_items.length > 0) {
-                html += ` + "`" + `
-                    <div class="status-card">
-                        <h2>🎒 Bag Items</h2>
-                        <div class="pokemon-grid">
-                            ${data.bag_items.map(item => ` + "`" + `
-                                <div class="info-card">
-                                    <strong>${item.name}</strong><br>
-                                    Quantity: ${item.quantity}
-                                </div>
-                            ` + "`" + `).join('')}
-                        </div>
-                    </div>
-                ` + "`" + `;
-            }
-            
-            content.innerHTML = html;
-        }
-        
-        function displayError(message) {
-            document.getElementById('content').innerHTML = ` + "`" + `<div class="error">${message}</div>` + "`" + `;
-        }
-        
-        // Load data on page load
-        loadGameData();
-        
-        // Refresh every 5 seconds
-        setInterval(loadGameData, 5000);
-    </script>
-</body>
-</html>`
-
-	w.Header().Set("Content-Type", "text/html")
-	fmt.Fprint(w, tmpl)
+func (s *Session) handleGetPollStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.poller.stats())
+}
+
+func (s *PokemonWebServer) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.config)
 }
 
-func (s *PokemonWebServer) handleLivePage(w http.ResponseWriter, r *http.Request) {
-	tmpl := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Pokemon Live Data</title>
-    <style>
-        /* Same styles as home page */
-        * { margin: 0; padding: 0; box-sizing: border-box; }
-        body {
-            font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif;
-            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
-            min-height: 100vh;
-            color: #fff;
-        }
-        .container { max-width: 1200px; margin: 0 auto; padding: 20px; }
-        .header { text-align: center; margin-bottom: 40px; }
-        .header h1 { font-size: 3rem; margin-bottom: 10px; text-shadow: 2px 2px 4px rgba(0,0,0,0.3); }
-        .status-indicator { 
-            display: inline-block; 
-            width: 10px; 
-            height: 10px; 
-            border-radius: 50%; 
-            margin-right: 10px;
-        }
-        .connected { background: #4CAF50; }
-        .disconnected { background: #F44336; }
-        .live-data { 
-            background: rgba(255,255,255,0.1); 
-            backdrop-filter: blur(10px); 
-            border-radius: 15px; 
-            padding: 30px; 
-            margin-bottom: 30px; 
-            border: 1px solid rgba(255,255,255,0.2); 
-        }
-        .btn {
-            background: #6C63FF;
-            color: white;
-            padding: 12px 24px;
-            border: none;
-            border-radius: 25px;
-            cursor: pointer;
-            text-decoration: none;
-            font-size: 1rem;
-            transition: all 0.3s ease;
-            display: inline-block;
-            margin: 5px;
-        }
-        .btn:hover { background: #5A52E5; transform: translateY(-2px); }
-        pre { background: rgba(0,0,0,0.3); padding: 20px; border-radius: 10px; overflow-x: auto; }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <div class="header">
-            <h1>📡 Pokemon Live Data</h1>
-            <p>Real-time WebSocket connection</p>
-        </div>
-        
-        <div style="text-align: center; margin-bottom: 30px;">
-            <a href="/" class="btn">📊 Back to Overview</a>
-            <button onclick="toggleConnection()" class="btn" id="connectBtn">🔌 Connect</button>
-            <button onclick="clearLog()" class="btn">🗑️ Clear Log</button>
-        </div>
-        
-        <div class="live-data">
-            <h2>
-                <span id="status-indicator" class="status-indicator disconnected"></span>
-                WebSocket Status: <span id="connection-status">Disconnected</span>
-            </h2>
-            <p>Messages: <span id="message-count">0</span></p>
-            <p>Last update: <span id="last-update">Never</span></p>
-        </div>
-        
-        <div class="live-data">
-            <h3>📊 Live Pokemon Data</h3>
-            <pre id="pokemon-data">Connecting...</pre>
-        </div>
-        
-        <div class="live-data">
-            <h3>📝 WebSocket Log</h3>
-            <pre id="websocket-log">WebSocket log will appear here...</pre>
-        </div>
-    </div>
-
-    <script>
-        let ws = null;
-        let messageCount = 0;
-        let isConnected = false;
-        
-        function updateStatus(connected) {
-            isConnected = connected;
-            const indicator = document.getElementById('status-indicator');
-            const status = document.getElementById('connection-status');
-            const connectBtn = document.getElementById('connectBtn');
-            
-            if (connected) {
-                indicator.className = 'status-indicator connected';
-                status.textContent = 'Connected';
-                connectBtn.textContent = '🔌 Disconnect';
-            } else {
-                indicator.className = 'status-indicator disconnected';
-                status.textContent = 'Disconnected';
-                connectBtn.textContent = '🔌 Connect';
-            }
-        }
-        
-        function addToLog(message) {
-            const log = document.getElementById('websocket-log');
-            const timestamp = new Date().toLocaleTimeString();
-            log.textContent += ` + "`" + `[${timestamp}] ${message}\n` + "`" + `;
-            log.scrollTop = log.scrollHeight;
-        }
-        
-        function connectWebSocket() {
-            const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-            const wsUrl = ` + "`" + `${protocol}//${window.location.host}/ws` + "`" + `;
-            
-            addToLog('Connecting to ' + wsUrl);
-            
-            ws = new WebSocket(wsUrl);
-            
-            ws.onopen = function() {
-                updateStatus(true);
-                addToLog('WebSocket connected successfully');
-                
-                // Request status
-                ws.send(JSON.stringify({
-                    type: 'get_status'
-                }));
-            };
-            
-            ws.onmessage = function(event) {
-                messageCount++;
-                document.getElementById('message-count').textContent = messageCount;
-                document.getElementById('last-update').textContent = new Date().toLocaleString();
-                
-                try {
-                    const message = JSON.parse(event.data);
-                    addToLog(` + "`" + `Received: ${message.type}` + "`" + `);
-                    
-                    if (message.type === 'pokemon_update') {
-                        document.getElementById('pokemon-data').textContent = JSON.stringify(message.data, null, 2);
-                    }
-                } catch (e) {
-                    addToLog('Failed to parse message: ' + e.message);
-                }
-            };
-            
-            ws.onclose = function() {
-                updateStatus(false);
-                addToLog('WebSocket connection closed');
-            };
-            
-            ws.onerror = function(error) {
-                addToLog('WebSocket error: ' + error.message);
-            };
-        }
-        
-        function disconnectWebSocket() {
-            if (ws) {
-                ws.close();
-                ws = null;
-            }
-        }
-        
-        function toggleConnection() {
-            if (isConnected) {
-                disconnectWebSocket();
-            } else {
-                connectWebSocket();
-            }
-        }
-        
-        function clearLog() {
-            document.getElementById('websocket-log').textContent = '';
-            messageCount = 0;
-            document.getElementById('message-count').textContent = '0';
-        }
-        
-        // Auto-connect on page load
-        connectWebSocket();
-        
-        // Fetch and display Pokemon data via REST API
-        async function fetchPokemonData() {
-            try {
-                const response = await fetch('/api/gamedata');
-                const data = await response.json();
-                document.getElementById('pokemon-data').textContent = JSON.stringify(data, null, 2);
-            } catch (error) {
-                document.getElementById('pokemon-data').textContent = 'Error fetching data: ' + error.message;
-            }
-        }
-        
-        // Refresh Pokemon data every 2 seconds
-        setInterval(fetchPokemonData, 2000);
-        fetchPokemonData(); // Initial load
-    </script>
-</body>
-</html>`
-
-	w.Header().Set("Content-Type", "text/html")
-	fmt.Fprint(w, tmpl)
+func (s *Session) handleGetWebSocketClients(w http.ResponseWriter, r *http.Request) {
+	config := s.wsManager.Config()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"queue_size":           config.QueueSize,
+		"slow_consumer_policy": config.SlowConsumerPolicy,
+		"clients":              s.wsManager.ClientStats(),
+	})
 }
 
 // monitorPokemonData continuously reads Pokemon data and broadcasts changes
-func (s *PokemonWebServer) monitorPokemonData() {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
+// until ctx is cancelled. Each tick reads only the property groups whose
+// priority makes them due.
+func (s *Session) monitorPokemonData(ctx context.Context) {
+	log.Printf("🔄 [%s] Starting Pokemon data monitoring every %v...", s.id, s.poller.interval())
 
-	log.Println("🔄 Starting Pokemon data monitoring...")
+	next := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			newData := s.readCompleteGameData()
-			if newData != nil {
-				// Check for changes and broadcast via WebSocket
-				if s.hasDataChanged(newData) {
-					s.gameData = newData
-					s.gameData.LastUpdated = time.Now()
-
-					// Broadcast update to WebSocket clients
-					s.wsManager.BroadcastMessage(server.Message{
-						Type:      "pokemon_update",
-						Data:      s.gameData,
-						Timestamp: time.Now(),
-					})
-				}
+		case <-ctx.Done():
+			log.Printf("🔄 [%s] Pokemon data monitoring stopped", s.id)
+			return
+
+		case <-timer.C:
+			start := time.Now()
+			interval := s.poller.interval()
+			late := start.Sub(next)
+			if late > interval {
+				// Rebase so one slow tick does not leave every later tick behind
+				next = start
 			}
+
+			s.applyFreezes()
+			changes := s.pollGroups(s.poller.dueGroups())
+			s.pollMemoryWindows()
+			s.pollWatchpoints()
+			s.tickScripts(changes)
+			s.updateSplits()
+
+			s.poller.recordTick(start, late, time.Since(start))
+			next = next.Add(s.poller.interval())
+			timer.Reset(time.Until(next))
 		}
 	}
 }
 
-// hasDataChanged compares new data with existing data
-func (s *PokemonWebServer) hasDataChanged(newData *GameData) bool {
-	if s.gameData == nil {
-		return true
+// pollGroups reads the given property groups on top of the current snapshot,
+// publishes the result as a new version, broadcasts its field-level changes and
+// returns them. The monitor goroutine is the only writer of the game state.
+func (s *Session) pollGroups(groups []pollGroup) []state.Change {
+	current := s.gameState.Load()
+
+	// A new recording starts with a keyframe of the current version. Only
+	// this goroutine publishes, so no version can fall between the keyframe
+	// and the next delta.
+	if recorder := s.recorder.Load(); recorder != nil && !recorder.Started() {
+		recorder.Record(current.Seq, time.Now(), nil, current.Data)
 	}
 
-	// Simple comparison - might want a more sophisticated change detection
-	return s.gameData.PlayerName != newData.PlayerName ||
-		s.gameData.Money != newData.Money ||
-		s.gameData.LocationName != newData.LocationName ||
-		len(s.gameData.Pokemon) != len(newData.Pokemon) ||
-		(len(newData.Pokemon) > 0 && len(s.gameData.Pokemon) > 0 &&
-			s.gameData.Pokemon[0].CurrentHP != newData.Pokemon[0].CurrentHP)
-}
+	// Group readers replace slices wholesale, so a shallow copy never
+	// modifies the published snapshot
+	newData := *current.Data
+	for _, group := range groups {
+		group.read(s, &newData)
+	}
 
-// corsMiddleware adds CORS headers
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	changes := s.diffGameData(current.Data, &newData)
+	if len(changes) == 0 {
+		return nil
+	}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	newData.LastUpdated = time.Now()
+	snapshot := s.gameState.Publish(&newData)
+
+	if recorder := s.recorder.Load(); recorder != nil {
+		recorder.Record(snapshot.Seq, newData.LastUpdated, changes, snapshot.Data)
+	}
+	if current.Seq == 0 {
+		s.recordHistorySnapshot(&newData)
+	} else {
+		s.recordHistory(changes, newData.LastUpdated)
+	}
 
-		next.ServeHTTP(w, r)
+	s.wsManager.BroadcastMessage(server.Message{
+		Type:      "pokemon_diff",
+		Seq:       snapshot.Seq,
+		Data:      server.ChangeSet{Changes: changes},
+		Timestamp: newData.LastUpdated,
 	})
+	return changes
+}
+
+// diffGameData computes the field-level changes between two versions of the game data
+func (s *Session) diffGameData(oldData, newData *GameData) []state.Change {
+	changes, err := state.Diff(oldData, newData, "/last_updated")
+	if err != nil {
+		log.Printf("⚠️  Failed to diff game data: %v", err)
+		return nil
+	}
+	return changes
+}
+
+// snapshotMessage builds the full-state message sent to newly connected clients
+func (s *Session) snapshotMessage() *server.Message {
+	snapshot := s.gameState.Load()
+	return &server.Message{
+		Type:      "pokemon_update",
+		Seq:       snapshot.Seq,
+		Data:      snapshot.Data,
+		Timestamp: time.Now(),
+	}
 }
 
 func main() {
-	server := NewPokemonWebServer()
-	server.Start("8080")
+	config, err := LoadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	server, err := NewPokemonWebServer(config)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := server.Run(ctx); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
 }
 
 // Pokemon Red/Blue Memory Layout
@@ -937,12 +647,15 @@ const (
 	// Battle data (when in battle)
 	BATTLE_MODE_ADDR = 0xD057 // Battle mode
 	BATTLE_TYPE_ADDR = 0xD05A // Battle type
-)
 
-func (s *PokemonWebServer) readCompleteGameData() *GameData {
-	data := &GameData{}
+	// Event flags (bit arrays, flag N is bit N%8 of byte N/8)
+	EVENT_FLAGS_ADDR       = 0xD747 // Story, gift and trainer flags (320 bytes)
+	HIDDEN_ITEM_FLAGS_ADDR = 0xD6F0 // Hidden items picked up (14 bytes)
+	HIDDEN_COIN_FLAGS_ADDR = 0xD6FE // Hidden Game Corner coins picked up (2 bytes)
+)
 
-	// Read player data
+// readPlayerData reads trainer identity, money and overworld position
+func (s *Session) readPlayerData(data *GameData) {
 	if nameBytes, err := s.driver.ReadMemory(PLAYER_NAME_ADDR, 11); err == nil {
 		data.PlayerName = convertPokemonText(nameBytes)
 	}
@@ -955,10 +668,6 @@ func (s *PokemonWebServer) readCompleteGameData() *GameData {
 		data.Money = decodeBCD(moneyBytes)
 	}
 
-	if teamBytes, err := s.driver.ReadMemory(TEAM_COUNT_ADDR, 1); err == nil {
-		data.TeamCount = teamBytes[0]
-	}
-
 	if mapBytes, err := s.driver.ReadMemory(CURRENT_MAP_ADDR, 1); err == nil {
 		data.CurrentMap = mapBytes[0]
 		data.LocationName = getLocationName(data.CurrentMap)
@@ -971,11 +680,10 @@ func (s *PokemonWebServer) readCompleteGameData() *GameData {
 	if yBytes, err := s.driver.ReadMemory(PLAYER_Y_ADDR, 1); err == nil {
 		data.PlayerY = yBytes[0]
 	}
+}
 
-	data.Badges = s.readBadges()
-
-	data.PokedexSeen, data.PokedexCaught = s.readPokedexCounts()
-
+// readPlayTime reads the in-game clock
+func (s *Session) readPlayTime(data *GameData) {
 	// Read game time (corrected format)
 	if hoursBytes, err := s.driver.ReadMemory(GAME_HOURS_ADDR, 2); err == nil {
 		// Hours stored as 2 bytes, big endian works better
@@ -990,24 +698,33 @@ func (s *PokemonWebServer) readCompleteGameData() *GameData {
 	if secondsBytes, err := s.driver.ReadMemory(GAME_SECONDS_ADDR, 1); err == nil {
 		data.Seconds = secondsBytes[0]
 	}
+}
 
-	data.BagItems = s.readBagItems()
-	data.BagItemCount = uint8(len(data.BagItems))
+// readParty reads the party size and every Pokemon in the party
+func (s *Session) readParty(data *GameData) {
+	if teamBytes, err := s.driver.ReadMemory(TEAM_COUNT_ADDR, 1); err == nil {
+		data.TeamCount = teamBytes[0]
+	}
 
 	pokemonAddresses := []uint32{
 		POKEMON_1_ADDR, POKEMON_2_ADDR, POKEMON_3_ADDR,
 		POKEMON_4_ADDR, POKEMON_5_ADDR, POKEMON_6_ADDR,
 	}
 
-	data.Pokemon = make([]Pokemon, 0, data.TeamCount)
+	party := make([]Pokemon, 0, data.TeamCount)
 
 	for i := 0; i < int(data.TeamCount) && i < len(pokemonAddresses); i++ {
 		pokemon := s.readPokemon(pokemonAddresses[i])
 		if pokemon != nil {
-			data.Pokemon = append(data.Pokemon, *pokemon)
+			party = append(party, *pokemon)
 		}
 	}
 
+	data.Pokemon = party
+}
+
+// readBattleState reads the current battle mode and type
+func (s *Session) readBattleState(data *GameData) {
 	if battleModeBytes, err := s.driver.ReadMemory(BATTLE_MODE_ADDR, 1); err == nil {
 		data.BattleMode = getBattleMode(battleModeBytes[0])
 	}
@@ -1015,11 +732,21 @@ func (s *PokemonWebServer) readCompleteGameData() *GameData {
 	if battleTypeBytes, err := s.driver.ReadMemory(BATTLE_TYPE_ADDR, 1); err == nil {
 		data.BattleType = getBattleType(battleTypeBytes[0])
 	}
+}
 
-	return data
+// readProgress reads badges and Pokedex completion
+func (s *Session) readProgress(data *GameData) {
+	data.Badges = s.readBadges()
+	data.PokedexSeen, data.PokedexCaught = s.readPokedexCounts()
 }
 
-func (s *PokemonWebServer) readPokemon(baseAddr uint32) *Pokemon {
+// readBag reads the bag contents
+func (s *Session) readBag(data *GameData) {
+	data.BagItems = s.readBagItems()
+	data.BagItemCount = uint8(len(data.BagItems))
+}
+
+func (s *Session) readPokemon(baseAddr uint32) *Pokemon {
 	pokemon := &Pokemon{}
 
 	// Read species
@@ -1106,7 +833,7 @@ func (s *PokemonWebServer) readPokemon(baseAddr uint32) *Pokemon {
 	return pokemon
 }
 
-func (s *PokemonWebServer) readBadges() []Badge {
+func (s *Session) readBadges() []Badge {
 	badges := []Badge{
 		{"Boulder Badge", false},
 		{"Cascade Badge", false},
@@ -1128,7 +855,7 @@ func (s *PokemonWebServer) readBadges() []Badge {
 	return badges
 }
 
-func (s *PokemonWebServer) readPokedexCounts() (int, int) {
+func (s *Session) readPokedexCounts() (int, int) {
 	seen := 0
 	caught := 0
 
@@ -1157,7 +884,7 @@ func (s *PokemonWebServer) readPokedexCounts() (int, int) {
 	return seen, caught
 }
 
-func (s *PokemonWebServer) readBagItems() []Item {
+func (s *Session) readBagItems() []Item {
 	items := []Item{}
 
 	if countBytes, err := s.driver.ReadMemory(BAG_ITEM_COUNT_ADDR, 1); err == nil {
@@ -1183,22 +910,23 @@ func (s *PokemonWebServer) readBagItems() []Item {
 	return items
 }
 
+// pokemonChars maps the Gen 1 character encoding to text
+var pokemonChars = map[byte]string{
+	0x80: "A", 0x81: "B", 0x82: "C", 0x83: "D", 0x84: "E", 0x85: "F", 0x86: "G",
+	0x87: "H", 0x88: "I", 0x89: "J", 0x8A: "K", 0x8B: "L", 0x8C: "M", 0x8D: "N",
+	0x8E: "O", 0x8F: "P", 0x90: "Q", 0x91: "R", 0x92: "S", 0x93: "T", 0x94: "U",
+	0x95: "V", 0x96: "W", 0x97: "X", 0x98: "Y", 0x99: "Z", 0x9A: "(", 0x9B: ")",
+	0x9C: ":", 0x9D: ";", 0x9E: "[", 0x9F: "]", 0xA0: "a", 0xA1: "b", 0xA2: "c",
+	0xA3: "d", 0xA4: "e", 0xA5: "f", 0xA6: "g", 0xA7: "h", 0xA8: "i", 0xA9: "j",
+	0xAA: "k", 0xAB: "l", 0xAC: "m", 0xAD: "n", 0xAE: "o", 0xAF: "p", 0xB0: "q",
+	0xB1: "r", 0xB2: "s", 0xB3: "t", 0xB4: "u", 0xB5: "v", 0xB6: "w", 0xB7: "x",
+	0xB8: "y", 0xB9: "z", 0xF6: "0", 0xF7: "1", 0xF8: "2", 0xF9: "3", 0xFA: "4",
+	0xFB: "5", 0xFC: "6", 0xFD: "7", 0xFE: "8", 0xFF: "9", 0x50: " ", 0x00: "",
+	0xEF: "♂", 0xF5: "♀", 0x7F: " ",
+}
+
 // Helper functions
 func convertPokemonText(data []byte) string {
-	pokemonChars := map[byte]string{
-		0x80: "A", 0x81: "B", 0x82: "C", 0x83: "D", 0x84: "E", 0x85: "F", 0x86: "G",
-		0x87: "H", 0x88: "I", 0x89: "J", 0x8A: "K", 0x8B: "L", 0x8C: "M", 0x8D: "N",
-		0x8E: "O", 0x8F: "P", 0x90: "Q", 0x91: "R", 0x92: "S", 0x93: "T", 0x94: "U",
-		0x95: "V", 0x96: "W", 0x97: "X", 0x98: "Y", 0x99: "Z", 0x9A: "(", 0x9B: ")",
-		0x9C: ":", 0x9D: ";", 0x9E: "[", 0x9F: "]", 0xA0: "a", 0xA1: "b", 0xA2: "c",
-		0xA3: "d", 0xA4: "e", 0xA5: "f", 0xA6: "g", 0xA7: "h", 0xA8: "i", 0xA9: "j",
-		0xAA: "k", 0xAB: "l", 0xAC: "m", 0xAD: "n", 0xAE: "o", 0xAF: "p", 0xB0: "q",
-		0xB1: "r", 0xB2: "s", 0xB3: "t", 0xB4: "u", 0xB5: "v", 0xB6: "w", 0xB7: "x",
-		0xB8: "y", 0xB9: "z", 0xF6: "0", 0xF7: "1", 0xF8: "2", 0xF9: "3", 0xFA: "4",
-		0xFB: "5", 0xFC: "6", 0xFD: "7", 0xFE: "8", 0xFF: "9", 0x50: " ", 0x00: "",
-		0xEF: "♂", 0xF5: "♀",
-	}
-
 	result := ""
 	for _, b := range data {
 		if b == 0x50 || b == 0x00 {
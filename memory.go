@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"RetroGameAnalysis/connection"
+	"RetroGameAnalysis/server"
+)
+
+// Memory window limits
+const (
+	maxMemoryWindow  = 0x1000 // Bytes in one window
+	maxMemoryWindows = 32     // Windows per session across all clients
+)
+
+// defaultMemoryLength is the number of bytes GET /api/memory returns without a length
+const defaultMemoryLength = 256
+
+// gameBoyBlocks are the cartridge RAM, work RAM and high RAM of the Game Boy
+var gameBoyBlocks = []connection.MemoryBlock{
+	{Name: "SRAM", Start: 0xA000, End: 0xBFFF},
+	{Name: "WRAM", Start: 0xC000, End: 0xDFFF},
+	{Name: "HRAM", Start: 0xFF80, End: 0xFFFE},
+}
+
+// gameBoyAdvanceBlocks are the external and internal work RAM of the Game Boy Advance
+var gameBoyAdvanceBlocks = []connection.MemoryBlock{
+	{Name: "EWRAM", Start: 0x02000000, End: 0x0203FFFF},
+	{Name: "IWRAM", Start: 0x03000000, End: 0x03007FFF},
+}
+
+// defaultMemoryBlocks lists the RAM of each platform, used when a session
+// does not configure its memory blocks
+var defaultMemoryBlocks = map[string][]connection.MemoryBlock{
+	"GB":               gameBoyBlocks,
+	"GBC":              gameBoyBlocks,
+	"GAME BOY":         gameBoyBlocks,
+	"GAMEBOY":          gameBoyBlocks,
+	"GBA":              gameBoyAdvanceBlocks,
+	"GAME BOY ADVANCE": gameBoyAdvanceBlocks,
+	"NES":              {{Name: "WRAM", Start: 0x0000, End: 0x07FF}, {Name: "SRAM", Start: 0x6000, End: 0x7FFF}},
+	"NINTENDO":         {{Name: "WRAM", Start: 0x0000, End: 0x07FF}, {Name: "SRAM", Start: 0x6000, End: 0x7FFF}},
+	"SNES":             {{Name: "WRAM", Start: 0x7E0000, End: 0x7FFFFF}},
+	"SUPER NINTENDO":   {{Name: "WRAM", Start: 0x7E0000, End: 0x7FFFFF}},
+	"NDS":              {{Name: "MAIN", Start: 0x02000000, End: 0x023FFFFF}},
+	"NINTENDO DS":      {{Name: "MAIN", Start: 0x02000000, End: 0x023FFFFF}},
+}
+
+// ParseMemoryBlocks parses blocks in the form "WRAM=0xC000-0xDFFF,SRAM=0xA000-0xBFFF"
+func ParseMemoryBlocks(spec string) (map[string]string, error) {
+	blocks := make(map[string]string)
+	for _, entry := range splitList(spec) {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid memory block %q: expected name=start-end", entry)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if _, err := parseMemoryBlock(name, value); err != nil {
+			return nil, err
+		}
+		blocks[name] = value
+	}
+	return blocks, nil
+}
+
+// parseMemoryBlock parses an inclusive range such as 0xC000-0xDFFF
+func parseMemoryBlock(name, value string) (connection.MemoryBlock, error) {
+	if name == "" {
+		return connection.MemoryBlock{}, fmt.Errorf("memory block %q has no name", value)
+	}
+	first, last, ok := strings.Cut(value, "-")
+	if !ok {
+		return connection.MemoryBlock{}, fmt.Errorf("invalid memory block %s=%q: expected start-end", name, value)
+	}
+	start, err := strconv.ParseUint(strings.TrimSpace(first), 0, 32)
+	if err != nil {
+		return connection.MemoryBlock{}, fmt.Errorf("invalid start of memory block %s: %q", name, first)
+	}
+	end, err := strconv.ParseUint(strings.TrimSpace(last), 0, 32)
+	if err != nil {
+		return connection.MemoryBlock{}, fmt.Errorf("invalid end of memory block %s: %q", name, last)
+	}
+	if end < start {
+		return connection.MemoryBlock{}, fmt.Errorf("memory block %s ends before it starts", name)
+	}
+	return connection.MemoryBlock{Name: name, Start: uint32(start), End: uint32(end)}, nil
+}
+
+// memoryBlocks returns the session's configured memory blocks, or the
+// platform's RAM when none are configured
+func (s *Session) memoryBlocks() ([]connection.MemoryBlock, error) {
+	if len(s.config.MemoryBlocks) > 0 {
+		return s.config.Blocks()
+	}
+	blocks, ok := defaultMemoryBlocks[strings.ToUpper(strings.TrimSpace(s.platform()))]
+	if !ok {
+		return nil, fmt.Errorf("no memory blocks are configured and platform %q has no defaults", s.platform())
+	}
+	return blocks, nil
+}
+
+// platform returns the session's platform, taken from the capture for replays
+func (s *Session) platform() string {
+	if s.replay != nil && s.replay.Platform() != "" {
+		return s.replay.Platform()
+	}
+	return s.config.Platform
+}
+
+// MemoryAnnotation names a range of memory, either a mapper property or one
+// of the memory layout constants
+type MemoryAnnotation struct {
+	Name        string `json:"name"`
+	Address     uint32 `json:"address"`
+	Length      uint32 `json:"length"`
+	Source      string `json:"source"` // property or constant
+	Description string `json:"description"`
+}
+
+// memoryConstants mirrors the memory layout constants, which cannot be
+// enumerated at runtime
+var memoryConstants = []MemoryAnnotation{
+	{"PLAYER_NAME_ADDR", PLAYER_NAME_ADDR, 11, "constant", "Player name"},
+	{"PLAYER_ID_ADDR", PLAYER_ID_ADDR, 2, "constant", "Player ID"},
+	{"MONEY_ADDR", MONEY_ADDR, 3, "constant", "Money (BCD)"},
+	{"TEAM_COUNT_ADDR", TEAM_COUNT_ADDR, 1, "constant", "Number of Pokemon in party"},
+	{"CURRENT_MAP_ADDR", CURRENT_MAP_ADDR, 1, "constant", "Current map ID"},
+	{"PLAYER_X_ADDR", PLAYER_X_ADDR, 1, "constant", "Player X position"},
+	{"PLAYER_Y_ADDR", PLAYER_Y_ADDR, 1, "constant", "Player Y position"},
+	{"BADGES_ADDR", BADGES_ADDR, 1, "constant", "Badge bitfield"},
+	{"POKEDEX_SEEN_ADDR", POKEDEX_SEEN_ADDR, 19, "constant", "Seen Pokemon bit array"},
+	{"POKEDEX_CAUGHT_ADDR", POKEDEX_CAUGHT_ADDR, 19, "constant", "Caught Pokemon bit array"},
+	{"GAME_HOURS_ADDR", GAME_HOURS_ADDR, 2, "constant", "Play time hours"},
+	{"GAME_MINUTES_ADDR", GAME_MINUTES_ADDR, 1, "constant", "Play time minutes"},
+	{"GAME_SECONDS_ADDR", GAME_SECONDS_ADDR, 1, "constant", "Play time seconds"},
+	{"GAME_FRAMES_ADDR", GAME_FRAMES_ADDR, 1, "constant", "Play time frames"},
+	{"BAG_ITEM_COUNT_ADDR", BAG_ITEM_COUNT_ADDR, 1, "constant", "Number of items in bag"},
+	{"BAG_ITEMS_ADDR", BAG_ITEMS_ADDR, 40, "constant", "Bag items (2 bytes per item)"},
+	{"POKEMON_1_ADDR", POKEMON_1_ADDR, 44, "constant", "Party Pokemon #1"},
+	{"POKEMON_2_ADDR", POKEMON_2_ADDR, 44, "constant", "Party Pokemon #2"},
+	{"POKEMON_3_ADDR", POKEMON_3_ADDR, 44, "constant", "Party Pokemon #3"},
+	{"POKEMON_4_ADDR", POKEMON_4_ADDR, 44, "constant", "Party Pokemon #4"},
+	{"POKEMON_5_ADDR", POKEMON_5_ADDR, 44, "constant", "Party Pokemon #5"},
+	{"POKEMON_6_ADDR", POKEMON_6_ADDR, 44, "constant", "Party Pokemon #6"},
+	{"BATTLE_MODE_ADDR", BATTLE_MODE_ADDR, 1, "constant", "Battle mode"},
+	{"BATTLE_TYPE_ADDR", BATTLE_TYPE_ADDR, 1, "constant", "Battle type"},
+	{"HIDDEN_ITEM_FLAGS_ADDR", HIDDEN_ITEM_FLAGS_ADDR, hiddenItemFlagBytes, "constant", "Hidden items picked up (bit array)"},
+	{"HIDDEN_COIN_FLAGS_ADDR", HIDDEN_COIN_FLAGS_ADDR, hiddenCoinFlagBytes, "constant", "Hidden coins picked up (bit array)"},
+	{"EVENT_FLAGS_ADDR", EVENT_FLAGS_ADDR, eventFlagBytes, "constant", "Event flags (bit array)"},
+}
+
+// memoryAnnotations lists every property and constant overlapping
+// [start, start+length), sorted by address
+func memoryAnnotations(start uint32, length uint64) []MemoryAnnotation {
+	end := uint64(start) + length
+	overlaps := func(address, size uint32) bool {
+		return uint64(address) < end && uint64(address)+uint64(size) > uint64(start)
+	}
+
+	var annotations []MemoryAnnotation
+	for _, property := range properties {
+		if overlaps(property.Address, property.Length) {
+			annotations = append(annotations, MemoryAnnotation{
+				Name:        property.Name,
+				Address:     property.Address,
+				Length:      property.Length,
+				Source:      "property",
+				Description: property.Description,
+			})
+		}
+	}
+	for _, constant := range memoryConstants {
+		if overlaps(constant.Address, constant.Length) {
+			annotations = append(annotations, constant)
+		}
+	}
+
+	sort.SliceStable(annotations, func(i, j int) bool { return annotations[i].Address < annotations[j].Address })
+	if annotations == nil {
+		annotations = []MemoryAnnotation{}
+	}
+	return annotations
+}
+
+// MemoryWindowRequest subscribes a WebSocket client to a range of memory
+type MemoryWindowRequest struct {
+	Address Address `json:"address"`
+	Length  int     `json:"length"`
+}
+
+// MemoryWindowUpdate carries the bytes of a window that changed since the
+// previous poll. The first update of a window holds all of it and sets Full.
+type MemoryWindowUpdate struct {
+	Window  string        `json:"window"`
+	Address string        `json:"address"`
+	Length  int           `json:"length"`
+	Full    bool          `json:"full,omitempty"`
+	Changes []MemoryRange `json:"changes"`
+}
+
+// memoryWindow is a range of memory streamed to one client
+type memoryWindow struct {
+	id      string
+	client  *server.Client
+	address uint32
+	length  int
+	last    []byte
+}
+
+// memoryWindowTable holds the memory windows of a session's clients
+type memoryWindowTable struct {
+	mu      sync.Mutex
+	windows map[string]*memoryWindow
+	nextID  uint64
+}
+
+func newMemoryWindowTable() *memoryWindowTable {
+	return &memoryWindowTable{windows: make(map[string]*memoryWindow)}
+}
+
+// add registers a window, or returns false when the table is full
+func (t *memoryWindowTable) add(client *server.Client, address uint32, length int) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.windows) >= maxMemoryWindows {
+		return "", false
+	}
+	t.nextID++
+	id := strconv.FormatUint(t.nextID, 10)
+	t.windows[id] = &memoryWindow{id: id, client: client, address: address, length: length}
+	return id, true
+}
+
+// remove drops a window if it belongs to client
+func (t *memoryWindowTable) remove(client *server.Client, id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	window, ok := t.windows[id]
+	if !ok || window.client != client {
+		return false
+	}
+	delete(t.windows, id)
+	return true
+}
+
+// list returns every window
+func (t *memoryWindowTable) list() []*memoryWindow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	windows := make([]*memoryWindow, 0, len(t.windows))
+	for _, window := range t.windows {
+		windows = append(windows, window)
+	}
+	return windows
+}
+
+// watchMemory starts streaming a window of memory to a client
+func (s *Session) watchMemory(client *server.Client, request MemoryWindowRequest) (map[string]interface{}, error) {
+	if request.Length <= 0 || request.Length > maxMemoryWindow {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "length must be between 1 and %d", maxMemoryWindow)
+	}
+
+	id, ok := s.memoryWindows.add(client, uint32(request.Address), request.Length)
+	if !ok {
+		return nil, server.NewCommandError(ErrorConflict, "session %s already streams %d memory windows", s.id, maxMemoryWindows)
+	}
+	return map[string]interface{}{
+		"window":  id,
+		"address": fmt.Sprintf("0x%04X", uint32(request.Address)),
+		"length":  request.Length,
+	}, nil
+}
+
+// pollMemoryWindows reads every window and sends each client the bytes that
+// changed. Windows whose client has gone away are dropped.
+func (s *Session) pollMemoryWindows() {
+	for _, window := range s.memoryWindows.list() {
+		data, err := s.driver.ReadMemory(window.address, uint32(window.length))
+		if err != nil {
+			continue
+		}
+
+		update := MemoryWindowUpdate{
+			Window:  window.id,
+			Address: fmt.Sprintf("0x%04X", window.address),
+			Length:  window.length,
+			Full:    window.last == nil,
+		}
+		if update.Full {
+			update.Changes = []MemoryRange{{Address: update.Address, Length: len(data), Hex: hex.EncodeToString(data)}}
+		} else {
+			update.Changes = changedRuns(window.address, window.last, data)
+		}
+		window.last = data
+		if len(update.Changes) == 0 {
+			continue
+		}
+
+		err = window.client.SendMessage(server.Message{
+			Type:      "memory_changed",
+			Data:      update,
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			s.memoryWindows.remove(window.client, window.id)
+		}
+	}
+}
+
+// changedRuns returns the runs of bytes that differ between two reads of the
+// same range
+func changedRuns(address uint32, old, new []byte) []MemoryRange {
+	var runs []MemoryRange
+	for i := 0; i < len(new); {
+		if old[i] == new[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < len(new) && old[i] != new[i] {
+			i++
+		}
+		runs = append(runs, MemoryRange{
+			Address: fmt.Sprintf("0x%04X", address+uint32(start)),
+			Length:  i - start,
+			Hex:     hex.EncodeToString(new[start:i]),
+		})
+	}
+	return runs
+}
+
+// registerMemoryCommands exposes memory windows over WebSocket
+func (s *Session) registerMemoryCommands() {
+	s.wsManager.RegisterCommand("watch_memory", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request MemoryWindowRequest
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		return s.watchMemory(client, request)
+	})
+
+	s.wsManager.RegisterCommand("unwatch_memory", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request struct {
+			Window string `json:"window"`
+		}
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		if !s.memoryWindows.remove(client, request.Window) {
+			return nil, server.NewCommandError(server.ErrorNotFound, "unknown memory window %q", request.Window)
+		}
+		return map[string]interface{}{"window": request.Window, "removed": true}, nil
+	})
+}
+
+// REST handlers for raw memory
+
+// handleGetMemory returns raw memory as JSON with hex or base64 data, or as
+// the bytes themselves with format=binary
+func (s *Session) handleGetMemory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	start, err := toUint(query.Get("start"))
+	if err != nil || start > 0xFFFFFFFF {
+		writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "invalid start %q", query.Get("start")))
+		return
+	}
+	length := defaultMemoryLength
+	if value := query.Get("length"); value != "" {
+		parsed, err := toUint(value)
+		if err != nil || parsed > maxMemoryRead {
+			writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "length must be between 1 and %d", maxMemoryRead))
+			return
+		}
+		length = int(parsed)
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = "hex"
+	}
+	if format != "hex" && format != "base64" && format != "binary" {
+		writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "unknown format %q (expected hex, base64 or binary)", format))
+		return
+	}
+
+	data, err := s.readMemoryBytes(uint32(start), length)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	address := fmt.Sprintf("0x%04X", start)
+	switch format {
+	case "binary":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-Memory-Address", address)
+		w.Write(data)
+	case "base64":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"address": address,
+			"length":  len(data),
+			"base64":  base64.StdEncoding.EncodeToString(data),
+		})
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(MemoryRange{Address: address, Length: len(data), Hex: hex.EncodeToString(data)})
+	}
+}
+
+// handleGetMemoryAnnotations lists the properties and constants in a range,
+// or all of them without one
+func (s *Session) handleGetMemoryAnnotations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var start, length uint64 = 0, 1 << 32
+	if value := query.Get("start"); value != "" {
+		parsed, err := toUint(value)
+		if err != nil || parsed > 0xFFFFFFFF {
+			writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "invalid start %q", value))
+			return
+		}
+		start, length = parsed, defaultMemoryLength
+	}
+	if value := query.Get("length"); value != "" {
+		parsed, err := toUint(value)
+		if err != nil {
+			writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "invalid length %q", value))
+			return
+		}
+		length = parsed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"annotations": memoryAnnotations(uint32(start), length)})
+}
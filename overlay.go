@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"RetroGameAnalysis/server"
+	"github.com/gorilla/mux"
+)
+
+// overlayNamePattern restricts overlay names to safe file names
+var overlayNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// overlayExtension is the file extension of overlay templates
+const overlayExtension = ".html"
+
+// overlayCommon defines the templates every overlay can use, such as "head"
+const overlayCommon = "web/overlays/common.tmpl"
+
+// Overlay is an overlay page available at /overlay/{name}
+type Overlay struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Source string `json:"source"` // "builtin" or "custom"
+}
+
+// OverlayOptions are an overlay's query parameters. Layout, Theme and Slots
+// are understood by the built-in overlays; Params holds every parameter for
+// templates that take their own.
+type OverlayOptions struct {
+	Name   string            `json:"name"`
+	Layout string            `json:"layout"`
+	Theme  string            `json:"theme"`
+	Slots  []int             `json:"slots"`
+	Params map[string]string `json:"params"`
+}
+
+// parseOverlayOptions reads an overlay's query parameters. slots lists
+// 1-based party slots, e.g. "1,2,3"; no slots means all of them.
+func parseOverlayOptions(name string, query url.Values) (*OverlayOptions, error) {
+	options := &OverlayOptions{
+		Name:   name,
+		Layout: "vertical",
+		Theme:  "dark",
+		Slots:  []int{},
+		Params: make(map[string]string, len(query)),
+	}
+	for key := range query {
+		options.Params[key] = query.Get(key)
+	}
+	if value := query.Get("layout"); value != "" {
+		options.Layout = value
+	}
+	if value := query.Get("theme"); value != "" {
+		options.Theme = value
+	}
+	if value := query.Get("slots"); value != "" {
+		for _, item := range splitList(value) {
+			slot, err := strconv.Atoi(item)
+			if err != nil || slot < 1 || slot > len(partySlotAddresses) {
+				return nil, server.NewCommandError(server.ErrorInvalidRequest, "invalid slot %q (expected 1-%d)", item, len(partySlotAddresses))
+			}
+			options.Slots = append(options.Slots, slot-1)
+		}
+	}
+	return options, nil
+}
+
+// overlaySource returns an overlay's template, from the overlays directory
+// if it has one of that name and from the built-in overlays otherwise
+func (s *PokemonWebServer) overlaySource(name string) ([]byte, error) {
+	if !overlayNamePattern.MatchString(name) {
+		return nil, server.NewCommandError(server.ErrorNotFound, "unknown overlay %q", name)
+	}
+
+	source, err := os.ReadFile(filepath.Join(s.config.OverlaysDir, name+overlayExtension))
+	if err == nil {
+		return source, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	source, err = webFiles.ReadFile("web/overlays/" + name + overlayExtension)
+	if err != nil {
+		return nil, server.NewCommandError(server.ErrorNotFound, "unknown overlay %q", name)
+	}
+	return source, nil
+}
+
+// renderOverlay executes an overlay's template. Templates are parsed on
+// every request, so edits in the overlays directory show on reload.
+func (s *PokemonWebServer) renderOverlay(name string, options *OverlayOptions) ([]byte, error) {
+	source, err := s.overlaySource(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.ParseFS(webFiles, overlayCommon)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmpl.New(name + overlayExtension).Parse(string(source)); err != nil {
+		return nil, fmt.Errorf("overlay %q: %w", name, err)
+	}
+
+	var page bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&page, name+overlayExtension, options); err != nil {
+		return nil, fmt.Errorf("overlay %q: %w", name, err)
+	}
+	return page.Bytes(), nil
+}
+
+// overlays lists the built-in and custom overlays by name
+func (s *PokemonWebServer) overlays() ([]Overlay, error) {
+	sources := make(map[string]string)
+
+	builtin, err := fs.Glob(webFiles, "web/overlays/*"+overlayExtension)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range builtin {
+		sources[strings.TrimSuffix(filepath.Base(path), overlayExtension)] = "builtin"
+	}
+
+	entries, err := os.ReadDir(s.config.OverlaysDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), overlayExtension)
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), overlayExtension) && overlayNamePattern.MatchString(name) {
+			sources[name] = "custom"
+		}
+	}
+
+	list := make([]Overlay, 0, len(sources))
+	for name, source := range sources {
+		list = append(list, Overlay{Name: name, URL: "/overlay/" + name, Source: source})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (s *PokemonWebServer) handleOverlay(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	options, err := parseOverlayOptions(name, r.URL.Query())
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	page, err := s.renderOverlay(name, options)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+func (s *PokemonWebServer) handleListOverlays(w http.ResponseWriter, r *http.Request) {
+	list, err := s.overlays()
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"dir": s.config.OverlaysDir, "overlays": list})
+}
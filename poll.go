@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PollPriority controls how often a group of properties is read
+type PollPriority int
+
+const (
+	PriorityFast   PollPriority = iota // Read every tick
+	PriorityNormal                     // Read every 4th tick
+	PrioritySlow                       // Read every 16th tick
+)
+
+// pollDivisors maps each priority to the number of ticks between reads
+var pollDivisors = map[PollPriority]uint64{
+	PriorityFast:   1,
+	PriorityNormal: 4,
+	PrioritySlow:   16,
+}
+
+// String returns the priority's name as used in configuration
+func (p PollPriority) String() string {
+	switch p {
+	case PriorityFast:
+		return "fast"
+	case PriorityNormal:
+		return "normal"
+	case PrioritySlow:
+		return "slow"
+	default:
+		return "unknown"
+	}
+}
+
+// ParsePollPriority parses a priority name
+func ParsePollPriority(name string) (PollPriority, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "fast":
+		return PriorityFast, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "slow":
+		return PrioritySlow, nil
+	default:
+		return 0, fmt.Errorf("unknown poll priority %q (expected fast, normal or slow)", name)
+	}
+}
+
+// pollGroup is a set of properties that are read together
+type pollGroup struct {
+	name     string
+	priority PollPriority
+	read     func(s *Session, data *GameData)
+}
+
+// pollGroups lists every property group with its default priority.
+// HP and battle fields change every frame, while Pokedex and bag rarely do.
+var pollGroups = []pollGroup{
+	{name: "party", priority: PriorityFast, read: (*Session).readParty},
+	{name: "battle", priority: PriorityFast, read: (*Session).readBattleState},
+	{name: "player", priority: PriorityNormal, read: (*Session).readPlayerData},
+	{name: "playtime", priority: PriorityNormal, read: (*Session).readPlayTime},
+	{name: "progress", priority: PrioritySlow, read: (*Session).readProgress},
+	{name: "bag", priority: PrioritySlow, read: (*Session).readBag},
+	{name: "events", priority: PrioritySlow, read: (*Session).pollEventFlags},
+}
+
+// ParsePollPriorities parses overrides in the form "party=fast,bag=slow"
+func ParsePollPriorities(spec string) (map[string]PollPriority, error) {
+	priorities := make(map[string]PollPriority)
+	if strings.TrimSpace(spec) == "" {
+		return priorities, nil
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid poll priority %q (expected group=priority)", entry)
+		}
+
+		group := strings.TrimSpace(parts[0])
+		if !isPollGroup(group) {
+			return nil, fmt.Errorf("unknown poll group %q", group)
+		}
+
+		priority, err := ParsePollPriority(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		priorities[group] = priority
+	}
+
+	return priorities, nil
+}
+
+func isPollGroup(name string) bool {
+	for _, group := range pollGroups {
+		if group.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Adaptive scheduling parameters
+const (
+	maxBackoffLevel   = 6  // Slowest rate is the update interval * 2^6
+	backoffAfter      = 3  // Consecutive over-budget ticks before slowing down
+	recoverAfter      = 20 // Consecutive fast ticks before speeding back up
+	achievedRateAlpha = 0.1
+)
+
+// PollStats reports what the scheduler is actually achieving
+type PollStats struct {
+	ConfiguredInterval string            `json:"configured_interval"`
+	EffectiveInterval  string            `json:"effective_interval"`
+	TargetHz           float64           `json:"target_hz"`
+	AchievedHz         float64           `json:"achieved_hz"`
+	BackoffLevel       int               `json:"backoff_level"`
+	Ticks              uint64            `json:"ticks"`
+	LateTicks          uint64            `json:"late_ticks"`
+	Overruns           uint64            `json:"overruns"`
+	LastReadDuration   string            `json:"last_read_duration"`
+	Priorities         map[string]string `json:"priorities"`
+	GroupReads         map[string]uint64 `json:"group_reads"`
+}
+
+// pollScheduler decides which property groups to read on each tick and
+// backs off when reads take longer than the tick's time budget
+type pollScheduler struct {
+	baseInterval time.Duration
+	priorities   map[string]PollPriority
+
+	mu             sync.Mutex
+	backoffLevel   int
+	overBudget     int
+	underBudget    int
+	tick           uint64
+	lateTicks      uint64
+	overruns       uint64
+	lastTick       time.Time
+	avgPeriod      time.Duration
+	lastReadTime   time.Duration
+	groupReadCount map[string]uint64
+}
+
+// newPollScheduler creates a scheduler with per-group priority overrides
+func newPollScheduler(interval time.Duration, overrides map[string]PollPriority) *pollScheduler {
+	priorities := make(map[string]PollPriority, len(pollGroups))
+	for _, group := range pollGroups {
+		priorities[group.name] = group.priority
+	}
+	for name, priority := range overrides {
+		priorities[name] = priority
+	}
+
+	return &pollScheduler{
+		baseInterval:   interval,
+		priorities:     priorities,
+		groupReadCount: make(map[string]uint64),
+	}
+}
+
+// interval returns the current effective tick interval
+func (p *pollScheduler) interval() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.baseInterval << p.backoffLevel
+}
+
+// dueGroups returns the groups that should be read on the next tick
+func (p *pollScheduler) dueGroups() []pollGroup {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	due := make([]pollGroup, 0, len(pollGroups))
+	for _, group := range pollGroups {
+		if p.tick%pollDivisors[p.priorities[group.name]] == 0 {
+			due = append(due, group)
+			p.groupReadCount[group.name]++
+		}
+	}
+	return due
+}
+
+// recordTick records a completed tick. late is how far behind schedule the
+// tick started and readTime is how long its reads took.
+func (p *pollScheduler) recordTick(start time.Time, late, readTime time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	budget := p.baseInterval << p.backoffLevel
+
+	if !p.lastTick.IsZero() {
+		period := start.Sub(p.lastTick)
+		if p.avgPeriod == 0 {
+			p.avgPeriod = period
+		} else {
+			p.avgPeriod = time.Duration(float64(p.avgPeriod)*(1-achievedRateAlpha) + float64(period)*achievedRateAlpha)
+		}
+	}
+	p.lastTick = start
+	p.lastReadTime = readTime
+	p.tick++
+
+	// Missed ticks are counted and reported, never dropped silently
+	if missed := uint64(late / budget); missed > 0 {
+		p.lateTicks += missed
+		log.Printf("⚠️  Poll tick %d started %v late (%d tick(s) missed at %v)", p.tick, late, missed, budget)
+	}
+
+	if readTime > budget {
+		p.overruns++
+		p.overBudget++
+		p.underBudget = 0
+	} else if readTime < budget/4 {
+		p.underBudget++
+		p.overBudget = 0
+	} else {
+		p.overBudget = 0
+		p.underBudget = 0
+	}
+
+	if p.overBudget >= backoffAfter && p.backoffLevel < maxBackoffLevel {
+		p.backoffLevel++
+		p.overBudget = 0
+		log.Printf("🐢 Reads taking %v exceed the %v budget, backing off to %v", readTime, budget, p.baseInterval<<p.backoffLevel)
+	} else if p.underBudget >= recoverAfter && p.backoffLevel > 0 {
+		p.backoffLevel--
+		p.underBudget = 0
+		log.Printf("🐇 Reads recovered (%v), speeding up to %v", readTime, p.baseInterval<<p.backoffLevel)
+	}
+}
+
+// stats returns a snapshot of the scheduler's statistics
+func (p *pollScheduler) stats() PollStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	effective := p.baseInterval << p.backoffLevel
+
+	achieved := 0.0
+	if p.avgPeriod > 0 {
+		achieved = float64(time.Second) / float64(p.avgPeriod)
+	}
+
+	priorities := make(map[string]string, len(p.priorities))
+	for name, priority := range p.priorities {
+		priorities[name] = priority.String()
+	}
+
+	reads := make(map[string]uint64, len(p.groupReadCount))
+	for name, count := range p.groupReadCount {
+		reads[name] = count
+	}
+
+	return PollStats{
+		ConfiguredInterval: p.baseInterval.String(),
+		EffectiveInterval:  effective.String(),
+		TargetHz:           float64(time.Second) / float64(p.baseInterval),
+		AchievedHz:         achieved,
+		BackoffLevel:       p.backoffLevel,
+		Ticks:              p.tick,
+		LateTicks:          p.lateTicks,
+		Overruns:           p.overruns,
+		LastReadDuration:   p.lastReadTime.String(),
+		Priorities:         priorities,
+		GroupReads:         reads,
+	}
+}
+
+// formatPriorities renders priority overrides in flag syntax
+func formatPriorities(priorities map[string]PollPriority) string {
+	entries := make([]string, 0, len(priorities))
+	for name, priority := range priorities {
+		entries = append(entries, name+"="+priority.String())
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"RetroGameAnalysis/server"
+)
+
+// Property value encodings
+const (
+	TypeUint8    = "uint8"
+	TypeUint16LE = "uint16le"
+	TypeUint16BE = "uint16be"
+	TypeUint24BE = "uint24be"
+	TypeBCD      = "bcd"
+	TypeText     = "text"
+	TypeBytes    = "bytes"
+)
+
+// Property describes a named, typed location in game memory. Names follow the
+// dotted form of the game state's JSON paths where the two describe the same value.
+type Property struct {
+	Name        string `json:"name"`
+	Address     uint32 `json:"address"`
+	Length      uint32 `json:"length"`
+	Type        string `json:"type"`
+	Group       string `json:"group"`
+	Description string `json:"description"`
+}
+
+// partySlotAddresses holds the base address of each party slot
+var partySlotAddresses = []uint32{
+	POKEMON_1_ADDR, POKEMON_2_ADDR, POKEMON_3_ADDR,
+	POKEMON_4_ADDR, POKEMON_5_ADDR, POKEMON_6_ADDR,
+}
+
+// properties lists every known property in display order
+var properties = buildPropertyTable()
+
+// propertyIndex maps property names to their definitions
+var propertyIndex = func() map[string]*Property {
+	index := make(map[string]*Property, len(properties))
+	for i := range properties {
+		index[properties[i].Name] = &properties[i]
+	}
+	return index
+}()
+
+func buildPropertyTable() []Property {
+	table := []Property{
+		{"player_name", PLAYER_NAME_ADDR, 11, TypeText, "player", "Player name"},
+		{"player_id", PLAYER_ID_ADDR, 2, TypeUint16LE, "player", "Trainer ID"},
+		{"money", MONEY_ADDR, 3, TypeBCD, "player", "Money (BCD)"},
+		{"current_map", CURRENT_MAP_ADDR, 1, TypeUint8, "player", "Current map ID"},
+		{"player_x", PLAYER_X_ADDR, 1, TypeUint8, "player", "Player X position"},
+		{"player_y", PLAYER_Y_ADDR, 1, TypeUint8, "player", "Player Y position"},
+		{"hours", GAME_HOURS_ADDR, 2, TypeUint16BE, "playtime", "Play time hours"},
+		{"minutes", GAME_MINUTES_ADDR, 1, TypeUint8, "playtime", "Play time minutes"},
+		{"seconds", GAME_SECONDS_ADDR, 1, TypeUint8, "playtime", "Play time seconds"},
+		{"badge_flags", BADGES_ADDR, 1, TypeUint8, "progress", "Badge bitfield (bit 0 = Boulder Badge)"},
+		{"pokedex_seen_flags", POKEDEX_SEEN_ADDR, 19, TypeBytes, "progress", "Pokedex seen bit array"},
+		{"pokedex_caught_flags", POKEDEX_CAUGHT_ADDR, 19, TypeBytes, "progress", "Pokedex caught bit array"},
+		{"bag_item_count", BAG_ITEM_COUNT_ADDR, 1, TypeUint8, "bag", "Number of items in the bag"},
+		{"battle_mode_id", BATTLE_MODE_ADDR, 1, TypeUint8, "battle", "Battle mode"},
+		{"battle_type_id", BATTLE_TYPE_ADDR, 1, TypeUint8, "battle", "Battle type"},
+		{"team_count", TEAM_COUNT_ADDR, 1, TypeUint8, "party", "Number of Pokemon in the party"},
+	}
+
+	for slot, base := range partySlotAddresses {
+		prefix := fmt.Sprintf("pokemon.%d.", slot)
+		label := fmt.Sprintf("Party slot %d ", slot+1)
+		table = append(table,
+			Property{prefix + "species", base + OFFSET_SPECIES, 1, TypeUint8, "party", label + "species ID"},
+			Property{prefix + "current_hp", base + OFFSET_CURRENT_HP, 2, TypeUint16LE, "party", label + "current HP"},
+			Property{prefix + "status", base + OFFSET_STATUS, 1, TypeUint8, "party", label + "status condition"},
+			Property{prefix + "type1", base + OFFSET_TYPE1, 1, TypeUint8, "party", label + "first type"},
+			Property{prefix + "type2", base + OFFSET_TYPE2, 1, TypeUint8, "party", label + "second type"},
+			Property{prefix + "move_ids", base + OFFSET_MOVES, 4, TypeBytes, "party", label + "move IDs"},
+			Property{prefix + "exp_points", base + OFFSET_EXP_POINTS, 3, TypeUint24BE, "party", label + "experience points"},
+			Property{prefix + "level", base + OFFSET_LEVEL, 1, TypeUint8, "party", label + "level"},
+			Property{prefix + "max_hp", base + OFFSET_MAX_HP, 2, TypeUint16LE, "party", label + "max HP"},
+			Property{prefix + "attack", base + OFFSET_ATTACK, 2, TypeUint16LE, "party", label + "attack"},
+			Property{prefix + "defense", base + OFFSET_DEFENSE, 2, TypeUint16LE, "party", label + "defense"},
+			Property{prefix + "speed", base + OFFSET_SPEED, 2, TypeUint16LE, "party", label + "speed"},
+			Property{prefix + "special", base + OFFSET_SPECIAL, 2, TypeUint16LE, "party", label + "special"},
+		)
+	}
+
+	return table
+}
+
+// lookupProperty finds a property by name
+func lookupProperty(name string) (*Property, error) {
+	property, ok := propertyIndex[name]
+	if !ok {
+		return nil, server.NewCommandError(server.ErrorNotFound, "unknown property %q", name)
+	}
+	return property, nil
+}
+
+// Decode converts raw memory into the property's value
+func (p *Property) Decode(data []byte) interface{} {
+	switch p.Type {
+	case TypeUint8:
+		return data[0]
+	case TypeUint16LE:
+		return uint16(data[0]) | uint16(data[1])<<8
+	case TypeUint16BE:
+		return uint16(data[0])<<8 | uint16(data[1])
+	case TypeUint24BE:
+		return uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2])
+	case TypeBCD:
+		return decodeBCD(data)
+	case TypeText:
+		return convertPokemonText(data)
+	default:
+		return hex.EncodeToString(data)
+	}
+}
+
+// Encode converts a JSON value into the property's raw memory representation
+func (p *Property) Encode(value interface{}) ([]byte, error) {
+	switch p.Type {
+	case TypeText:
+		text, ok := value.(string)
+		if !ok {
+			return nil, server.NewCommandError(server.ErrorInvalidRequest, "%s expects a string", p.Name)
+		}
+		return encodePokemonText(text, p.Length)
+
+	case TypeBytes:
+		text, ok := value.(string)
+		if !ok {
+			return nil, server.NewCommandError(server.ErrorInvalidRequest, "%s expects a hex string", p.Name)
+		}
+		data, err := hex.DecodeString(strings.ReplaceAll(text, " ", ""))
+		if err != nil || uint32(len(data)) != p.Length {
+			return nil, server.NewCommandError(server.ErrorInvalidRequest, "%s expects %d hex-encoded bytes", p.Name, p.Length)
+		}
+		return data, nil
+	}
+
+	number, err := toUint(value)
+	if err != nil {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "%s: %v", p.Name, err)
+	}
+
+	maxValue := uint64(1)<<(8*p.Length) - 1
+	if p.Type == TypeBCD {
+		maxValue = uint64(math.Pow10(int(2*p.Length))) - 1
+	}
+	if number > maxValue {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "%s: value %d exceeds maximum %d", p.Name, number, maxValue)
+	}
+
+	switch p.Type {
+	case TypeUint8:
+		return []byte{byte(number)}, nil
+	case TypeUint16LE:
+		return []byte{byte(number), byte(number >> 8)}, nil
+	case TypeUint16BE:
+		return []byte{byte(number >> 8), byte(number)}, nil
+	case TypeUint24BE:
+		return []byte{byte(number >> 16), byte(number >> 8), byte(number)}, nil
+	case TypeBCD:
+		return encodeBCD(uint32(number), p.Length), nil
+	default:
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "%s has unsupported type %s", p.Name, p.Type)
+	}
+}
+
+// toUint converts a decoded JSON number or numeric string (including 0x hex) to an unsigned integer
+func toUint(value interface{}) (uint64, error) {
+	switch v := value.(type) {
+	case float64:
+		if v < 0 || v != math.Trunc(v) || v >= 1<<64 {
+			return 0, fmt.Errorf("expected a non-negative integer below 2^64, got %v", v)
+		}
+		return uint64(v), nil
+	case json.Number:
+		return strconv.ParseUint(v.String(), 10, 64)
+	case string:
+		parsed, err := strconv.ParseUint(strings.TrimSpace(v), 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("expected an integer, got %q", v)
+		}
+		return parsed, nil
+	default:
+		return 0, fmt.Errorf("expected an integer, got %T", value)
+	}
+}
+
+// pokemonCharCodes maps each character of the Gen 1 character set to its
+// code. A character with several codes gets the lowest, except the space,
+// which is always 0x7F.
+var pokemonCharCodes = buildPokemonCharCodes()
+
+func buildPokemonCharCodes() map[string]byte {
+	codes := make(map[string]byte, len(pokemonChars))
+	for code, char := range pokemonChars {
+		if char == "" || code == 0x50 {
+			continue
+		}
+		if existing, ok := codes[char]; !ok || code < existing {
+			codes[char] = code
+		}
+	}
+	codes[" "] = 0x7F
+	return codes
+}
+
+// encodePokemonText encodes text in the Gen 1 character set, terminated and padded with 0x50
+func encodePokemonText(text string, length uint32) ([]byte, error) {
+	data := make([]byte, 0, length)
+	for _, r := range text {
+		code, ok := pokemonCharCodes[string(r)]
+		if !ok {
+			return nil, server.NewCommandError(server.ErrorInvalidRequest, "character %q cannot be encoded", r)
+		}
+		data = append(data, code)
+	}
+
+	if uint32(len(data)) >= length {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "text is limited to %d characters", length-1)
+	}
+	for uint32(len(data)) < length {
+		data = append(data, 0x50)
+	}
+	return data, nil
+}
+
+// encodeBCD encodes a number as big-endian packed BCD of the given length
+func encodeBCD(value uint32, length uint32) []byte {
+	data := make([]byte, length)
+	for i := int(length) - 1; i >= 0; i-- {
+		low := value % 10
+		value /= 10
+		high := value % 10
+		value /= 10
+		data[i] = byte(high<<4 | low)
+	}
+	return data
+}
+
+// FrozenProperty describes a property held at a fixed value
+type FrozenProperty struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+	Since time.Time   `json:"since"`
+}
+
+type frozenValue struct {
+	property *Property
+	data     []byte
+	since    time.Time
+}
+
+// freezeTable holds the properties that are rewritten on every poll tick
+type freezeTable struct {
+	mu     sync.Mutex
+	frozen map[string]frozenValue
+}
+
+func newFreezeTable() *freezeTable {
+	return &freezeTable{frozen: make(map[string]frozenValue)}
+}
+
+// set freezes a property at the given raw value
+func (f *freezeTable) set(property *Property, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.frozen[property.Name] = frozenValue{property: property, data: data, since: time.Now()}
+}
+
+// release unfreezes a property and reports whether it was frozen
+func (f *freezeTable) release(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.frozen[name]
+	delete(f.frozen, name)
+	return ok
+}
+
+// releaseAll unfreezes every property and returns their names
+func (f *freezeTable) releaseAll() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	names := make([]string, 0, len(f.frozen))
+	for name := range f.frozen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	f.frozen = make(map[string]frozenValue)
+	return names
+}
+
+// isFrozen reports whether a property is frozen
+func (f *freezeTable) isFrozen(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.frozen[name]
+	return ok
+}
+
+// list returns every frozen property sorted by name
+func (f *freezeTable) list() []FrozenProperty {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := make([]FrozenProperty, 0, len(f.frozen))
+	for name, value := range f.frozen {
+		result = append(result, FrozenProperty{
+			Name:  name,
+			Value: value.property.Decode(value.data),
+			Since: value.since,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// writes returns the pending writes for every frozen property
+func (f *freezeTable) writes() []frozenValue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := make([]frozenValue, 0, len(f.frozen))
+	for _, value := range f.frozen {
+		result = append(result, value)
+	}
+	return result
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"RetroGameAnalysis/connection"
+	"RetroGameAnalysis/recording"
+	"RetroGameAnalysis/server"
+	"github.com/gorilla/mux"
+)
+
+// RecordingSettings configures where and how sessions record their timelines
+type RecordingSettings struct {
+	Dir              string
+	KeyframeInterval time.Duration
+}
+
+// RecordingRequest starts a recording. Name defaults to the session ID and
+// the start time.
+type RecordingRequest struct {
+	Name string `json:"name,omitempty"`
+}
+
+// RecordingStatus reports whether a session is recording
+type RecordingStatus struct {
+	Recording bool             `json:"recording"`
+	Stats     *recording.Stats `json:"stats,omitempty"`
+}
+
+// CaptureStatus reports whether a session is capturing memory
+type CaptureStatus struct {
+	Capturing bool                     `json:"capturing"`
+	Stats     *connection.CaptureStats `json:"stats,omitempty"`
+}
+
+// RecordingFile describes a timeline or capture file in the recordings directory
+type RecordingFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// startRecording begins recording every published state version to a new
+// timeline file, starting with a keyframe of the current state
+func (s *Session) startRecording(request RecordingRequest) (*RecordingStatus, error) {
+	s.recordMu.Lock()
+	defer s.recordMu.Unlock()
+
+	if s.recorder.Load() != nil {
+		return nil, server.NewCommandError(ErrorConflict, "session %s is already recording", s.id)
+	}
+
+	name, path, err := s.recordingPath(request.Name, recording.Extension)
+	if err != nil {
+		return nil, err
+	}
+	recorder, err := recording.Create(path, recording.Options{
+		Session: s.id,
+		Metadata: map[string]interface{}{
+			"mapper":   s.config.Mapper,
+			"platform": s.config.Platform,
+			"endpoint": s.config.Endpoint(),
+		},
+		KeyframeInterval: s.recordings.KeyframeInterval,
+	})
+	if os.IsExist(err) {
+		return nil, server.NewCommandError(ErrorConflict, "recording %q already exists", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+
+	// The monitor goroutine writes the first keyframe, so it is the version
+	// the first delta applies to
+	s.recorder.Store(recorder)
+
+	log.Printf("⏺️  [%s] Recording to %s", s.id, path)
+	stats := recorder.Stats()
+	s.wsManager.BroadcastMessage(server.Message{
+		Type:      "recording_started",
+		Data:      stats,
+		Timestamp: time.Now(),
+	})
+	return &RecordingStatus{Recording: true, Stats: &stats}, nil
+}
+
+// recordingPath resolves a requested file name in the recordings directory,
+// creating the directory if needed
+func (s *Session) recordingPath(name, extension string) (string, string, error) {
+	if name == "" {
+		name = fmt.Sprintf("%s-%s", s.id, time.Now().Format("20060102-150405"))
+	}
+	name = strings.TrimSuffix(name, extension)
+	if !safeNamePattern.MatchString(name) {
+		return "", "", server.NewCommandError(server.ErrorInvalidRequest, "invalid name %q (letters, digits, '.', '_' and '-', up to 64 characters)", name)
+	}
+
+	if err := os.MkdirAll(s.recordings.Dir, 0o755); err != nil {
+		return "", "", fmt.Errorf("failed to create recordings directory: %w", err)
+	}
+	return name, filepath.Join(s.recordings.Dir, name+extension), nil
+}
+
+// stopRecording closes the current timeline file
+func (s *Session) stopRecording() (*RecordingStatus, error) {
+	s.recordMu.Lock()
+	defer s.recordMu.Unlock()
+
+	recorder := s.recorder.Swap(nil)
+	if recorder == nil {
+		return nil, server.NewCommandError(ErrorConflict, "session %s is not recording", s.id)
+	}
+
+	err := recorder.Close()
+	stats := recorder.Stats()
+	if err != nil {
+		log.Printf("⚠️  [%s] Recording %s did not close cleanly: %v", s.id, recorder.Path(), err)
+	} else {
+		log.Printf("⏹️  [%s] Recording stopped: %d keyframes, %d deltas, %d bytes", s.id, stats.Keyframes, stats.Deltas, stats.Bytes)
+	}
+
+	s.wsManager.BroadcastMessage(server.Message{
+		Type:      "recording_stopped",
+		Data:      stats,
+		Timestamp: time.Now(),
+	})
+	return &RecordingStatus{Recording: false, Stats: &stats}, err
+}
+
+// recordingStatus reports the current recording, if any
+func (s *Session) recordingStatus() *RecordingStatus {
+	recorder := s.recorder.Load()
+	if recorder == nil {
+		return &RecordingStatus{}
+	}
+	stats := recorder.Stats()
+	return &RecordingStatus{Recording: true, Stats: &stats}
+}
+
+// startCapture begins capturing the raw memory the session reads, for
+// playback with a replay session
+func (s *Session) startCapture(request RecordingRequest) (*CaptureStatus, error) {
+	s.recordMu.Lock()
+	defer s.recordMu.Unlock()
+
+	if _, capturing := s.capture.CaptureStats(); capturing {
+		return nil, server.NewCommandError(ErrorConflict, "session %s is already capturing", s.id)
+	}
+
+	name, path, err := s.recordingPath(request.Name, connection.CaptureExtension)
+	if err != nil {
+		return nil, err
+	}
+	err = s.capture.StartCapture(path, connection.CaptureOptions{
+		Platform:         s.config.Platform,
+		KeyframeInterval: s.recordings.KeyframeInterval,
+	})
+	if os.IsExist(err) {
+		return nil, server.NewCommandError(ErrorConflict, "capture %q already exists", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture: %w", err)
+	}
+
+	log.Printf("⏺️  [%s] Capturing memory to %s", s.id, path)
+	return s.captureStatus(), nil
+}
+
+// stopCapture closes the current capture file
+func (s *Session) stopCapture() (*CaptureStatus, error) {
+	s.recordMu.Lock()
+	defer s.recordMu.Unlock()
+
+	if _, capturing := s.capture.CaptureStats(); !capturing {
+		return nil, server.NewCommandError(ErrorConflict, "session %s is not capturing", s.id)
+	}
+
+	stats, err := s.capture.StopCapture()
+	if err != nil {
+		log.Printf("⚠️  [%s] Capture %s did not close cleanly: %v", s.id, stats.Path, err)
+	} else {
+		log.Printf("⏹️  [%s] Capture stopped: %d keyframes, %d deltas, %d bytes", s.id, stats.Keyframes, stats.Deltas, stats.Bytes)
+	}
+	return &CaptureStatus{Capturing: false, Stats: &stats}, err
+}
+
+// captureStatus reports the current capture, if any
+func (s *Session) captureStatus() *CaptureStatus {
+	stats, capturing := s.capture.CaptureStats()
+	if !capturing {
+		return &CaptureStatus{}
+	}
+	return &CaptureStatus{Capturing: true, Stats: &stats}
+}
+
+// listRecordings returns the files with the given extension in the
+// recordings directory, newest first
+func listRecordings(dir, extension string) ([]RecordingFile, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []RecordingFile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := []RecordingFile{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), extension) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, RecordingFile{
+			Name:     strings.TrimSuffix(entry.Name(), extension),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Modified.After(files[j].Modified)
+	})
+	return files, nil
+}
+
+// registerRecordingCommands exposes recording control over WebSocket
+func (s *Session) registerRecordingCommands() {
+	s.wsManager.RegisterCommand("start_recording", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request RecordingRequest
+		if len(params) > 0 {
+			if err := decodeParams(params, &request); err != nil {
+				return nil, err
+			}
+		}
+		return s.startRecording(request)
+	})
+
+	s.wsManager.RegisterCommand("stop_recording", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		return s.stopRecording()
+	})
+
+	s.wsManager.RegisterCommand("recording_status", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		return s.recordingStatus(), nil
+	})
+
+	s.wsManager.RegisterCommand("start_capture", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request RecordingRequest
+		if len(params) > 0 {
+			if err := decodeParams(params, &request); err != nil {
+				return nil, err
+			}
+		}
+		return s.startCapture(request)
+	})
+
+	s.wsManager.RegisterCommand("stop_capture", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		return s.stopCapture()
+	})
+
+	s.wsManager.RegisterCommand("capture_status", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		return s.captureStatus(), nil
+	})
+}
+
+// REST handlers for recording control
+
+func (s *Session) handleGetRecording(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.recordingStatus())
+}
+
+func (s *Session) handleStartRecording(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeRecordingRequest(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := s.startRecording(request)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(status)
+}
+
+func (s *Session) handleStopRecording(w http.ResponseWriter, r *http.Request) {
+	status, err := s.stopRecording()
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (s *Session) handleGetCapture(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.captureStatus())
+}
+
+func (s *Session) handleStartCapture(w http.ResponseWriter, r *http.Request) {
+	request, ok := decodeRecordingRequest(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := s.startCapture(request)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(status)
+}
+
+func (s *Session) handleStopCapture(w http.ResponseWriter, r *http.Request) {
+	status, err := s.stopCapture()
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// decodeRecordingRequest decodes an optional recording or capture request body
+func decodeRecordingRequest(w http.ResponseWriter, r *http.Request) (RecordingRequest, bool) {
+	var request RecordingRequest
+	if err := decodeOptionalBody(r, &request); err != nil {
+		writeCommandError(w, err)
+		return request, false
+	}
+	return request, true
+}
+
+func (s *PokemonWebServer) handleListRecordings(w http.ResponseWriter, r *http.Request) {
+	s.listRecordingFiles(w, "recordings", recording.Extension)
+}
+
+func (s *PokemonWebServer) handleListCaptures(w http.ResponseWriter, r *http.Request) {
+	s.listRecordingFiles(w, "captures", connection.CaptureExtension)
+}
+
+func (s *PokemonWebServer) handleDownloadRecording(w http.ResponseWriter, r *http.Request) {
+	s.serveRecordingFile(w, r, "recording", recording.Extension)
+}
+
+func (s *PokemonWebServer) handleDownloadCapture(w http.ResponseWriter, r *http.Request) {
+	s.serveRecordingFile(w, r, "capture", connection.CaptureExtension)
+}
+
+func (s *PokemonWebServer) listRecordingFiles(w http.ResponseWriter, key, extension string) {
+	files, err := listRecordings(s.recordings.Dir, extension)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{key: files})
+}
+
+func (s *PokemonWebServer) serveRecordingFile(w http.ResponseWriter, r *http.Request, kind, extension string) {
+	name := mux.Vars(r)["name"]
+	if !safeNamePattern.MatchString(name) {
+		writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "invalid %s name %q", kind, name))
+		return
+	}
+
+	path := filepath.Join(s.recordings.Dir, name+extension)
+	if _, err := os.Stat(path); err != nil {
+		writeCommandError(w, server.NewCommandError(server.ErrorNotFound, "unknown %s %q", kind, name))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+extension))
+	http.ServeFile(w, r, path)
+}
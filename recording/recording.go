@@ -0,0 +1,274 @@
+// Package recording writes session timelines: gzip-compressed JSON
+// lines holding periodic full-state keyframes and the field-level deltas
+// between them. Its Writer and KeyframeClock also write memory captures.
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"RetroGameAnalysis/state"
+)
+
+// FormatVersion is written in every timeline header
+const FormatVersion = 1
+
+// Extension is the file extension of timeline files
+const Extension = ".jsonl.gz"
+
+// Entry types
+const (
+	EntryHeader   = "header"
+	EntryKeyframe = "keyframe"
+	EntryDelta    = "delta"
+	EntryEnd      = "end"
+)
+
+// Entry is one line of a timeline. A header starts the file, keyframes hold
+// the full state, deltas hold the changes since the previous entry and an end
+// entry marks a cleanly closed recording.
+type Entry struct {
+	Type    string          `json:"type"`
+	Seq     uint64          `json:"seq,omitempty"`
+	Time    time.Time       `json:"time"`
+	State   json.RawMessage `json:"state,omitempty"`
+	Changes []state.Change  `json:"changes,omitempty"`
+
+	// Header fields
+	Version  int                    `json:"version,omitempty"`
+	Session  string                 `json:"session,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Options configures a recorder
+type Options struct {
+	// Session is the ID of the recorded session, stored in the header
+	Session string
+
+	// Metadata is stored in the header, e.g. the mapper and platform
+	Metadata map[string]interface{}
+
+	// KeyframeInterval is the longest time between keyframes
+	KeyframeInterval time.Duration
+
+	// FlushInterval is how often buffered entries are flushed to disk
+	FlushInterval time.Duration
+}
+
+// Stats reports a recording's progress
+type Stats struct {
+	Path      string    `json:"path"`
+	Session   string    `json:"session"`
+	Started   time.Time `json:"started"`
+	Duration  string    `json:"duration"`
+	Keyframes uint64    `json:"keyframes"`
+	Deltas    uint64    `json:"deltas"`
+	Dropped   uint64    `json:"dropped"`
+	Bytes     int64     `json:"bytes"`
+	LastSeq   uint64    `json:"last_seq"`
+}
+
+// queueSize is the number of entries buffered between the poll loop and the
+// writer goroutine
+const queueSize = 1024
+
+// record is a state version handed to the writer goroutine
+type record struct {
+	seq      uint64
+	at       time.Time
+	changes  []state.Change
+	snapshot interface{}
+	keyframe bool
+}
+
+// Recorder appends a session's state versions to a timeline file. Record
+// never blocks the poll loop: when the writer falls behind, versions are
+// dropped and the next one is written as a keyframe so the timeline stays
+// consistent.
+type Recorder struct {
+	options Options
+	writer  *Writer
+
+	records chan record
+	done    chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+	err     error
+
+	mu        sync.Mutex
+	stats     Stats
+	gap       bool
+	keyframes KeyframeClock
+}
+
+// Create starts a new timeline at path. The file must not exist yet.
+func Create(path string, options Options) (*Recorder, error) {
+	if options.KeyframeInterval <= 0 {
+		options.KeyframeInterval = time.Minute
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = time.Second
+	}
+
+	started := time.Now()
+	writer, err := CreateWriter(path, Entry{
+		Type:     EntryHeader,
+		Time:     started,
+		Version:  FormatVersion,
+		Session:  options.Session,
+		Metadata: options.Metadata,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{
+		options:   options,
+		writer:    writer,
+		records:   make(chan record, queueSize),
+		done:      make(chan struct{}),
+		closed:    make(chan struct{}),
+		keyframes: KeyframeClock{Interval: options.KeyframeInterval},
+		stats: Stats{
+			Path:    path,
+			Session: options.Session,
+			Started: started,
+		},
+	}
+
+	go r.run()
+	return r, nil
+}
+
+// Path returns the timeline file's path
+func (r *Recorder) Path() string {
+	return r.writer.Path()
+}
+
+// Record queues a state version. changes are the differences from the
+// previous version and snapshot is the full, immutable state. The first
+// version and any version after a gap are written as keyframes.
+func (r *Recorder) Record(seq uint64, at time.Time, changes []state.Change, snapshot interface{}) {
+	r.mu.Lock()
+	keyframe := r.gap || r.keyframes.Due(at)
+	r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+
+	select {
+	case r.records <- record{seq: seq, at: at, changes: changes, snapshot: snapshot, keyframe: keyframe}:
+		r.mu.Lock()
+		if keyframe {
+			r.keyframes.Mark(at)
+			r.gap = false
+		}
+		r.mu.Unlock()
+	default:
+		r.mu.Lock()
+		r.stats.Dropped++
+		r.gap = true
+		r.mu.Unlock()
+	}
+}
+
+// Started reports whether the first keyframe has been queued
+func (r *Recorder) Started() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.keyframes.Count() > 0
+}
+
+// Stats returns the recording's progress
+func (r *Recorder) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.Bytes = r.writer.Bytes()
+	stats.Duration = time.Since(stats.Started).Round(time.Second).String()
+	return stats
+}
+
+// Close writes the queued versions and an end entry, then closes the file
+func (r *Recorder) Close() error {
+	r.once.Do(func() {
+		close(r.done)
+	})
+	<-r.closed
+	return r.err
+}
+
+// run writes queued versions until Close
+func (r *Recorder) run() {
+	defer close(r.closed)
+
+	ticker := time.NewTicker(r.options.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case rec := <-r.records:
+			r.write(rec)
+
+		case <-ticker.C:
+			r.writer.Flush()
+
+		case <-r.done:
+			r.drain()
+			r.finish()
+			return
+		}
+	}
+}
+
+// drain writes the versions queued before Close
+func (r *Recorder) drain() {
+	for {
+		select {
+		case rec := <-r.records:
+			r.write(rec)
+		default:
+			return
+		}
+	}
+}
+
+func (r *Recorder) write(rec record) {
+	entry := Entry{Seq: rec.seq, Time: rec.at}
+	if rec.keyframe {
+		data, err := json.Marshal(rec.snapshot)
+		if err != nil {
+			r.writer.Fail(fmt.Errorf("failed to encode keyframe %d: %w", rec.seq, err))
+			return
+		}
+		entry.Type = EntryKeyframe
+		entry.State = data
+	} else {
+		entry.Type = EntryDelta
+		entry.Changes = rec.changes
+	}
+
+	if err := r.writer.Write(entry); err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	if rec.keyframe {
+		r.stats.Keyframes++
+	} else {
+		r.stats.Deltas++
+	}
+	r.stats.LastSeq = rec.seq
+	r.mu.Unlock()
+}
+
+// finish writes the end entry and closes the file
+func (r *Recorder) finish() {
+	r.err = r.writer.Close(Entry{Type: EntryEnd, Time: time.Now()})
+}
@@ -0,0 +1,127 @@
+package recording
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"RetroGameAnalysis/state"
+)
+
+// readTimeline decodes every line of a timeline file
+func readTimeline(t *testing.T, path string) []Entry {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gz.Close()
+
+	var entries []Entry
+	decoder := json.NewDecoder(gz)
+	for decoder.More() {
+		var entry Entry
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatalf("entry %d: %v", len(entries), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestRecorderWritesTimeline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run"+Extension)
+	recorder, err := Create(path, Options{
+		Session:          "default",
+		Metadata:         map[string]interface{}{"mapper": "pokemon-gen1"},
+		KeyframeInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Started() {
+		t.Error("recorder is started before its first version")
+	}
+
+	at := time.Now()
+	recorder.Record(7, at, nil, map[string]interface{}{"money": 3000, "name": "ASH"})
+	if !recorder.Started() {
+		t.Error("recorder is not started after its first version")
+	}
+	recorder.Record(8, at.Add(time.Second), []state.Change{
+		{Op: state.OpReplace, Path: "/money", Old: 3000, Value: 0},
+		{Op: state.OpReplace, Path: "/name", Old: "ASH", Value: ""},
+	}, map[string]interface{}{"money": 0, "name": ""})
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Create(path, Options{}); !os.IsExist(err) {
+		t.Errorf("creating over an existing timeline: %v, want an exists error", err)
+	}
+
+	entries := readTimeline(t, path)
+	types := make([]string, len(entries))
+	for i, entry := range entries {
+		types[i] = entry.Type
+	}
+	want := []string{EntryHeader, EntryKeyframe, EntryDelta, EntryEnd}
+	if len(types) != len(want) {
+		t.Fatalf("entries %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("entries %v, want %v", types, want)
+		}
+	}
+
+	header, keyframe, delta := entries[0], entries[1], entries[2]
+	if header.Version != FormatVersion || header.Session != "default" || header.Metadata["mapper"] != "pokemon-gen1" {
+		t.Errorf("header %+v", header)
+	}
+	if keyframe.Seq != 7 || string(keyframe.State) != `{"money":3000,"name":"ASH"}` {
+		t.Errorf("keyframe seq %d state %s", keyframe.Seq, keyframe.State)
+	}
+	if delta.Seq != 8 || len(delta.Changes) != 2 {
+		t.Fatalf("delta seq %d with %d changes, want seq 8 with 2", delta.Seq, len(delta.Changes))
+	}
+	if money := delta.Changes[0]; money.Value != float64(0) {
+		t.Errorf("money change %+v lost its zero value", money)
+	}
+	if name := delta.Changes[1]; name.Value != "" {
+		t.Errorf("name change %+v lost its empty value", name)
+	}
+
+	stats := recorder.Stats()
+	if stats.Keyframes != 1 || stats.Deltas != 1 || stats.LastSeq != 8 || stats.Bytes == 0 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestKeyframeClock(t *testing.T) {
+	clock := KeyframeClock{Interval: time.Minute}
+	start := time.Now()
+
+	if !clock.Due(start) {
+		t.Fatal("first entry is not due a keyframe")
+	}
+	clock.Mark(start)
+	if clock.Due(start.Add(59 * time.Second)) {
+		t.Error("keyframe due before the interval")
+	}
+	if !clock.Due(start.Add(time.Minute)) {
+		t.Error("keyframe not due after the interval")
+	}
+	if clock.Count() != 1 {
+		t.Errorf("count %d, want 1", clock.Count())
+	}
+}
@@ -0,0 +1,169 @@
+package recording
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Writer appends JSON lines to a new gzip-compressed file. It is shared by
+// timelines and memory captures. The first error sticks: later writes
+// return it and nothing more is written. Writes, flushes and Close must not
+// run concurrently; Bytes may be called at any time.
+type Writer struct {
+	path    string
+	file    *os.File
+	counter *countingWriter
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	err     error
+}
+
+// CreateWriter creates the file at path, which must not exist yet, and
+// writes header as its first line
+func CreateWriter(path string, header interface{}) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	counter := &countingWriter{w: file}
+	gz := gzip.NewWriter(counter)
+	w := &Writer{
+		path:    path,
+		file:    file,
+		counter: counter,
+		gz:      gz,
+		buf:     bufio.NewWriter(gz),
+	}
+
+	if err := w.Write(header); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return w, nil
+}
+
+// Path returns the file's path
+func (w *Writer) Path() string {
+	return w.path
+}
+
+// Err returns the first error the writer hit
+func (w *Writer) Err() error {
+	return w.err
+}
+
+// Fail records err as the writer's error unless it already has one
+func (w *Writer) Fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// Write appends entry as one JSON line
+func (w *Writer) Write(entry interface{}) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	data, err := json.Marshal(entry)
+	if err == nil {
+		_, err = w.buf.Write(append(data, '\n'))
+	}
+	if err != nil {
+		w.Fail(fmt.Errorf("failed to write %s: %w", w.path, err))
+	}
+	return w.err
+}
+
+// Flush pushes buffered lines through the compressor to disk, so a crash
+// loses at most what was written since
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.buf.Flush(); err != nil {
+		w.Fail(err)
+		return w.err
+	}
+	if err := w.gz.Flush(); err != nil {
+		w.Fail(err)
+	}
+	return w.err
+}
+
+// Close writes end as the last line and closes the file
+func (w *Writer) Close(end interface{}) error {
+	w.Write(end)
+	if w.err == nil {
+		if err := w.buf.Flush(); err != nil {
+			w.Fail(err)
+		}
+	}
+	if err := w.gz.Close(); err != nil {
+		w.Fail(err)
+	}
+	if err := w.file.Close(); err != nil {
+		w.Fail(err)
+	}
+	return w.err
+}
+
+// Bytes returns the number of compressed bytes written to the file
+func (w *Writer) Bytes() int64 {
+	return w.counter.count()
+}
+
+// KeyframeClock decides when a timeline is due its next full keyframe: for
+// its first entry and once Interval has passed since the last keyframe
+type KeyframeClock struct {
+	Interval time.Duration
+
+	last  time.Time
+	count uint64
+}
+
+// Due reports whether an entry at the given time should be a keyframe
+func (k *KeyframeClock) Due(at time.Time) bool {
+	return k.count == 0 || at.Sub(k.last) >= k.Interval
+}
+
+// Mark records a keyframe written at the given time
+func (k *KeyframeClock) Mark(at time.Time) {
+	k.last = at
+	k.count++
+}
+
+// Count returns the number of keyframes marked
+func (k *KeyframeClock) Count() uint64 {
+	return k.count
+}
+
+// countingWriter counts the compressed bytes written to the file
+type countingWriter struct {
+	w io.Writer
+
+	mu sync.Mutex
+	n  int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.mu.Lock()
+	c.n += int64(n)
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *countingWriter) count() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"RetroGameAnalysis/connection"
+	"RetroGameAnalysis/server"
+)
+
+// ReplayControl changes a replay's playback; unset fields are left as they are
+type ReplayControl struct {
+	Speed    *float64 `json:"speed,omitempty"`
+	Paused   *bool    `json:"paused,omitempty"`
+	Loop     *bool    `json:"loop,omitempty"`
+	Position *float64 `json:"position,omitempty"` // Seconds from the start of the capture
+}
+
+// replayStatus reports the playback position of a replay session
+func (s *Session) replayStatus() (*connection.ReplayStatus, error) {
+	if s.replay == nil {
+		return nil, server.NewCommandError(ErrorConflict, "session %s is not a replay", s.id)
+	}
+	status := s.replay.Status()
+	return &status, nil
+}
+
+// controlReplay applies playback changes and broadcasts the new status
+func (s *Session) controlReplay(control ReplayControl) (*connection.ReplayStatus, error) {
+	if s.replay == nil {
+		return nil, server.NewCommandError(ErrorConflict, "session %s is not a replay", s.id)
+	}
+
+	if control.Speed != nil {
+		if err := s.replay.SetSpeed(*control.Speed); err != nil {
+			return nil, server.NewCommandError(server.ErrorInvalidRequest, "%v", err)
+		}
+	}
+	if control.Loop != nil {
+		s.replay.SetLoop(*control.Loop)
+	}
+	if control.Paused != nil {
+		s.replay.SetPaused(*control.Paused)
+	}
+	if control.Position != nil {
+		position := time.Duration(*control.Position * float64(time.Second))
+		if err := s.replay.Seek(position); err != nil {
+			return nil, server.NewCommandError(server.ErrorInvalidRequest, "%v", err)
+		}
+	}
+
+	status := s.replay.Status()
+	s.wsManager.BroadcastMessage(server.Message{
+		Type:      "replay_status",
+		Data:      status,
+		Timestamp: time.Now(),
+	})
+	return &status, nil
+}
+
+// registerReplayCommands exposes playback control over WebSocket
+func (s *Session) registerReplayCommands() {
+	s.wsManager.RegisterCommand("replay_status", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		return s.replayStatus()
+	})
+
+	s.wsManager.RegisterCommand("replay_control", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var control ReplayControl
+		if err := decodeParams(params, &control); err != nil {
+			return nil, err
+		}
+		return s.controlReplay(control)
+	})
+}
+
+// REST handlers for playback control
+
+func (s *Session) handleGetReplay(w http.ResponseWriter, r *http.Request) {
+	status, err := s.replayStatus()
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (s *Session) handleControlReplay(w http.ResponseWriter, r *http.Request) {
+	var control ReplayControl
+	if err := json.NewDecoder(r.Body).Decode(&control); err != nil {
+		writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "invalid request body: %v", err))
+		return
+	}
+
+	status, err := s.controlReplay(control)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
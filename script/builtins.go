@@ -0,0 +1,288 @@
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"RetroGameAnalysis/server"
+	"go.starlark.net/starlark"
+)
+
+// builtins returns the functions a script can call:
+//
+//	read(name)                        the current value of a property
+//	write(name, value)                write a property
+//	read_memory(address, length)      raw memory as bytes
+//	write_memory(address, data)       write bytes or a list of byte values
+//	wait(polls=1)                     block until that many polls have passed
+//	wait_change(pattern, polls=0)     block until a matching property changes and
+//	                                  return (name, old, new), or None after polls
+//	on_change(pattern, fn)            call fn(name, old, new) on every matching
+//	                                  change once the top-level code has returned
+//	log(*args)                        log a line, like print
+func (s *Script) builtins() starlark.StringDict {
+	return starlark.StringDict{
+		"read":         starlark.NewBuiltin("read", s.read),
+		"write":        starlark.NewBuiltin("write", s.write),
+		"read_memory":  starlark.NewBuiltin("read_memory", s.readMemory),
+		"write_memory": starlark.NewBuiltin("write_memory", s.writeMemory),
+		"wait":         starlark.NewBuiltin("wait", s.wait),
+		"wait_change":  starlark.NewBuiltin("wait_change", s.waitChange),
+		"on_change":    starlark.NewBuiltin("on_change", s.onChange),
+		"log":          starlark.NewBuiltin("log", s.logBuiltin),
+	}
+}
+
+func (s *Script) read(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+	value, err := s.runtime.host.ReadProperty(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return toStarlark(value), nil
+}
+
+func (s *Script) write(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var value starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "value", &value); err != nil {
+		return nil, err
+	}
+	goValue, err := fromStarlark(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	if err := s.runtime.host.WriteProperty(name, goValue); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+func (s *Script) readMemory(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var address uint32
+	var length int
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "address", &address, "length", &length); err != nil {
+		return nil, err
+	}
+	data, err := s.runtime.host.ReadMemory(address, length)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return starlark.Bytes(data), nil
+}
+
+func (s *Script) writeMemory(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var address uint32
+	var value starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "address", &address, "data", &value); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	switch v := value.(type) {
+	case starlark.Bytes:
+		data = []byte(v)
+	case starlark.Indexable:
+		for i := 0; i < v.Len(); i++ {
+			var n int
+			if err := starlark.AsInt(v.Index(i), &n); err != nil || n < 0 || n > 0xFF {
+				return nil, fmt.Errorf("%s: data[%d] is not a byte value", b.Name(), i)
+			}
+			data = append(data, byte(n))
+		}
+	default:
+		return nil, fmt.Errorf("%s: data must be bytes or a list of byte values, got %s", b.Name(), value.Type())
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%s: no data", b.Name())
+	}
+
+	if err := s.runtime.host.WriteMemory(address, data); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+func (s *Script) wait(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	polls := 1
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "polls?", &polls); err != nil {
+		return nil, err
+	}
+	if polls < 1 {
+		return nil, fmt.Errorf("%s: polls must be at least 1", b.Name())
+	}
+
+	for i := 0; i < polls; i++ {
+		if _, err := s.nextTick(); err != nil {
+			return nil, err
+		}
+	}
+	s.resetSteps()
+	return starlark.None, nil
+}
+
+func (s *Script) waitChange(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern string
+	polls := 0
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &pattern, "polls?", &polls); err != nil {
+		return nil, err
+	}
+	if err := server.ValidatePropertyPattern(pattern); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+
+	defer s.resetSteps()
+	for waited := 0; polls <= 0 || waited < polls; waited++ {
+		changes, err := s.nextTick()
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range changes {
+			if matchChange(pattern, change.Name) {
+				return starlark.Tuple{starlark.String(change.Name), toStarlark(change.Old), toStarlark(change.New)}, nil
+			}
+		}
+	}
+	return starlark.None, nil
+}
+
+func (s *Script) onChange(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var pattern string
+	var fn starlark.Callable
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &pattern, "fn", &fn); err != nil {
+		return nil, err
+	}
+	if err := server.ValidatePropertyPattern(pattern); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	s.handlers = append(s.handlers, handler{pattern: pattern, fn: fn})
+	return starlark.None, nil
+}
+
+func (s *Script) logBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) > 0 {
+		return nil, fmt.Errorf("%s: unexpected keyword arguments", b.Name())
+	}
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		if text, ok := starlark.AsString(arg); ok {
+			parts[i] = text
+		} else {
+			parts[i] = arg.String()
+		}
+	}
+	s.runtime.logf(s, "info", "%s", strings.Join(parts, " "))
+	return starlark.None, nil
+}
+
+// matchChange reports whether a change belongs to a pattern. As for WebSocket
+// subscriptions, a change to "pokemon.0" matches "pokemon.*.current_hp".
+func matchChange(pattern, name string) bool {
+	return server.MatchProperty(pattern, name)
+}
+
+// toStarlark converts a Go value into a Starlark value. Values that are not
+// JSON-like are converted through their JSON encoding.
+func toStarlark(value interface{}) starlark.Value {
+	switch v := value.(type) {
+	case nil:
+		return starlark.None
+	case bool:
+		return starlark.Bool(v)
+	case string:
+		return starlark.String(v)
+	case []byte:
+		return starlark.Bytes(v)
+	case int:
+		return starlark.MakeInt(v)
+	case int64:
+		return starlark.MakeInt64(v)
+	case uint64:
+		return starlark.MakeUint64(v)
+	case float64:
+		// JSON numbers arrive as floats; whole ones are ints to scripts
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return starlark.MakeInt64(int64(v))
+		}
+		return starlark.Float(v)
+	case json.Number:
+		if n, ok := new(big.Int).SetString(v.String(), 10); ok {
+			return starlark.MakeBigInt(n)
+		}
+		f, _ := v.Float64()
+		return starlark.Float(f)
+	case []interface{}:
+		items := make([]starlark.Value, len(v))
+		for i, item := range v {
+			items[i] = toStarlark(item)
+		}
+		return starlark.NewList(items)
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(v))
+		for key, item := range v {
+			dict.SetKey(starlark.String(key), toStarlark(item))
+		}
+		return dict
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return starlark.String(fmt.Sprint(value))
+	}
+	var generic interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&generic); err != nil {
+		return starlark.String(string(data))
+	}
+	return toStarlark(generic)
+}
+
+// fromStarlark converts a Starlark value into the JSON-like form a Host takes
+func fromStarlark(value starlark.Value) (interface{}, error) {
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		return json.Number(v.String()), nil
+	case starlark.Float:
+		return json.Number(v.String()), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Bytes:
+		return string(v), nil
+	case *starlark.Dict:
+		object := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, got %s", item[0].Type())
+			}
+			converted, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			object[key] = converted
+		}
+		return object, nil
+	case starlark.Indexable:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			converted, err := fromStarlark(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			items[i] = converted
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s", value.Type())
+}
@@ -0,0 +1,454 @@
+// Package script runs Starlark automation scripts against a session. Each
+// *.star file of a directory runs in its own sandboxed interpreter: scripts
+// cannot touch files, the network or the clock, only the bindings a Host
+// provides. The directory is rescanned so edited scripts restart and removed
+// ones stop.
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Script states
+const (
+	StateRunning  = "running"  // Running its top-level code
+	StateWaiting  = "waiting"  // Done with its top-level code, handling on_change events
+	StateFinished = "finished" // Returned without registering handlers
+	StateFailed   = "failed"   // Stopped by an error
+	StateStopped  = "stopped"  // Stopped because it changed, was removed or the session stopped
+)
+
+// ErrUnknownScript is returned for a script that is not loaded
+var ErrUnknownScript = errors.New("unknown script")
+
+const (
+	// reloadInterval is how often the directory is rescanned
+	reloadInterval = time.Second
+
+	// maxSteps bounds the work a script does between two waits, so a loop
+	// that never waits fails instead of spinning forever
+	maxSteps = 10_000_000
+
+	// tickQueue is the number of polls queued for a busy script before
+	// later ones are dropped
+	tickQueue = 64
+
+	// maxLogs is the number of log lines kept per script
+	maxLogs = 200
+)
+
+// fileOptions allows while loops and top-level control flow, which polling
+// scripts need, and recursion
+var fileOptions = &syntax.FileOptions{While: true, TopLevelControl: true, GlobalReassign: true, Recursion: true}
+
+// Host is what scripts can reach. Property values are in the form
+// encoding/json decodes into with UseNumber: nil, bool, json.Number, string,
+// []interface{} and map[string]interface{}.
+type Host interface {
+	ReadProperty(name string) (interface{}, error)
+	WriteProperty(name string, value interface{}) error
+	ReadMemory(address uint32, length int) ([]byte, error)
+	WriteMemory(address uint32, data []byte) error
+}
+
+// Change is a property that changed during a poll, named in the dotted form
+// of its path, e.g. "pokemon.0.current_hp"
+type Change struct {
+	Name string
+	Old  interface{}
+	New  interface{}
+}
+
+// Log is a line logged by a script
+type Log struct {
+	Seq     uint64    `json:"seq"`
+	Script  string    `json:"script"`
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
+}
+
+// Info describes a loaded script
+type Info struct {
+	Name     string    `json:"name"`
+	State    string    `json:"state"`
+	Error    string    `json:"error,omitempty"`
+	Loaded   time.Time `json:"loaded"`
+	Modified time.Time `json:"modified"`
+	Handlers int       `json:"handlers"`
+	Polls    uint64    `json:"polls"`
+	Dropped  uint64    `json:"dropped"`
+}
+
+// Runtime runs the scripts of one directory
+type Runtime struct {
+	dir   string
+	host  Host
+	onLog func(Log)
+
+	logSeq atomic.Uint64
+
+	mu      sync.Mutex
+	ctx     context.Context
+	scripts map[string]*Script
+}
+
+// NewRuntime creates a runtime for the scripts in dir. onLog is called with
+// every line a script logs.
+func NewRuntime(dir string, host Host, onLog func(Log)) *Runtime {
+	return &Runtime{dir: dir, host: host, onLog: onLog, scripts: make(map[string]*Script)}
+}
+
+// Dir returns the scripts directory
+func (r *Runtime) Dir() string {
+	return r.dir
+}
+
+// Run loads the scripts and reloads them as they change until ctx is done,
+// then stops every script
+func (r *Runtime) Run(ctx context.Context) {
+	r.mu.Lock()
+	r.ctx = ctx
+	r.mu.Unlock()
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+	for {
+		r.scan()
+		select {
+		case <-ctx.Done():
+			r.mu.Lock()
+			scripts := r.scripts
+			r.scripts = make(map[string]*Script)
+			r.ctx = nil
+			r.mu.Unlock()
+			for _, s := range scripts {
+				s.stop()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan starts new scripts, restarts changed ones and stops removed ones
+func (r *Runtime) scan() {
+	found := make(map[string]os.FileInfo)
+	entries, err := os.ReadDir(r.dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".star") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			found[strings.TrimSuffix(entry.Name(), ".star")] = info
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ctx == nil {
+		return
+	}
+	for name, s := range r.scripts {
+		info, ok := found[name]
+		if ok && info.ModTime().Equal(s.modified) && info.Size() == s.size {
+			continue
+		}
+		s.stop()
+		delete(r.scripts, name)
+		if !ok {
+			r.log(name, "info", "script removed")
+		}
+	}
+	for name, info := range found {
+		if _, running := r.scripts[name]; running {
+			continue
+		}
+		r.scripts[name] = r.start(name, info)
+	}
+}
+
+// start loads and runs a script. The caller holds r.mu.
+func (r *Runtime) start(name string, info os.FileInfo) *Script {
+	ctx, cancel := context.WithCancel(r.ctx)
+	s := &Script{
+		runtime:  r,
+		name:     name,
+		modified: info.ModTime(),
+		size:     info.Size(),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		ticks:    make(chan []Change, tickQueue),
+		state:    StateRunning,
+		loaded:   time.Now(),
+	}
+	s.thread = &starlark.Thread{
+		Name:  name,
+		Print: func(_ *starlark.Thread, msg string) { r.logf(s, "info", "%s", msg) },
+		Load: func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+			return nil, fmt.Errorf("load is not available to scripts")
+		},
+	}
+
+	src, err := os.ReadFile(filepath.Join(r.dir, name+".star"))
+	if err != nil {
+		s.fail(err)
+		close(s.done)
+		return s
+	}
+	go s.run(src)
+	return s
+}
+
+// log records a script's log line and passes it on. The caller holds r.mu;
+// scripts' own goroutines use logf.
+func (r *Runtime) log(name, level, message string) {
+	entry := Log{Seq: r.logSeq.Add(1), Script: name, Time: time.Now(), Level: level, Message: message}
+	if s := r.scripts[name]; s != nil {
+		s.addLog(entry)
+	}
+	if r.onLog != nil {
+		r.onLog(entry)
+	}
+}
+
+// logf records a log line from a script's goroutine
+func (r *Runtime) logf(s *Script, level, format string, args ...interface{}) {
+	entry := Log{Seq: r.logSeq.Add(1), Script: s.name, Time: time.Now(), Level: level, Message: fmt.Sprintf(format, args...)}
+	s.addLog(entry)
+	if r.onLog != nil {
+		r.onLog(entry)
+	}
+}
+
+// Tick passes one poll and the changes it found to every script. A script
+// that has fallen tickQueue polls behind misses the poll.
+func (r *Runtime) Tick(changes []Change) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.scripts {
+		select {
+		case <-s.done:
+			// Finished and failed scripts take no more polls
+			continue
+		default:
+		}
+		select {
+		case s.ticks <- changes:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// List describes every loaded script by name
+func (r *Runtime) List() []Info {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	infos := make([]Info, 0, len(r.scripts))
+	for _, s := range r.scripts {
+		infos = append(infos, s.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Get describes a script and returns its log lines after since
+func (r *Runtime) Get(name string, since uint64) (Info, []Log, error) {
+	r.mu.Lock()
+	s, ok := r.scripts[name]
+	r.mu.Unlock()
+	if !ok {
+		return Info{}, nil, ErrUnknownScript
+	}
+	return s.info(), s.logsSince(since), nil
+}
+
+// Restart reruns a script from the top, as if its file had changed
+func (r *Runtime) Restart(name string) (Info, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.scripts[name]
+	if !ok || r.ctx == nil {
+		return Info{}, ErrUnknownScript
+	}
+	s.stop()
+	info, err := os.Stat(filepath.Join(r.dir, name+".star"))
+	if err != nil {
+		delete(r.scripts, name)
+		return Info{}, ErrUnknownScript
+	}
+	r.scripts[name] = r.start(name, info)
+	r.log(name, "info", "script restarted")
+	return r.scripts[name].info(), nil
+}
+
+// Script is one running script
+type Script struct {
+	runtime  *Runtime
+	name     string
+	modified time.Time
+	size     int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	ticks  chan []Change
+	thread *starlark.Thread
+
+	// handlers and polls are only touched by the script's goroutine
+	handlers []handler
+	polls    atomic.Uint64
+	dropped  atomic.Uint64
+
+	mu       sync.Mutex
+	state    string
+	err      string
+	loaded   time.Time
+	logs     []Log
+	nhandler int
+}
+
+// handler is a function registered with on_change
+type handler struct {
+	pattern string
+	fn      starlark.Callable
+}
+
+// run executes the top-level code, then handles changes until stopped
+func (s *Script) run(src []byte) {
+	defer close(s.done)
+	s.runtime.logf(s, "info", "script started")
+
+	s.resetSteps()
+	if _, err := starlark.ExecFileOptions(fileOptions, s.thread, s.name+".star", src, s.builtins()); err != nil {
+		s.fail(err)
+		return
+	}
+	if len(s.handlers) == 0 {
+		s.setState(StateFinished, "")
+		s.runtime.logf(s, "info", "script finished")
+		return
+	}
+
+	s.setState(StateWaiting, "")
+	for {
+		changes, err := s.nextTick()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		for _, change := range changes {
+			for _, h := range s.handlers {
+				if !matchChange(h.pattern, change.Name) {
+					continue
+				}
+				s.resetSteps()
+				args := starlark.Tuple{starlark.String(change.Name), toStarlark(change.Old), toStarlark(change.New)}
+				if _, err := starlark.Call(s.thread, h.fn, args, nil); err != nil {
+					s.fail(err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// nextTick waits for the next poll
+func (s *Script) nextTick() ([]Change, error) {
+	select {
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	case changes := <-s.ticks:
+		s.polls.Add(1)
+		return changes, nil
+	}
+}
+
+// resetSteps grants the script another maxSteps of work
+func (s *Script) resetSteps() {
+	s.thread.SetMaxExecutionSteps(s.thread.ExecutionSteps() + maxSteps)
+}
+
+// stop cancels the script and waits for it to end
+func (s *Script) stop() {
+	s.cancel()
+	s.thread.Cancel("script stopped")
+	<-s.done
+}
+
+// fail records why the script ended
+func (s *Script) fail(err error) {
+	if s.ctx.Err() != nil {
+		s.setState(StateStopped, "")
+		return
+	}
+	message := err.Error()
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		message = evalErr.Backtrace()
+	}
+	s.setState(StateFailed, message)
+	s.runtime.logf(s, "error", "%s", message)
+}
+
+func (s *Script) setState(state, err string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state, s.err = state, err
+	s.nhandler = len(s.handlers)
+}
+
+func (s *Script) addLog(entry Log) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.logs) == maxLogs {
+		s.logs = append(s.logs[:0], s.logs[1:]...)
+	}
+	s.logs = append(s.logs, entry)
+}
+
+func (s *Script) logsSince(since uint64) []Log {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	logs := []Log{}
+	for _, entry := range s.logs {
+		if entry.Seq > since {
+			logs = append(logs, entry)
+		}
+	}
+	return logs
+}
+
+func (s *Script) info() Info {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Info{
+		Name:     s.name,
+		State:    s.state,
+		Error:    s.err,
+		Loaded:   s.loaded,
+		Modified: s.modified,
+		Handlers: s.nhandler,
+		Polls:    s.polls.Load(),
+		Dropped:  s.dropped.Load(),
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"RetroGameAnalysis/script"
+	"RetroGameAnalysis/server"
+	"RetroGameAnalysis/state"
+	"github.com/gorilla/mux"
+)
+
+// ScriptDetail is a script with its recent log lines
+type ScriptDetail struct {
+	script.Info
+	Logs []script.Log `json:"logs"`
+}
+
+// scriptHost gives scripts the same property and memory access as the
+// commands, so writes keep frozen values in step
+type scriptHost struct {
+	session *Session
+}
+
+func (h scriptHost) ReadProperty(name string) (interface{}, error) {
+	value, err := h.session.readProperty(name)
+	if err != nil {
+		return nil, err
+	}
+	return value.Value, nil
+}
+
+func (h scriptHost) WriteProperty(name string, value interface{}) error {
+	return h.session.writeProperty(name, value)
+}
+
+func (h scriptHost) ReadMemory(address uint32, length int) ([]byte, error) {
+	return h.session.readMemoryBytes(address, length)
+}
+
+func (h scriptHost) WriteMemory(address uint32, data []byte) error {
+	if len(data) > maxMemoryRead {
+		return server.NewCommandError(server.ErrorInvalidRequest, "length must be between 1 and %d", maxMemoryRead)
+	}
+	if err := h.session.driver.WriteBytes(address, data); err != nil {
+		return server.NewCommandError(ErrorDriver, "failed to write memory: %v", err)
+	}
+	return nil
+}
+
+// newScriptRuntime creates the session's script runtime. Script logs go to
+// the server log and are broadcast as script_log messages.
+func (s *Session) newScriptRuntime() *script.Runtime {
+	return script.NewRuntime(s.config.ScriptsDir, scriptHost{session: s}, func(entry script.Log) {
+		log.Printf("📜 [%s] %s: %s", s.id, entry.Script, entry.Message)
+		s.wsManager.BroadcastMessage(server.Message{
+			Type:      "script_log",
+			Data:      entry,
+			Timestamp: entry.Time,
+		})
+	})
+}
+
+// tickScripts passes a poll and the property changes it found to the scripts
+func (s *Session) tickScripts(changes []state.Change) {
+	scriptChanges := make([]script.Change, len(changes))
+	for i, change := range changes {
+		scriptChanges[i] = script.Change{Name: server.PropertyName(change.Path), Old: change.Old, New: change.Value}
+	}
+	s.scripts.Tick(scriptChanges)
+}
+
+// scriptDetail describes a script with its log lines after since
+func (s *Session) scriptDetail(name string, since uint64) (*ScriptDetail, error) {
+	info, logs, err := s.scripts.Get(name, since)
+	if err != nil {
+		return nil, scriptError(name, err)
+	}
+	return &ScriptDetail{Info: info, Logs: logs}, nil
+}
+
+// restartScript reruns a script from the top
+func (s *Session) restartScript(name string) (*script.Info, error) {
+	info, err := s.scripts.Restart(name)
+	if err != nil {
+		return nil, scriptError(name, err)
+	}
+	return &info, nil
+}
+
+func scriptError(name string, err error) error {
+	if errors.Is(err, script.ErrUnknownScript) {
+		return server.NewCommandError(server.ErrorNotFound, "unknown script %q", name)
+	}
+	return err
+}
+
+func (s *Session) registerScriptCommands() {
+	s.wsManager.RegisterCommand("script_list", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{"dir": s.scripts.Dir(), "scripts": s.scripts.List()}, nil
+	})
+
+	s.wsManager.RegisterCommand("script_logs", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request struct {
+			Name  string `json:"name"`
+			Since uint64 `json:"since"`
+		}
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		return s.scriptDetail(request.Name, request.Since)
+	})
+
+	s.wsManager.RegisterCommand("script_restart", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request struct {
+			Name string `json:"name"`
+		}
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		return s.restartScript(request.Name)
+	})
+}
+
+// REST handlers for scripts
+
+func (s *Session) handleListScripts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"dir": s.scripts.Dir(), "scripts": s.scripts.List()})
+}
+
+func (s *Session) handleGetScript(w http.ResponseWriter, r *http.Request) {
+	var since uint64
+	if value := r.URL.Query().Get("since"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "invalid since %q", value))
+			return
+		}
+		since = parsed
+	}
+
+	detail, err := s.scriptDetail(mux.Vars(r)["name"], since)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+func (s *Session) handleRestartScript(w http.ResponseWriter, r *http.Request) {
+	info, err := s.restartScript(mux.Vars(r)["name"])
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
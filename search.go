@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"RetroGameAnalysis/connection"
+	"RetroGameAnalysis/search"
+	"RetroGameAnalysis/server"
+	"github.com/gorilla/mux"
+)
+
+// maxSearches bounds the number of RAM searches a session keeps at once
+const maxSearches = 8
+
+// maxSearchBytes bounds the memory a search snapshots on every filter
+const maxSearchBytes = 16 << 20
+
+// Result page sizes
+const (
+	defaultSearchPage = 100
+	maxSearchPage     = 1000
+)
+
+// SearchRegion is a memory range to search, with inclusive bounds
+type SearchRegion struct {
+	Start Address `json:"start"`
+	End   Address `json:"end"`
+}
+
+// SearchRequest starts a RAM search. Without regions the session's memory
+// blocks are searched.
+type SearchRequest struct {
+	Type    string         `json:"type"`
+	Regions []SearchRegion `json:"regions,omitempty"`
+	Aligned bool           `json:"aligned"`
+}
+
+// SearchFilterRequest narrows a search using a fresh snapshot. Value accepts
+// numbers and decimal or 0x-prefixed strings.
+type SearchFilterRequest struct {
+	Op    string      `json:"op"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// SearchInfo describes a RAM search
+type SearchInfo struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Regions    []search.Region `json:"regions"`
+	Aligned    bool            `json:"aligned"`
+	Candidates int             `json:"candidates"`
+	Filters    []search.Filter `json:"filters"`
+	Created    time.Time       `json:"created"`
+	Updated    time.Time       `json:"updated"`
+}
+
+// SearchPage is one page of a search's candidates
+type SearchPage struct {
+	SearchInfo
+	Offset  int             `json:"offset"`
+	Limit   int             `json:"limit"`
+	Results []search.Result `json:"results"`
+}
+
+// searchTable holds a session's RAM searches by ID
+type searchTable struct {
+	mu       sync.Mutex
+	searches map[string]*search.Search
+	nextID   uint64
+}
+
+func newSearchTable() *searchTable {
+	return &searchTable{searches: make(map[string]*search.Search)}
+}
+
+// add stores a search under a new ID, or returns false when the table is full
+func (t *searchTable) add(s *search.Search) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.searches) >= maxSearches {
+		return "", false
+	}
+	t.nextID++
+	id := strconv.FormatUint(t.nextID, 10)
+	t.searches[id] = s
+	return id, true
+}
+
+func (t *searchTable) get(id string) (*search.Search, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.searches[id]
+	if !ok {
+		return nil, server.NewCommandError(server.ErrorNotFound, "unknown search %q", id)
+	}
+	return s, nil
+}
+
+func (t *searchTable) remove(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.searches[id]
+	delete(t.searches, id)
+	return ok
+}
+
+// ids returns every search ID in creation order
+func (t *searchTable) ids() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ids := make([]string, 0, len(t.searches))
+	for id := range t.searches {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, _ := strconv.ParseUint(ids[i], 10, 64)
+		b, _ := strconv.ParseUint(ids[j], 10, 64)
+		return a < b
+	})
+	return ids
+}
+
+// searchRegions validates requested regions or falls back to the session's
+// memory blocks
+func (s *Session) searchRegions(requested []SearchRegion) ([]search.Region, error) {
+	if len(requested) == 0 {
+		blocks, err := s.memoryBlocks()
+		if err != nil {
+			return nil, server.NewCommandError(server.ErrorInvalidRequest, "%v; give regions explicitly", err)
+		}
+		requested = make([]SearchRegion, len(blocks))
+		for i, block := range blocks {
+			requested[i] = SearchRegion{Start: Address(block.Start), End: Address(block.End)}
+		}
+	}
+
+	regions := make([]search.Region, len(requested))
+	total := 0
+	for i, region := range requested {
+		if region.End < region.Start {
+			return nil, server.NewCommandError(server.ErrorInvalidRequest, "region 0x%X-0x%X ends before it starts", region.Start, region.End)
+		}
+		regions[i] = search.Region{Start: uint32(region.Start), End: uint32(region.End)}
+		total += regions[i].Size()
+	}
+	if total > maxSearchBytes {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "regions cover %d bytes; at most %d can be searched", total, maxSearchBytes)
+	}
+
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Start < regions[j].Start })
+	for i := 1; i < len(regions); i++ {
+		if regions[i].Start <= regions[i-1].End {
+			return nil, server.NewCommandError(server.ErrorInvalidRequest, "regions 0x%X-0x%X and 0x%X-0x%X overlap",
+				regions[i-1].Start, regions[i-1].End, regions[i].Start, regions[i].End)
+		}
+	}
+	return regions, nil
+}
+
+// snapshotRegions reads every region in one ReadMemoryBlocks call
+func (s *Session) snapshotRegions(regions []search.Region) (*search.Snapshot, error) {
+	blocks := make([]connection.MemoryBlock, len(regions))
+	for i, region := range regions {
+		blocks[i] = connection.MemoryBlock{Name: fmt.Sprintf("0x%X", region.Start), Start: region.Start, End: region.End}
+	}
+
+	data, err := s.driver.ReadMemoryBlocks(blocks)
+	if err != nil {
+		return nil, server.NewCommandError(ErrorDriver, "failed to snapshot memory: %v", err)
+	}
+	snapshot, err := search.NewSnapshot(regions, data, time.Now())
+	if err != nil {
+		return nil, server.NewCommandError(ErrorDriver, "failed to snapshot memory: %v", err)
+	}
+	return snapshot, nil
+}
+
+// startSearch takes the first snapshot of a new search
+func (s *Session) startSearch(request SearchRequest) (*SearchInfo, error) {
+	valueType, err := search.ParseType(request.Type)
+	if err != nil {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "%v", err)
+	}
+	regions, err := s.searchRegions(request.Regions)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := s.snapshotRegions(regions)
+	if err != nil {
+		return nil, err
+	}
+
+	ramSearch := search.New(valueType, snapshot, request.Aligned)
+	id, ok := s.searches.add(ramSearch)
+	if !ok {
+		return nil, server.NewCommandError(ErrorConflict, "session %s already has %d searches; delete one first", s.id, maxSearches)
+	}
+	return searchInfo(id, ramSearch), nil
+}
+
+// filterSearch narrows a search by comparing a fresh snapshot with the last one
+func (s *Session) filterSearch(id string, request SearchFilterRequest) (*SearchInfo, error) {
+	ramSearch, err := s.searches.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := search.Filter{Op: request.Op}
+	if request.Value != nil {
+		value, err := toUint(request.Value)
+		if err != nil {
+			return nil, server.NewCommandError(server.ErrorInvalidRequest, "value: %v", err)
+		}
+		filter.Value = &value
+	}
+	if err := filter.Validate(ramSearch.Type()); err != nil {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "%v", err)
+	}
+
+	snapshot, err := s.snapshotRegions(ramSearch.Regions())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ramSearch.Apply(snapshot, filter); err != nil {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "%v", err)
+	}
+	return searchInfo(id, ramSearch), nil
+}
+
+// searchResults returns a page of a search's candidates
+func (s *Session) searchResults(id string, offset, limit int) (*SearchPage, error) {
+	ramSearch, err := s.searches.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "offset must not be negative")
+	}
+	if limit == 0 {
+		limit = defaultSearchPage
+	}
+	if limit < 0 || limit > maxSearchPage {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "limit must be between 1 and %d", maxSearchPage)
+	}
+
+	return &SearchPage{
+		SearchInfo: *searchInfo(id, ramSearch),
+		Offset:     offset,
+		Limit:      limit,
+		Results:    ramSearch.Results(offset, limit),
+	}, nil
+}
+
+// listSearches describes every search of the session
+func (s *Session) listSearches() []SearchInfo {
+	ids := s.searches.ids()
+	infos := make([]SearchInfo, 0, len(ids))
+	for _, id := range ids {
+		if ramSearch, err := s.searches.get(id); err == nil {
+			infos = append(infos, *searchInfo(id, ramSearch))
+		}
+	}
+	return infos
+}
+
+// deleteSearch discards a search
+func (s *Session) deleteSearch(id string) error {
+	if !s.searches.remove(id) {
+		return server.NewCommandError(server.ErrorNotFound, "unknown search %q", id)
+	}
+	return nil
+}
+
+func searchInfo(id string, ramSearch *search.Search) *SearchInfo {
+	filters := ramSearch.Filters()
+	if filters == nil {
+		filters = []search.Filter{}
+	}
+	return &SearchInfo{
+		ID:         id,
+		Type:       ramSearch.Type().Name,
+		Regions:    ramSearch.Regions(),
+		Aligned:    ramSearch.Aligned(),
+		Candidates: ramSearch.Count(),
+		Filters:    filters,
+		Created:    ramSearch.Created(),
+		Updated:    ramSearch.Updated(),
+	}
+}
+
+// registerSearchCommands exposes RAM search over WebSocket
+func (s *Session) registerSearchCommands() {
+	s.wsManager.RegisterCommand("search_list", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{"searches": s.listSearches()}, nil
+	})
+
+	s.wsManager.RegisterCommand("search_start", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request SearchRequest
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		return s.startSearch(request)
+	})
+
+	s.wsManager.RegisterCommand("search_filter", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request struct {
+			ID string `json:"id"`
+			SearchFilterRequest
+		}
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		return s.filterSearch(request.ID, request.SearchFilterRequest)
+	})
+
+	s.wsManager.RegisterCommand("search_results", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request struct {
+			ID     string `json:"id"`
+			Offset int    `json:"offset"`
+			Limit  int    `json:"limit"`
+		}
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		return s.searchResults(request.ID, request.Offset, request.Limit)
+	})
+
+	s.wsManager.RegisterCommand("search_delete", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request struct {
+			ID string `json:"id"`
+		}
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		if err := s.deleteSearch(request.ID); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"id": request.ID, "deleted": true}, nil
+	})
+}
+
+// REST handlers for RAM search
+
+func (s *Session) handleListSearches(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"searches": s.listSearches()})
+}
+
+func (s *Session) handleStartSearch(w http.ResponseWriter, r *http.Request) {
+	var request SearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "invalid request body: %v", err))
+		return
+	}
+
+	info, err := s.startSearch(request)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(info)
+}
+
+func (s *Session) handleGetSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var offset, limit int
+	for name, target := range map[string]*int{"offset": &offset, "limit": &limit} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "invalid %s %q", name, value))
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	page, err := s.searchResults(mux.Vars(r)["id"], offset, limit)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func (s *Session) handleFilterSearch(w http.ResponseWriter, r *http.Request) {
+	var request SearchFilterRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "invalid request body: %v", err))
+		return
+	}
+
+	info, err := s.filterSearch(mux.Vars(r)["id"], request)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func (s *Session) handleDeleteSearch(w http.ResponseWriter, r *http.Request) {
+	if err := s.deleteSearch(mux.Vars(r)["id"]); err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
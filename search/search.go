@@ -0,0 +1,321 @@
+// Package search implements RAM search: every address in a set of memory
+// regions starts as a candidate, and each filter compares a fresh snapshot
+// with the previous one to narrow the candidates down to the few addresses
+// that hold a value of interest.
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Filter operations
+const (
+	OpEqual     = "equal"     // Current value equals Value
+	OpNotEqual  = "not_equal" // Current value differs from Value
+	OpChanged   = "changed"   // Value differs from the previous snapshot
+	OpUnchanged = "unchanged" // Value matches the previous snapshot
+	OpIncreased = "increased" // Value grew, by exactly Value when given
+	OpDecreased = "decreased" // Value shrank, by exactly Value when given
+)
+
+var typePattern = regexp.MustCompile(`^(uint|bcd)(8|16|24|32)(le|be)?$`)
+
+// Type is the encoding of the values being searched for
+type Type struct {
+	Name      string
+	Size      int  // Bytes per value, 1 to 4
+	BigEndian bool // Byte order of multi-byte values
+	BCD       bool // Packed binary-coded decimal, two digits per byte
+}
+
+// ParseType parses a type name such as uint8, uint16le, uint24be or bcd24.
+// Multi-byte integers need an explicit byte order; BCD defaults to big-endian,
+// which is how Game Boy games store money and scores.
+func ParseType(name string) (Type, error) {
+	match := typePattern.FindStringSubmatch(name)
+	if match == nil {
+		return Type{}, fmt.Errorf("unknown type %q (expected uint8, uint16le, uint16be, uint24le, uint24be, uint32le, uint32be or bcd8 to bcd32)", name)
+	}
+
+	bits, _ := strconv.Atoi(match[2])
+	t := Type{Name: name, Size: bits / 8, BCD: match[1] == "bcd"}
+	switch {
+	case t.Size == 1 && match[3] != "":
+		return Type{}, fmt.Errorf("type %q: single bytes have no byte order", name)
+	case t.Size > 1 && match[3] == "" && !t.BCD:
+		return Type{}, fmt.Errorf("type %q needs a byte order, e.g. %sle or %sbe", name, name, name)
+	}
+	t.BigEndian = match[3] == "be" || (t.BCD && match[3] == "")
+	return t, nil
+}
+
+// Decode converts Size bytes into a value. BCD bytes with a digit above 9 are
+// not valid BCD and report false.
+func (t Type) Decode(data []byte) (uint64, bool) {
+	var value uint64
+	for i := 0; i < t.Size; i++ {
+		b := data[i]
+		if !t.BigEndian {
+			b = data[t.Size-1-i]
+		}
+		if t.BCD {
+			if b>>4 > 9 || b&0x0F > 9 {
+				return 0, false
+			}
+			value = value*100 + uint64(b>>4)*10 + uint64(b&0x0F)
+		} else {
+			value = value<<8 | uint64(b)
+		}
+	}
+	return value, true
+}
+
+// Max returns the largest value the type can hold
+func (t Type) Max() uint64 {
+	if t.BCD {
+		max := uint64(1)
+		for i := 0; i < 2*t.Size; i++ {
+			max *= 10
+		}
+		return max - 1
+	}
+	return uint64(1)<<(8*t.Size) - 1
+}
+
+// Region is an inclusive range of addresses
+type Region struct {
+	Start uint32 `json:"start"`
+	End   uint32 `json:"end"`
+}
+
+// Size returns the number of bytes in the region
+func (r Region) Size() int {
+	return int(r.End-r.Start) + 1
+}
+
+// Snapshot is the contents of a search's regions at one point in time
+type Snapshot struct {
+	regions []Region
+	data    [][]byte
+	taken   time.Time
+}
+
+// NewSnapshot pairs each region with its memory, as returned by a driver's
+// ReadMemoryBlocks keyed by start address
+func NewSnapshot(regions []Region, blocks map[uint32][]byte, taken time.Time) (*Snapshot, error) {
+	snapshot := &Snapshot{regions: regions, data: make([][]byte, len(regions)), taken: taken}
+	for i, region := range regions {
+		data, ok := blocks[region.Start]
+		if !ok || len(data) < region.Size() {
+			return nil, fmt.Errorf("short read of region 0x%X-0x%X", region.Start, region.End)
+		}
+		snapshot.data[i] = data
+	}
+	return snapshot, nil
+}
+
+// bytes returns size bytes at address, or nil when they are not all in one region
+func (s *Snapshot) bytes(address uint32, size int) []byte {
+	for i, region := range s.regions {
+		if address >= region.Start && uint64(address)+uint64(size)-1 <= uint64(region.End) {
+			offset := address - region.Start
+			return s.data[i][offset : offset+uint32(size)]
+		}
+	}
+	return nil
+}
+
+// Filter narrows a search's candidates. Value is the value compared with for
+// equal and not_equal, and the exact difference for increased and decreased.
+type Filter struct {
+	Op    string  `json:"op"`
+	Value *uint64 `json:"value,omitempty"`
+}
+
+// Validate checks the operation and that a value is given where needed
+func (f Filter) Validate(t Type) error {
+	switch f.Op {
+	case OpEqual, OpNotEqual:
+		if f.Value == nil {
+			return fmt.Errorf("%s needs a value", f.Op)
+		}
+	case OpChanged, OpUnchanged:
+		if f.Value != nil {
+			return fmt.Errorf("%s does not take a value", f.Op)
+		}
+	case OpIncreased, OpDecreased:
+	default:
+		return fmt.Errorf("unknown filter %q (expected equal, not_equal, changed, unchanged, increased or decreased)", f.Op)
+	}
+	if f.Value != nil && *f.Value > t.Max() {
+		return fmt.Errorf("value %d exceeds the %s maximum %d", *f.Value, t.Name, t.Max())
+	}
+	return nil
+}
+
+// match reports whether a candidate passes the filter
+func (f Filter) match(current, previous uint64) bool {
+	switch f.Op {
+	case OpEqual:
+		return current == *f.Value
+	case OpNotEqual:
+		return current != *f.Value
+	case OpChanged:
+		return current != previous
+	case OpUnchanged:
+		return current == previous
+	case OpIncreased:
+		return current > previous && (f.Value == nil || current-previous == *f.Value)
+	case OpDecreased:
+		return current < previous && (f.Value == nil || previous-current == *f.Value)
+	}
+	return false
+}
+
+// Result is a candidate address with its value in the last two snapshots
+type Result struct {
+	Address  uint32  `json:"address"`
+	Value    uint64  `json:"value"`
+	Previous *uint64 `json:"previous,omitempty"`
+}
+
+// Search is one RAM search. It is safe for concurrent use.
+type Search struct {
+	typ     Type
+	regions []Region
+	aligned bool
+	created time.Time
+
+	mu         sync.Mutex
+	current    *Snapshot
+	previous   *Snapshot
+	candidates []uint32
+	filters    []Filter
+}
+
+// New starts a search with every address of the snapshot's regions as a
+// candidate. Aligned searches only consider addresses that are a multiple of
+// the type's size.
+func New(t Type, snapshot *Snapshot, aligned bool) *Search {
+	s := &Search{typ: t, regions: snapshot.regions, aligned: aligned, created: snapshot.taken, current: snapshot}
+
+	step := uint32(1)
+	if aligned {
+		step = uint32(t.Size)
+	}
+	for _, region := range snapshot.regions {
+		first := region.Start
+		if aligned && first%step != 0 {
+			first += step - first%step
+		}
+		for address := uint64(first); address+uint64(t.Size)-1 <= uint64(region.End); address += uint64(step) {
+			if _, ok := t.Decode(snapshot.bytes(uint32(address), t.Size)); ok {
+				s.candidates = append(s.candidates, uint32(address))
+			}
+		}
+	}
+	sort.Slice(s.candidates, func(i, j int) bool { return s.candidates[i] < s.candidates[j] })
+	return s
+}
+
+// Regions returns the searched regions
+func (s *Search) Regions() []Region {
+	return s.regions
+}
+
+// Type returns the searched value type
+func (s *Search) Type() Type {
+	return s.typ
+}
+
+// Aligned reports whether only aligned addresses are candidates
+func (s *Search) Aligned() bool {
+	return s.aligned
+}
+
+// Created returns the time of the first snapshot
+func (s *Search) Created() time.Time {
+	return s.created
+}
+
+// Apply compares snapshot with the previous one and keeps the candidates that
+// pass the filter. It returns the number left.
+func (s *Search) Apply(snapshot *Snapshot, filter Filter) (int, error) {
+	if err := filter.Validate(s.typ); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.candidates[:0]
+	for _, address := range s.candidates {
+		current, ok := s.typ.Decode(snapshot.bytes(address, s.typ.Size))
+		if !ok {
+			continue
+		}
+		previous, _ := s.typ.Decode(s.current.bytes(address, s.typ.Size))
+		if filter.match(current, previous) {
+			kept = append(kept, address)
+		}
+	}
+
+	s.candidates = kept
+	s.previous, s.current = s.current, snapshot
+	s.filters = append(s.filters, filter)
+	return len(kept), nil
+}
+
+// Count returns the number of candidates left
+func (s *Search) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.candidates)
+}
+
+// Filters returns the filters applied so far
+func (s *Search) Filters() []Filter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Filter(nil), s.filters...)
+}
+
+// Updated returns the time of the latest snapshot
+func (s *Search) Updated() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current.taken
+}
+
+// Results returns up to limit candidates starting at offset, in address
+// order, with their values in the latest and previous snapshots
+func (s *Search) Results(offset, limit int) []Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if offset >= len(s.candidates) {
+		return []Result{}
+	}
+	end := offset + limit
+	if end > len(s.candidates) {
+		end = len(s.candidates)
+	}
+
+	results := make([]Result, 0, end-offset)
+	for _, address := range s.candidates[offset:end] {
+		result := Result{Address: address}
+		result.Value, _ = s.typ.Decode(s.current.bytes(address, s.typ.Size))
+		if s.previous != nil {
+			if previous, ok := s.typ.Decode(s.previous.bytes(address, s.typ.Size)); ok {
+				result.Previous = &previous
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
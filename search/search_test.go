@@ -0,0 +1,126 @@
+package search
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseType(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		bigEndian bool
+		bcd       bool
+	}{
+		{"uint8", 1, false, false},
+		{"uint16le", 2, false, false},
+		{"uint16be", 2, true, false},
+		{"uint24be", 3, true, false},
+		{"uint32le", 4, false, false},
+		{"bcd8", 1, true, true},
+		{"bcd24", 3, true, true},
+		{"bcd16le", 2, false, true},
+	}
+	for _, test := range tests {
+		typ, err := ParseType(test.name)
+		if err != nil {
+			t.Errorf("ParseType(%q): %v", test.name, err)
+			continue
+		}
+		if typ.Size != test.size || typ.BigEndian != test.bigEndian || typ.BCD != test.bcd {
+			t.Errorf("ParseType(%q) = %+v", test.name, typ)
+		}
+	}
+
+	for _, name := range []string{"uint16", "uint8le", "int8", "uint64le", "bcd"} {
+		if _, err := ParseType(name); err == nil {
+			t.Errorf("ParseType(%q) accepted an invalid type", name)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		typ   string
+		data  []byte
+		want  uint64
+		valid bool
+	}{
+		{"uint8", []byte{0xFE}, 254, true},
+		{"uint16le", []byte{0x34, 0x12}, 0x1234, true},
+		{"uint16be", []byte{0x12, 0x34}, 0x1234, true},
+		{"uint24le", []byte{0x56, 0x34, 0x12}, 0x123456, true},
+		{"uint24be", []byte{0x12, 0x34, 0x56}, 0x123456, true},
+		{"uint32be", []byte{0xFF, 0xFF, 0xFF, 0xFF}, 0xFFFFFFFF, true},
+
+		// Money as Red and Blue store it: 3000 is 00 30 00
+		{"bcd24", []byte{0x00, 0x30, 0x00}, 3000, true},
+		{"bcd24", []byte{0x99, 0x99, 0x99}, 999999, true},
+		{"bcd16le", []byte{0x34, 0x12}, 1234, true},
+		{"bcd8", []byte{0x1A}, 0, false},
+		{"bcd16be", []byte{0xA0, 0x00}, 0, false},
+	}
+	for _, test := range tests {
+		typ, err := ParseType(test.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := typ.Decode(test.data)
+		if ok != test.valid || got != test.want {
+			t.Errorf("%s decode of % X = %d, %v; want %d, %v", test.typ, test.data, got, ok, test.want, test.valid)
+		}
+	}
+}
+
+func TestMax(t *testing.T) {
+	tests := map[string]uint64{
+		"uint8":    0xFF,
+		"uint24le": 0xFFFFFF,
+		"uint32be": 0xFFFFFFFF,
+		"bcd8":     99,
+		"bcd24":    999999,
+	}
+	for name, want := range tests {
+		typ, _ := ParseType(name)
+		if got := typ.Max(); got != want {
+			t.Errorf("%s max = %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestSearchNarrowsCandidates(t *testing.T) {
+	typ, _ := ParseType("bcd16be")
+	regions := []Region{{Start: 0x100, End: 0x105}}
+	snapshot := func(data ...byte) *Snapshot {
+		s, err := NewSnapshot(regions, map[uint32][]byte{0x100: data}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	// Aligned BCD candidates are 0x100, 0x102 and 0x104; 0x104 holds
+	// invalid BCD and is not one
+	search := New(typ, snapshot(0x00, 0x50, 0x01, 0x00, 0xAB, 0x00), true)
+	if count := search.Count(); count != 2 {
+		t.Fatalf("%d candidates, want 2", count)
+	}
+
+	increase := uint64(25)
+	count, err := search.Apply(snapshot(0x00, 0x75, 0x01, 0x00, 0x00, 0x00), Filter{Op: OpIncreased, Value: &increase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("%d candidates after the filter, want 1", count)
+	}
+	results := search.Results(0, 10)
+	if len(results) != 1 || results[0].Address != 0x100 || results[0].Value != 75 || results[0].Previous == nil || *results[0].Previous != 50 {
+		t.Errorf("results %+v, want 0x100 going from 50 to 75", results)
+	}
+
+	tooBig := uint64(10000)
+	if _, err := search.Apply(snapshot(0, 0, 0, 0, 0, 0), Filter{Op: OpEqual, Value: &tooBig}); err == nil {
+		t.Error("a value above the bcd16 maximum was accepted")
+	}
+}
@@ -0,0 +1,311 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Access error types reported in the "error_type" field
+const (
+	ErrorUnauthorized = "unauthorized"
+	ErrorForbidden    = "forbidden"
+)
+
+// Role is the access level granted to an API key. Each role includes the
+// permissions of the roles below it.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleRead
+	RoleWrite
+	RoleAdmin
+)
+
+// String returns the role's config name
+func (r Role) String() string {
+	switch r {
+	case RoleRead:
+		return "read"
+	case RoleWrite:
+		return "write"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// MarshalJSON encodes the role by name
+func (r Role) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// ParseRole parses a role name
+func ParseRole(name string) (Role, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "read", "read-only", "readonly":
+		return RoleRead, nil
+	case "write", "read-write", "readwrite":
+		return RoleWrite, nil
+	case "admin":
+		return RoleAdmin, nil
+	default:
+		return RoleNone, fmt.Errorf("unknown role %q (expected read, write or admin)", name)
+	}
+}
+
+// APIKey is a static key and the role it grants
+type APIKey struct {
+	Name string
+	Key  string
+	Role Role
+}
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+}
+
+// Identities of callers when no API keys are configured: admins on the
+// server's own machine, readers anywhere else
+var (
+	anonymousAdmin  = Identity{Name: "anonymous", Role: RoleAdmin}
+	anonymousReader = Identity{Name: "anonymous", Role: RoleRead}
+)
+
+// AccessControl authenticates REST and WebSocket callers by API key and
+// checks browser origins against an allowlist
+type AccessControl struct {
+	keys      map[[sha256.Size]byte]Identity
+	origins   map[string]bool
+	anyOrigin bool
+
+	// hosts holds the host:port pairs the server is reached at, which make
+	// an Origin same-origin
+	hosts map[string]bool
+}
+
+// NewAccessControl builds the access rules. With no keys, callers from
+// loopback are anonymous admins and other callers anonymous readers.
+// origins lists the cross-origin pages that may call the API, and "*"
+// allows any origin. listen is the server's listen address; pages served
+// from it are same-origin.
+func NewAccessControl(keys []APIKey, origins []string, listen string) (*AccessControl, error) {
+	access := &AccessControl{
+		keys:    make(map[[sha256.Size]byte]Identity, len(keys)),
+		origins: make(map[string]bool, len(origins)),
+	}
+
+	hosts, err := listenHosts(listen)
+	if err != nil {
+		return nil, err
+	}
+	access.hosts = hosts
+
+	for i, key := range keys {
+		if key.Key == "" {
+			return nil, fmt.Errorf("API key %d has no key", i+1)
+		}
+		if key.Role == RoleNone {
+			return nil, fmt.Errorf("API key %q has no role", key.Name)
+		}
+		hash := sha256.Sum256([]byte(key.Key))
+		if _, exists := access.keys[hash]; exists {
+			return nil, fmt.Errorf("API key %q is configured twice", key.Name)
+		}
+
+		name := key.Name
+		if name == "" {
+			name = fmt.Sprintf("key-%d", i+1)
+		}
+		access.keys[hash] = Identity{Name: name, Role: key.Role}
+	}
+
+	for _, origin := range origins {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin == "*" {
+			access.anyOrigin = true
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid origin %q (expected scheme://host[:port])", origin)
+		}
+		access.origins[strings.ToLower(origin)] = true
+	}
+
+	return access, nil
+}
+
+// listenHosts lists the host:port pairs a listen address is reached at. A
+// wildcard address is reached at localhost and every interface address;
+// names other than those must be listed as allowed origins.
+func listenHosts(listen string) (map[string]bool, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %w", listen, err)
+	}
+
+	names := []string{strings.ToLower(host)}
+	ip := net.ParseIP(host)
+	if host == "" || host == "localhost" || (ip != nil && (ip.IsUnspecified() || ip.IsLoopback())) {
+		names = append(names, "localhost", "127.0.0.1", "::1")
+	}
+	if host == "" || (ip != nil && ip.IsUnspecified()) {
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list interface addresses: %w", err)
+		}
+		for _, addr := range addrs {
+			if network, ok := addr.(*net.IPNet); ok {
+				names = append(names, network.IP.String())
+			}
+		}
+	}
+
+	hosts := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name != "" {
+			hosts[net.JoinHostPort(name, port)] = true
+		}
+	}
+	return hosts, nil
+}
+
+// isLoopback reports whether a request comes from the server's own machine
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Enabled reports whether API keys are required
+func (a *AccessControl) Enabled() bool {
+	return len(a.keys) > 0
+}
+
+// Authenticate identifies the caller from an "Authorization: Bearer <key>"
+// or "X-API-Key" header, or an "api_key" query parameter for browser
+// WebSockets, which cannot set headers
+func (a *AccessControl) Authenticate(r *http.Request) (Identity, error) {
+	if !a.Enabled() {
+		if isLoopback(r) {
+			return anonymousAdmin, nil
+		}
+		return anonymousReader, nil
+	}
+
+	key := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); key == "" && len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		key = strings.TrimSpace(auth[7:])
+	}
+	if key == "" {
+		key = r.URL.Query().Get("api_key")
+	}
+	if key == "" {
+		return Identity{}, NewCommandError(ErrorUnauthorized, "an API key is required")
+	}
+
+	hash := sha256.Sum256([]byte(key))
+	for stored, identity := range a.keys {
+		if subtle.ConstantTimeCompare(stored[:], hash[:]) == 1 {
+			return identity, nil
+		}
+	}
+	return Identity{}, NewCommandError(ErrorUnauthorized, "invalid API key")
+}
+
+// AllowOrigin reports whether a browser page served from origin may call the
+// API. Requests without an Origin header do not come from a browser page and
+// are allowed, as are pages served from the listen address. The request's
+// Host header is not trusted: a DNS rebinding page controls it.
+func (a *AccessControl) AllowOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || a.anyOrigin {
+		return true
+	}
+
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	port := parsed.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[strings.ToLower(parsed.Scheme)]
+	}
+	if a.hosts[net.JoinHostPort(strings.ToLower(parsed.Hostname()), port)] {
+		return true
+	}
+	return a.origins[strings.ToLower(strings.TrimRight(origin, "/"))]
+}
+
+// Authorize checks that a caller holds at least the given role
+func Authorize(identity Identity, role Role) error {
+	if identity.Role < role {
+		return NewCommandError(ErrorForbidden, "%s access required (key %q has %s access)", role, identity.Name, identity.Role)
+	}
+	return nil
+}
+
+// Require wraps a REST handler so it only runs for callers from an allowed
+// origin that hold at least the given role
+func (a *AccessControl) Require(role Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.AllowOrigin(r) {
+			writeAccessError(w, http.StatusForbidden, NewCommandError(ErrorForbidden, "origin %q is not allowed", r.Header.Get("Origin")))
+			return
+		}
+
+		identity, err := a.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="RetroGameAnalysis"`)
+			writeAccessError(w, http.StatusUnauthorized, err.(*CommandError))
+			return
+		}
+		if err := Authorize(identity, role); err != nil {
+			writeAccessError(w, http.StatusForbidden, err.(*CommandError))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// CORS adds CORS headers for allowed origins and answers preflight requests
+func (a *AccessControl) CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && a.AllowOrigin(r) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeAccessError(w http.ResponseWriter, status int, err *CommandError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error_type": err.Type,
+		"message":    err.Message,
+	})
+}
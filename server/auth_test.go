@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testListen = "127.0.0.1:8080"
+
+// serveAccess sends a request through Require for the given role and
+// returns the response
+func serveAccess(access *AccessControl, role Role, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	access.CORS(access.Require(role, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(w, r)
+	return w
+}
+
+func newAccess(t *testing.T, keys []APIKey, origins ...string) *AccessControl {
+	t.Helper()
+	access, err := NewAccessControl(keys, origins, testListen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return access
+}
+
+func TestRequireRoles(t *testing.T) {
+	access := newAccess(t, []APIKey{
+		{Name: "viewer", Key: "read-key", Role: RoleRead},
+		{Name: "tool", Key: "write-key", Role: RoleWrite},
+		{Name: "owner", Key: "admin-key", Role: RoleAdmin},
+	})
+
+	tests := []struct {
+		name      string
+		header    string
+		value     string
+		query     string
+		role      Role
+		status    int
+		errorType string
+	}{
+		{name: "reader reads", header: "X-API-Key", value: "read-key", role: RoleRead, status: http.StatusOK},
+		{name: "reader writes", header: "X-API-Key", value: "read-key", role: RoleWrite, status: http.StatusForbidden, errorType: ErrorForbidden},
+		{name: "writer writes", header: "Authorization", value: "Bearer write-key", role: RoleWrite, status: http.StatusOK},
+		{name: "writer administers", header: "Authorization", value: "bearer write-key", role: RoleAdmin, status: http.StatusForbidden, errorType: ErrorForbidden},
+		{name: "admin administers", query: "api_key=admin-key", role: RoleAdmin, status: http.StatusOK},
+		{name: "missing key", role: RoleRead, status: http.StatusUnauthorized, errorType: ErrorUnauthorized},
+		{name: "invalid key", header: "X-API-Key", value: "guess", role: RoleRead, status: http.StatusUnauthorized, errorType: ErrorUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/state?"+test.query, nil)
+			r.RemoteAddr = "192.0.2.10:40000"
+			if test.header != "" {
+				r.Header.Set(test.header, test.value)
+			}
+
+			w := serveAccess(access, test.role, r)
+			if w.Code != test.status {
+				t.Fatalf("status %d, want %d", w.Code, test.status)
+			}
+			if test.errorType == "" {
+				return
+			}
+			var body map[string]interface{}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body["error_type"] != test.errorType {
+				t.Errorf("error_type %v, want %s", body["error_type"], test.errorType)
+			}
+			if unauthorized := w.Header().Get("WWW-Authenticate") != ""; unauthorized != (test.status == http.StatusUnauthorized) {
+				t.Errorf("WWW-Authenticate %q on a %d response", w.Header().Get("WWW-Authenticate"), w.Code)
+			}
+		})
+	}
+}
+
+func TestRequireWithoutKeys(t *testing.T) {
+	access := newAccess(t, nil)
+
+	tests := []struct {
+		remote string
+		role   Role
+		status int
+	}{
+		{"127.0.0.1:40000", RoleAdmin, http.StatusOK},
+		{"[::1]:40000", RoleAdmin, http.StatusOK},
+		{"192.0.2.10:40000", RoleRead, http.StatusOK},
+		{"192.0.2.10:40000", RoleWrite, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/api/state", nil)
+		r.RemoteAddr = test.remote
+		if w := serveAccess(access, test.role, r); w.Code != test.status {
+			t.Errorf("%s asking for %s access: status %d, want %d", test.remote, test.role, w.Code, test.status)
+		}
+	}
+}
+
+func TestRequireOrigins(t *testing.T) {
+	access := newAccess(t, nil, "https://dashboard.example")
+
+	tests := []struct {
+		name   string
+		origin string
+		host   string
+		status int
+	}{
+		{name: "no origin", status: http.StatusOK},
+		{name: "same origin", origin: "http://127.0.0.1:8080", status: http.StatusOK},
+		{name: "localhost", origin: "http://localhost:8080", status: http.StatusOK},
+		{name: "allowed origin", origin: "https://dashboard.example/", status: http.StatusOK},
+		{name: "foreign origin", origin: "http://evil.example", status: http.StatusForbidden},
+		{name: "other port", origin: "http://127.0.0.1:9000", status: http.StatusForbidden},
+
+		// A DNS rebinding page sends its own name as both Origin and Host
+		{name: "spoofed host", origin: "http://evil.example:8080", host: "evil.example:8080", status: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/state", nil)
+			r.RemoteAddr = "127.0.0.1:40000"
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			if test.host != "" {
+				r.Host = test.host
+			}
+
+			w := serveAccess(access, RoleAdmin, r)
+			if w.Code != test.status {
+				t.Fatalf("status %d, want %d", w.Code, test.status)
+			}
+			allowed := w.Header().Get("Access-Control-Allow-Origin")
+			if want := test.origin != "" && test.status == http.StatusOK; (allowed != "") != want {
+				t.Errorf("Access-Control-Allow-Origin %q", allowed)
+			}
+		})
+	}
+}
+
+func TestNewAccessControlRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []APIKey
+		origins []string
+		listen  string
+	}{
+		{name: "empty key", keys: []APIKey{{Name: "a", Role: RoleRead}}},
+		{name: "no role", keys: []APIKey{{Name: "a", Key: "k"}}},
+		{name: "duplicate key", keys: []APIKey{{Name: "a", Key: "k", Role: RoleRead}, {Name: "b", Key: "k", Role: RoleAdmin}}},
+		{name: "origin without scheme", origins: []string{"dashboard.example"}},
+		{name: "listen without port", listen: "127.0.0.1"},
+	}
+	for _, test := range tests {
+		listen := test.listen
+		if listen == "" {
+			listen = testListen
+		}
+		if _, err := NewAccessControl(test.keys, test.origins, listen); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
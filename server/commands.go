@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Command error types reported in the "error_type" field of error replies
+const (
+	ErrorInvalidRequest = "invalid_request"
+	ErrorUnknownCommand = "unknown_command"
+	ErrorNotFound       = "not_found"
+	ErrorInternal       = "internal_error"
+)
+
+// CommandHandler executes a client command. params holds the request's raw
+// "data" field. The returned value is sent back as the result's data.
+type CommandHandler func(client *Client, params json.RawMessage) (interface{}, error)
+
+// CommandError is an error with a machine-readable type that is returned to
+// the client that sent the command
+type CommandError struct {
+	Type    string
+	Message string
+}
+
+// Error implements the error interface
+func (e *CommandError) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+// NewCommandError creates a typed command error
+func NewCommandError(errorType, format string, args ...interface{}) *CommandError {
+	return &CommandError{Type: errorType, Message: fmt.Sprintf(format, args...)}
+}
+
+// command is a registered command and the role required to run it
+type command struct {
+	role    Role
+	handler CommandHandler
+}
+
+// incomingMessage is a client message with its data left undecoded
+type incomingMessage struct {
+	Type string          `json:"type"`
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+// RegisterCommand makes a command available to clients. A request looks like
+// {"type": "<name>", "id": "<request id>", "data": {...}} and is answered with
+// {"type": "result", "id": ...} or {"type": "error", "id": ...}. Clients
+// whose API key grants less than role get a "forbidden" error.
+func (m *WebSocketManager) RegisterCommand(name string, role Role, handler CommandHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands[name] = command{role: role, handler: handler}
+}
+
+// command returns the command registered for name
+func (m *WebSocketManager) command(name string) (command, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	registered, ok := m.commands[name]
+	return registered, ok
+}
+
+// handleCommand runs a registered command and sends the correlated reply
+func (c *Client) handleCommand(request incomingMessage, handler CommandHandler) {
+	result, err := handler(c, request.Data)
+	if err != nil {
+		c.sendCommandError(request, err)
+		return
+	}
+
+	c.SendMessage(Message{
+		Type:      "result",
+		ID:        request.ID,
+		Data:      result,
+		Timestamp: time.Now(),
+		Metadata:  map[string]interface{}{"command": request.Type},
+	})
+}
+
+// sendCommandError replies with a typed error for a failed command
+func (c *Client) sendCommandError(request incomingMessage, err error) {
+	var commandErr *CommandError
+	if !errors.As(err, &commandErr) {
+		log.Printf("Command %s from client %s failed: %v", request.Type, c.id, err)
+		commandErr = &CommandError{Type: ErrorInternal, Message: err.Error()}
+	}
+
+	c.SendMessage(Message{
+		Type: "error",
+		ID:   request.ID,
+		Data: map[string]interface{}{
+			"error_type": commandErr.Type,
+			"message":    commandErr.Message,
+		},
+		Timestamp: time.Now(),
+		Metadata:  map[string]interface{}{"command": request.Type},
+	})
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encoding is the wire format a client receives messages in
+type Encoding string
+
+const (
+	// EncodingJSON sends each message as a JSON text frame
+	EncodingJSON Encoding = "json"
+
+	// EncodingMsgPack sends each message as a MessagePack binary frame. Field
+	// names match the JSON encoding.
+	EncodingMsgPack Encoding = "msgpack"
+)
+
+// ParseEncoding parses an encoding name; an empty name selects JSON
+func ParseEncoding(name string) (Encoding, error) {
+	switch encoding := Encoding(strings.ToLower(strings.TrimSpace(name))); encoding {
+	case "", EncodingJSON:
+		return EncodingJSON, nil
+	case EncodingMsgPack:
+		return EncodingMsgPack, nil
+	default:
+		return "", fmt.Errorf("unknown encoding %q (expected json or msgpack)", name)
+	}
+}
+
+// frameType returns the WebSocket frame type used for the encoding
+func (e Encoding) frameType() int {
+	if e == EncodingMsgPack {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// marshal encodes a value in the given encoding
+func (e Encoding) marshal(value interface{}) ([]byte, error) {
+	if e != EncodingMsgPack {
+		return json.Marshal(value)
+	}
+
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetCustomStructTag("json")
+	encoder.UseCompactInts(true)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeIncoming converts a received frame into JSON so every encoding is
+// handled by the same message code
+func decodeIncoming(frameType int, data []byte) (json.RawMessage, error) {
+	if frameType != websocket.BinaryMessage {
+		return json.RawMessage(data), nil
+	}
+
+	// Maps decode with string keys, which encoding/json accepts
+	var value interface{}
+	if err := msgpack.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("invalid MessagePack message: %w", err)
+	}
+	return json.Marshal(value)
+}
+
+// batchEnvelope wraps several messages in one frame for clients that opted
+// in with ?batch=true
+func batchEnvelope(encoding Encoding, encoded [][]byte) ([]byte, error) {
+	message := Message{Type: "batch", Timestamp: time.Now()}
+
+	if encoding == EncodingMsgPack {
+		items := make([]msgpack.RawMessage, len(encoded))
+		for i, data := range encoded {
+			items[i] = data
+		}
+		message.Data = items
+	} else {
+		items := make([]json.RawMessage, len(encoded))
+		for i, data := range encoded {
+			items[i] = data
+		}
+		message.Data = items
+	}
+
+	return encoding.marshal(message)
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"RetroGameAnalysis/state"
+)
+
+// SlowConsumerPolicy decides what happens when a client's send queue is full
+type SlowConsumerPolicy string
+
+const (
+	// PolicyConflate merges queued diffs and snapshots down to the latest state.
+	// Diffs that cannot be merged (added or removed fields) and other
+	// broadcasts fall back to a resync.
+	PolicyConflate SlowConsumerPolicy = "conflate"
+
+	// PolicyResync drops every queued diff and queues one full-state snapshot instead
+	PolicyResync SlowConsumerPolicy = "resync"
+
+	// PolicyDisconnect closes the connection with a close reason
+	PolicyDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+// ParseSlowConsumerPolicy parses a policy name
+func ParseSlowConsumerPolicy(name string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(strings.ToLower(strings.TrimSpace(name))); policy {
+	case PolicyConflate, PolicyResync, PolicyDisconnect:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown slow consumer policy %q (expected conflate, resync or disconnect)", name)
+	}
+}
+
+var (
+	errQueueClosed  = errors.New("send queue closed")
+	errSlowConsumer = errors.New("slow consumer: send queue full")
+)
+
+// directQueueFactor bounds direct replies: a client that lets them pile up
+// past this multiple of the queue capacity is disconnected
+const directQueueFactor = 2
+
+// outbound is a queued message. Its encodings are cached so every client that
+// receives the same broadcast shares them.
+type outbound struct {
+	message Message
+	direct  bool // Replies and welcome messages are never dropped or merged
+
+	mu      sync.Mutex
+	encoded map[Encoding][]byte
+}
+
+func newOutbound(message Message, direct bool) *outbound {
+	return &outbound{message: message, direct: direct}
+}
+
+// encode returns the message in the given encoding
+func (o *outbound) encode(encoding Encoding) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if data, ok := o.encoded[encoding]; ok {
+		return data, nil
+	}
+
+	data, err := encoding.marshal(o.message)
+	if err != nil {
+		return nil, err
+	}
+	if o.encoded == nil {
+		o.encoded = make(map[Encoding][]byte, 1)
+	}
+	o.encoded[encoding] = data
+	return data, nil
+}
+
+// isDiff reports whether the message carries field-level changes
+func (o *outbound) isDiff() bool {
+	_, ok := o.message.Data.(ChangeSet)
+	return ok
+}
+
+// isSnapshot reports whether the message carries a full state: it has a
+// sequence number but is not a diff
+func (o *outbound) isSnapshot() bool {
+	return o.message.Seq != 0 && !o.isDiff()
+}
+
+// QueueStats reports a client's send queue metrics
+type QueueStats struct {
+	Depth     int    `json:"queue_depth"`
+	MaxDepth  int    `json:"max_queue_depth"`
+	Capacity  int    `json:"queue_capacity"`
+	Sent      uint64 `json:"sent"`
+	Dropped   uint64 `json:"dropped"`
+	Conflated uint64 `json:"conflated"`
+	Resyncs   uint64 `json:"resyncs"`
+}
+
+// sendQueue is a client's bounded outgoing message queue
+type sendQueue struct {
+	mu       sync.Mutex
+	items    []*outbound
+	capacity int
+	notify   chan struct{}
+
+	closed      bool
+	closeCode   int
+	closeReason string
+
+	maxDepth  int
+	sent      uint64
+	dropped   uint64
+	conflated uint64
+	resyncs   uint64
+}
+
+func newSendQueue(capacity int) *sendQueue {
+	return &sendQueue{
+		items:    make([]*outbound, 0, capacity),
+		capacity: capacity,
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+// signal wakes the write pump; must be called with mu held
+func (q *sendQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *sendQueue) appendLocked(item *outbound) {
+	q.items = append(q.items, item)
+	if len(q.items) > q.maxDepth {
+		q.maxDepth = len(q.items)
+	}
+	q.signal()
+}
+
+// push queues a message, applying the slow-consumer policy when the queue is
+// full. snapshot builds a full-state message for resyncs. It returns
+// errSlowConsumer when the client must be disconnected.
+func (q *sendQueue) push(item *outbound, policy SlowConsumerPolicy, snapshot func() *Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+
+	if item.direct {
+		if len(q.items) >= q.capacity*directQueueFactor {
+			return errSlowConsumer
+		}
+		q.appendLocked(item)
+		return nil
+	}
+
+	if len(q.items) < q.capacity {
+		q.appendLocked(item)
+		return nil
+	}
+
+	switch policy {
+	case PolicyConflate:
+		if q.conflateLocked(item) {
+			q.conflated++
+			q.signal()
+			return nil
+		}
+		if q.resyncLocked(item, snapshot) {
+			return nil
+		}
+
+	case PolicyResync:
+		if q.resyncLocked(item, snapshot) {
+			return nil
+		}
+	}
+
+	q.dropped++
+	return errSlowConsumer
+}
+
+// conflateLocked merges item into the newest queued broadcast of the same
+// type, moving the merged message to the back of the queue. Only state diffs
+// and snapshots are merged; other broadcasts are discrete events that would
+// be lost.
+func (q *sendQueue) conflateLocked(item *outbound) bool {
+	if !item.isDiff() && !item.isSnapshot() {
+		return false
+	}
+
+	for i := len(q.items) - 1; i >= 0; i-- {
+		queued := q.items[i]
+		if queued.direct || queued.message.Type != item.message.Type {
+			continue
+		}
+
+		merged := item
+		if queued.isDiff() || item.isDiff() {
+			var ok bool
+			if merged, ok = mergeDiffs(queued, item); !ok {
+				return false
+			}
+		}
+
+		q.items = append(q.items[:i], q.items[i+1:]...)
+		q.items = append(q.items, merged)
+		return true
+	}
+	return false
+}
+
+// mergeDiffs combines two change sets of replace operations into one that
+// holds the latest value per path
+func mergeDiffs(older, newer *outbound) (*outbound, bool) {
+	olderSet, ok1 := older.message.Data.(ChangeSet)
+	newerSet, ok2 := newer.message.Data.(ChangeSet)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+
+	merged := make([]state.Change, 0, len(olderSet.Changes)+len(newerSet.Changes))
+	index := make(map[string]int)
+
+	for _, changes := range [][]state.Change{olderSet.Changes, newerSet.Changes} {
+		for _, change := range changes {
+			if change.Op != state.OpReplace {
+				return nil, false
+			}
+			if i, exists := index[change.Path]; exists {
+				merged[i].Value = change.Value
+				continue
+			}
+			index[change.Path] = len(merged)
+			merged = append(merged, change)
+		}
+	}
+
+	message := newer.message
+	message.Data = ChangeSet{Changes: merged}
+	return newOutbound(message, false), true
+}
+
+// resyncLocked drops every queued diff and queues a full-state snapshot in
+// their place. Other broadcasts are dropped oldest-first if still needed.
+func (q *sendQueue) resyncLocked(item *outbound, snapshot func() *Message) bool {
+	if snapshot == nil {
+		return false
+	}
+
+	kept := q.items[:0]
+	for _, queued := range q.items {
+		if !queued.direct && (queued.isDiff() || isResync(queued)) {
+			q.dropped++
+			continue
+		}
+		kept = append(kept, queued)
+	}
+	q.items = kept
+
+	if item.isDiff() {
+		// The snapshot already includes this diff's changes
+		q.dropped++
+		message := snapshot()
+		if message == nil {
+			return false
+		}
+		if message.Metadata == nil {
+			message.Metadata = make(map[string]interface{})
+		}
+		message.Metadata["resync"] = true
+		item = newOutbound(*message, false)
+		q.resyncs++
+	}
+
+	for len(q.items) >= q.capacity {
+		dropped := false
+		for i, queued := range q.items {
+			if !queued.direct {
+				q.items = append(q.items[:i], q.items[i+1:]...)
+				q.dropped++
+				dropped = true
+				break
+			}
+		}
+		if !dropped {
+			return false
+		}
+	}
+
+	q.appendLocked(item)
+	return true
+}
+
+func isResync(item *outbound) bool {
+	resync, _ := item.message.Metadata["resync"].(bool)
+	return resync
+}
+
+// drain removes and returns every queued message, plus the close frame to
+// send if the queue has been closed
+func (q *sendQueue) drain() (items []*outbound, closed bool, code int, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items = q.items
+	q.items = make([]*outbound, 0, q.capacity)
+	q.sent += uint64(len(items))
+	return items, q.closed, q.closeCode, q.closeReason
+}
+
+// close stops the queue. With discard set, pending messages are dropped
+// instead of being flushed before the close frame.
+func (q *sendQueue) close(code int, reason string, discard bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	q.closed = true
+	q.closeCode = code
+	q.closeReason = reason
+	if discard {
+		q.dropped += uint64(len(q.items))
+		q.items = nil
+	}
+	q.signal()
+}
+
+// stats returns the queue's metrics
+func (q *sendQueue) stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return QueueStats{
+		Depth:     len(q.items),
+		MaxDepth:  q.maxDepth,
+		Capacity:  q.capacity,
+		Sent:      q.sent,
+		Dropped:   q.dropped,
+		Conflated: q.conflated,
+		Resyncs:   q.resyncs,
+	}
+}
+
+// ClientStats describes a connected client and its send queue
+type ClientStats struct {
+	ID          string    `json:"id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	Identity    Identity  `json:"identity"`
+	Encoding    Encoding  `json:"encoding"`
+	Batch       bool      `json:"batch"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Types       []string  `json:"subscribed_types"`
+	Properties  []string  `json:"subscribed_properties"`
+	QueueStats
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"RetroGameAnalysis/state"
+)
+
+// diffItem is a broadcast of replace operations setting each path to value
+func diffItem(seq uint64, value interface{}, paths ...string) *outbound {
+	changes := make([]state.Change, len(paths))
+	for i, path := range paths {
+		changes[i] = state.Change{Op: state.OpReplace, Path: path, Value: value}
+	}
+	return newOutbound(Message{Type: "pokemon_diff", Seq: seq, Data: ChangeSet{Changes: changes}}, false)
+}
+
+// eventItem is a discrete broadcast without a sequence number
+func eventItem(messageType, name string) *outbound {
+	return newOutbound(Message{Type: messageType, Data: map[string]interface{}{"name": name}}, false)
+}
+
+func snapshotFunc(seq uint64) func() *Message {
+	return func() *Message {
+		return &Message{Type: "pokemon_update", Seq: seq, Data: map[string]interface{}{"money": 3000}}
+	}
+}
+
+// fillQueue creates a queue of the given capacity holding items
+func fillQueue(t *testing.T, capacity int, items ...*outbound) *sendQueue {
+	t.Helper()
+	q := newSendQueue(capacity)
+	for _, item := range items {
+		if err := q.push(item, PolicyDisconnect, nil); err != nil {
+			t.Fatalf("filling the queue: %v", err)
+		}
+	}
+	return q
+}
+
+func TestPushDirectLimit(t *testing.T) {
+	q := fillQueue(t, 2, diffItem(1, 1, "/money"), diffItem(2, 2, "/money"))
+
+	// Replies go past the broadcast capacity up to directQueueFactor times it
+	for i := 0; i < 2; i++ {
+		if err := q.push(newOutbound(Message{Type: "response"}, true), PolicyDisconnect, nil); err != nil {
+			t.Fatalf("direct reply %d: %v", i, err)
+		}
+	}
+	if err := q.push(newOutbound(Message{Type: "response"}, true), PolicyConflate, snapshotFunc(3)); !errors.Is(err, errSlowConsumer) {
+		t.Errorf("direct reply past the limit: %v, want errSlowConsumer", err)
+	}
+	if stats := q.stats(); stats.Depth != 4 {
+		t.Errorf("depth %d, want 4", stats.Depth)
+	}
+}
+
+func TestPushPoliciesAtCapacity(t *testing.T) {
+	tests := []struct {
+		policy   SlowConsumerPolicy
+		err      error
+		types    []string
+		stats    QueueStats
+		snapshot bool
+	}{
+		{
+			policy: PolicyConflate,
+			types:  []string{"pokemon_diff", "pokemon_diff"},
+			stats:  QueueStats{Depth: 2, Conflated: 1},
+		},
+		{
+			policy:   PolicyResync,
+			types:    []string{"pokemon_update"},
+			stats:    QueueStats{Depth: 1, Dropped: 3, Resyncs: 1},
+			snapshot: true,
+		},
+		{
+			policy: PolicyDisconnect,
+			err:    errSlowConsumer,
+			types:  []string{"pokemon_diff", "pokemon_diff"},
+			stats:  QueueStats{Depth: 2, Dropped: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.policy), func(t *testing.T) {
+			q := fillQueue(t, 2, diffItem(1, 1, "/money"), diffItem(2, 2, "/money"))
+			if err := q.push(diffItem(3, 3, "/money"), test.policy, snapshotFunc(3)); !errors.Is(err, test.err) {
+				t.Fatalf("push: %v, want %v", err, test.err)
+			}
+
+			stats := q.stats()
+			if stats.Depth != test.stats.Depth || stats.Dropped != test.stats.Dropped ||
+				stats.Conflated != test.stats.Conflated || stats.Resyncs != test.stats.Resyncs {
+				t.Errorf("stats %+v, want %+v", stats, test.stats)
+			}
+			for i, item := range q.items {
+				if i >= len(test.types) || item.message.Type != test.types[i] {
+					t.Fatalf("queued %d items, want types %v", len(q.items), test.types)
+				}
+			}
+			if last := q.items[len(q.items)-1]; isResync(last) != test.snapshot {
+				t.Errorf("last item %+v, resync %v, want %v", last.message, isResync(last), test.snapshot)
+			}
+		})
+	}
+}
+
+func TestConflateMergesDiffs(t *testing.T) {
+	q := fillQueue(t, 2, diffItem(1, 1, "/money", "/level"), eventItem("split_event", "one"))
+
+	if err := q.push(diffItem(2, 2, "/money", "/badges"), PolicyConflate, nil); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	// The merged diff moves behind the event it was queued before
+	if len(q.items) != 2 || q.items[0].message.Type != "split_event" {
+		t.Fatalf("queue holds %d items starting with %q", len(q.items), q.items[0].message.Type)
+	}
+	merged := q.items[1].message
+	if merged.Seq != 2 {
+		t.Errorf("merged diff has seq %d, want the newer 2", merged.Seq)
+	}
+	want := map[string]interface{}{"/money": 2, "/level": 1, "/badges": 2}
+	changes := merged.Data.(ChangeSet).Changes
+	if len(changes) != len(want) {
+		t.Fatalf("merged changes %+v, want one per path of %v", changes, want)
+	}
+	for _, change := range changes {
+		if change.Value != want[change.Path] {
+			t.Errorf("merged %s = %v, want %v", change.Path, change.Value, want[change.Path])
+		}
+	}
+}
+
+func TestConflateMergesSnapshots(t *testing.T) {
+	older := newOutbound(Message{Type: "pokemon_update", Seq: 1}, false)
+	q := fillQueue(t, 2, older, eventItem("split_event", "one"))
+
+	newer := newOutbound(Message{Type: "pokemon_update", Seq: 2}, false)
+	if err := q.push(newer, PolicyConflate, nil); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if len(q.items) != 2 || q.items[1] != newer {
+		t.Errorf("the newer snapshot did not replace the older one")
+	}
+}
+
+func TestConflateFallsBackToResync(t *testing.T) {
+	q := fillQueue(t, 2, diffItem(1, 1, "/money"), eventItem("split_event", "one"))
+
+	// Added fields cannot be merged into a replace
+	added := newOutbound(Message{Type: "pokemon_diff", Seq: 2, Data: ChangeSet{Changes: []state.Change{
+		{Op: state.OpAdd, Path: "/pokemon/1", Value: map[string]interface{}{}},
+	}}}, false)
+	if err := q.push(added, PolicyConflate, snapshotFunc(2)); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+
+	if len(q.items) != 2 || q.items[0].message.Type != "split_event" || !isResync(q.items[1]) {
+		t.Fatalf("queue does not hold the event and a resync snapshot")
+	}
+	if stats := q.stats(); stats.Conflated != 0 || stats.Resyncs != 1 || stats.Dropped != 2 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestConflateKeepsDiscreteEvents(t *testing.T) {
+	q := fillQueue(t, 2, eventItem("split_event", "one"), eventItem("split_event", "two"))
+
+	// Without a snapshot there is nothing to fall back on
+	if err := q.push(eventItem("split_event", "three"), PolicyConflate, nil); !errors.Is(err, errSlowConsumer) {
+		t.Fatalf("push without a snapshot: %v, want errSlowConsumer", err)
+	}
+
+	// Resyncing makes room by dropping the oldest event, never by merging
+	if err := q.push(eventItem("split_event", "three"), PolicyConflate, snapshotFunc(1)); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	var names []string
+	for _, item := range q.items {
+		names = append(names, item.message.Data.(map[string]interface{})["name"].(string))
+	}
+	if len(names) != 2 || names[0] != "two" || names[1] != "three" {
+		t.Errorf("queued events %v, want [two three]", names)
+	}
+	if stats := q.stats(); stats.Conflated != 0 || stats.Resyncs != 0 {
+		t.Errorf("stats %+v, want no conflation or resync", stats)
+	}
+}
+
+func TestResyncKeepsDirectReplies(t *testing.T) {
+	q := fillQueue(t, 2, newOutbound(Message{Type: "response"}, true), diffItem(1, 1, "/money"))
+
+	if err := q.push(diffItem(2, 2, "/money"), PolicyResync, snapshotFunc(2)); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if len(q.items) != 2 || !q.items[0].direct || !isResync(q.items[1]) {
+		t.Errorf("queue does not hold the reply and a resync snapshot")
+	}
+
+	// A snapshot that cannot be built leaves the client to be disconnected
+	failing := func() *Message { return nil }
+	if err := q.push(diffItem(3, 3, "/money"), PolicyResync, failing); !errors.Is(err, errSlowConsumer) {
+		t.Errorf("push with a failing snapshot: %v, want errSlowConsumer", err)
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// resumable is a disconnected client's state, kept for the grace window so a
+// reconnecting client can pick up where it left off
+type resumable struct {
+	id       string
+	identity string // Only the same API key may resume the session
+	subs     *subscription
+	expires  time.Time
+}
+
+// saveResumable keeps a disconnected client's state until the grace window ends
+func (m *WebSocketManager) saveResumable(client *Client) {
+	if m.config.ResumeGrace <= 0 || client.token == "" {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.resumable[client.token] = &resumable{
+		id:       client.id,
+		identity: client.identity.Name,
+		subs:     client.subs,
+		expires:  time.Now().Add(m.config.ResumeGrace),
+	}
+}
+
+// takeResumable removes and returns the state saved under a resume token
+func (m *WebSocketManager) takeResumable(token string) (*resumable, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved, ok := m.resumable[token]
+	if !ok {
+		return nil, false
+	}
+	delete(m.resumable, token)
+
+	if time.Now().After(saved.expires) {
+		return nil, false
+	}
+	return saved, true
+}
+
+// expireResumable drops saved state whose grace window has ended
+func (m *WebSocketManager) expireResumable() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for token, saved := range m.resumable {
+		if now.After(saved.expires) {
+			delete(m.resumable, token)
+		}
+	}
+}
+
+// replayBuffer keeps the most recent sequenced broadcasts so resumed clients
+// can catch up on the diffs they missed
+type replayBuffer struct {
+	messages []Message
+	start    int
+	count    int
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	return &replayBuffer{messages: make([]Message, size)}
+}
+
+// add records a broadcast; messages without a sequence number are ignored
+func (b *replayBuffer) add(message Message) {
+	if message.Seq == 0 || len(b.messages) == 0 {
+		return
+	}
+
+	end := (b.start + b.count) % len(b.messages)
+	b.messages[end] = message
+	if b.count < len(b.messages) {
+		b.count++
+	} else {
+		b.start = (b.start + 1) % len(b.messages)
+	}
+}
+
+// since returns the buffered messages after seq. It reports false when some
+// of them are no longer buffered or were never broadcast, in which case the
+// client needs a full snapshot instead.
+func (b *replayBuffer) since(seq uint64) ([]Message, bool) {
+	if b.count == 0 {
+		return nil, false
+	}
+
+	newest := b.messages[(b.start+b.count-1)%len(b.messages)]
+	if seq >= newest.Seq {
+		return nil, true
+	}
+
+	var missed []Message
+	expected := seq + 1
+	for i := 0; i < b.count; i++ {
+		message := b.messages[(b.start+i)%len(b.messages)]
+		if message.Seq <= seq {
+			continue
+		}
+		if message.Seq != expected {
+			return nil, false
+		}
+		missed = append(missed, message)
+		expected++
+	}
+	return missed, true
+}
+
+// reset drops every buffered message
+func (b *replayBuffer) reset() {
+	b.start, b.count = 0, 0
+	for i := range b.messages {
+		b.messages[i] = Message{}
+	}
+}
+
+// generateToken returns a random resume token
+func generateToken() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+// seqs lists the sequence numbers of messages
+func seqs(messages []Message) []uint64 {
+	list := make([]uint64, len(messages))
+	for i, message := range messages {
+		list[i] = message.Seq
+	}
+	return list
+}
+
+func TestReplayBufferSince(t *testing.T) {
+	b := newReplayBuffer(4)
+	if _, ok := b.since(0); ok {
+		t.Error("an empty buffer can replay")
+	}
+
+	// Six broadcasts through a buffer of four keep 3 to 6; unsequenced
+	// messages are not kept
+	for seq := uint64(1); seq <= 6; seq++ {
+		b.add(Message{Type: "pokemon_diff", Seq: seq})
+		b.add(Message{Type: "split_event"})
+	}
+
+	tests := []struct {
+		seq  uint64
+		want []uint64
+		ok   bool
+	}{
+		{seq: 6, want: []uint64{}, ok: true},
+		{seq: 9, want: []uint64{}, ok: true},
+		{seq: 4, want: []uint64{5, 6}, ok: true},
+		{seq: 2, want: []uint64{3, 4, 5, 6}, ok: true},
+		{seq: 1, ok: false}, // 2 is no longer buffered
+		{seq: 0, ok: false},
+	}
+	for _, test := range tests {
+		missed, ok := b.since(test.seq)
+		if ok != test.ok {
+			t.Errorf("since(%d) ok = %v, want %v", test.seq, ok, test.ok)
+			continue
+		}
+		got := seqs(missed)
+		if len(got) != len(test.want) {
+			t.Errorf("since(%d) = %v, want %v", test.seq, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("since(%d) = %v, want %v", test.seq, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestReplayBufferGapForcesSnapshot(t *testing.T) {
+	b := newReplayBuffer(8)
+	for _, seq := range []uint64{1, 2, 4, 5} {
+		b.add(Message{Type: "pokemon_diff", Seq: seq})
+	}
+
+	// Version 3 was never broadcast, so only a client past it can replay
+	if _, ok := b.since(2); ok {
+		t.Error("since(2) replayed across a gap")
+	}
+	if missed, ok := b.since(3); !ok || len(missed) != 2 {
+		t.Errorf("since(3) = %v, %v; want 4 and 5", seqs(missed), ok)
+	}
+
+	b.reset()
+	if _, ok := b.since(5); ok {
+		t.Error("a reset buffer can replay")
+	}
+}
+
+func TestTakeResumable(t *testing.T) {
+	m := &WebSocketManager{
+		config:    ManagerConfig{ResumeGrace: time.Minute},
+		resumable: make(map[string]*resumable),
+	}
+	m.resumable["live"] = &resumable{id: "a", expires: time.Now().Add(time.Minute)}
+	m.resumable["expired"] = &resumable{id: "b", expires: time.Now().Add(-time.Second)}
+
+	if saved, ok := m.takeResumable("live"); !ok || saved.id != "a" {
+		t.Errorf("takeResumable(live) = %+v, %v", saved, ok)
+	}
+	if _, ok := m.takeResumable("live"); ok {
+		t.Error("a resume token was used twice")
+	}
+	if _, ok := m.takeResumable("expired"); ok {
+		t.Error("an expired resume token was accepted")
+	}
+	if len(m.resumable) != 0 {
+		t.Errorf("%d saved clients left after taking them", len(m.resumable))
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"RetroGameAnalysis/state"
+)
+
+// ChangeSet is the payload of messages that carry field-level state changes.
+// Clients subscribed to property patterns receive only the matching changes.
+type ChangeSet struct {
+	Changes []state.Change `json:"changes"`
+}
+
+// PropertyName converts a change's JSON Pointer path into a dotted property
+// name, e.g. "/pokemon/0/current_hp" becomes "pokemon.0.current_hp"
+func PropertyName(pointer string) string {
+	if pointer == "" {
+		return ""
+	}
+
+	segments := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, segment := range segments {
+		segment = strings.ReplaceAll(segment, "~1", "/")
+		segments[i] = strings.ReplaceAll(segment, "~0", "~")
+	}
+	return strings.Join(segments, ".")
+}
+
+// ValidatePropertyPattern checks a dotted property glob such as "pokemon.*.current_hp".
+// Each segment is a path.Match pattern; "**" matches any number of segments.
+func ValidatePropertyPattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty property pattern")
+	}
+
+	for _, segment := range strings.Split(pattern, ".") {
+		if segment == "" {
+			return fmt.Errorf("invalid property pattern %q: empty segment", pattern)
+		}
+		if segment == "**" {
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid property pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// MatchProperty reports whether a dotted property name is covered by a pattern.
+// Names below a matching property match ("pokemon.0" covers "pokemon.0.level"),
+// and so do names above one, because a change to "pokemon.0" includes the
+// fields a pattern like "pokemon.*.current_hp" selects.
+func MatchProperty(pattern, name string) bool {
+	patternSegments := strings.Split(pattern, ".")
+	nameSegments := []string{}
+	if name != "" {
+		nameSegments = strings.Split(name, ".")
+	}
+
+	for i, segment := range patternSegments {
+		if segment == "**" {
+			return true
+		}
+		if i >= len(nameSegments) {
+			return true
+		}
+		if matched, _ := path.Match(segment, nameSegments[i]); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// subscription holds a client's topic filters. An empty subscription
+// receives every broadcast.
+type subscription struct {
+	mu         sync.RWMutex
+	types      map[string]bool
+	properties map[string]bool
+}
+
+func newSubscription() *subscription {
+	return &subscription{
+		types:      make(map[string]bool),
+		properties: make(map[string]bool),
+	}
+}
+
+// add subscribes to message types and property patterns
+func (s *subscription) add(types, properties []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, messageType := range types {
+		s.types[messageType] = true
+	}
+	for _, pattern := range properties {
+		s.properties[pattern] = true
+	}
+}
+
+// remove unsubscribes from message types and property patterns
+func (s *subscription) remove(types, properties []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, messageType := range types {
+		delete(s.types, messageType)
+	}
+	for _, pattern := range properties {
+		delete(s.properties, pattern)
+	}
+}
+
+// clear removes every filter
+func (s *subscription) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.types = make(map[string]bool)
+	s.properties = make(map[string]bool)
+}
+
+// list returns the subscribed types and patterns in sorted order
+func (s *subscription) list() ([]string, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return sortedKeys(s.types), sortedKeys(s.properties)
+}
+
+// route decides whether a broadcast reaches this client. It returns the
+// message to deliver and whether it was narrowed to the matching changes.
+func (s *subscription) route(message Message) (routed Message, deliver bool, narrowed bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.types) > 0 && !s.types[message.Type] {
+		return message, false, false
+	}
+
+	if len(s.properties) == 0 {
+		return message, true, false
+	}
+
+	switch data := message.Data.(type) {
+	case ChangeSet:
+		matching := make([]state.Change, 0, len(data.Changes))
+		for _, change := range data.Changes {
+			if s.matchLocked(PropertyName(change.Path)) {
+				matching = append(matching, change)
+			}
+		}
+		if len(matching) == 0 {
+			return message, false, false
+		}
+		if len(matching) < len(data.Changes) {
+			message.Data = ChangeSet{Changes: matching}
+			return message, true, true
+		}
+		return message, true, false
+
+	case map[string]interface{}:
+		if property, ok := data["property"].(string); ok {
+			return message, s.matchLocked(property), false
+		}
+	}
+
+	// Messages that are not about a property are filtered by type only
+	return message, true, false
+}
+
+func (s *subscription) matchLocked(name string) bool {
+	for pattern := range s.properties {
+		if MatchProperty(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"testing"
+
+	"RetroGameAnalysis/state"
+)
+
+func TestPropertyName(t *testing.T) {
+	tests := map[string]string{
+		"":                      "",
+		"/money":                "money",
+		"/pokemon/0/current_hp": "pokemon.0.current_hp",
+		"/a~1b/c~0d":            "a/b.c~d",
+	}
+	for pointer, want := range tests {
+		if got := PropertyName(pointer); got != want {
+			t.Errorf("PropertyName(%q) = %q, want %q", pointer, got, want)
+		}
+	}
+}
+
+func TestMatchProperty(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"money", "money", true},
+		{"money", "level", false},
+		{"pokemon.*.current_hp", "pokemon.0.current_hp", true},
+		{"pokemon.*.current_hp", "pokemon.0.level", false},
+		{"pokemon.[0-2].level", "pokemon.3.level", false},
+		{"pokemon.**", "pokemon.5.moves.0", true},
+		{"**", "anything.at.all", true},
+
+		// Names below a matching property
+		{"pokemon.0", "pokemon.0.level", true},
+		{"pokemon", "pokemon.0.moves.1", true},
+
+		// Names above one, whose change includes the selected fields
+		{"pokemon.*.current_hp", "pokemon.0", true},
+		{"pokemon.*.current_hp", "pokemon", true},
+		{"pokemon.*.current_hp", "", true},
+		{"pokemon.*.current_hp", "badges", false},
+	}
+	for _, test := range tests {
+		if got := MatchProperty(test.pattern, test.name); got != test.want {
+			t.Errorf("MatchProperty(%q, %q) = %v, want %v", test.pattern, test.name, got, test.want)
+		}
+	}
+}
+
+func TestValidatePropertyPattern(t *testing.T) {
+	for _, pattern := range []string{"money", "pokemon.*.current_hp", "pokemon.**", "pokemon.[0-5]"} {
+		if err := ValidatePropertyPattern(pattern); err != nil {
+			t.Errorf("ValidatePropertyPattern(%q): %v", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"", "pokemon..level", ".money", "pokemon.[0-"} {
+		if err := ValidatePropertyPattern(pattern); err == nil {
+			t.Errorf("ValidatePropertyPattern(%q) accepted an invalid pattern", pattern)
+		}
+	}
+}
+
+func changeSet(paths ...string) ChangeSet {
+	changes := make([]state.Change, len(paths))
+	for i, path := range paths {
+		changes[i] = state.Change{Op: state.OpReplace, Path: path, Value: 1}
+	}
+	return ChangeSet{Changes: changes}
+}
+
+func TestRoute(t *testing.T) {
+	diff := Message{Type: "pokemon_diff", Seq: 1, Data: changeSet("/money", "/pokemon/0/current_hp", "/pokemon/0/level")}
+	property := Message{Type: "memory_change", Data: map[string]interface{}{"property": "money", "value": 5}}
+	event := Message{Type: "split_event", Data: map[string]interface{}{"type": "split"}}
+
+	tests := []struct {
+		name       string
+		types      []string
+		properties []string
+		message    Message
+		deliver    bool
+		narrowed   bool
+		paths      []string
+	}{
+		{name: "no filters", message: diff, deliver: true, paths: []string{"/money", "/pokemon/0/current_hp", "/pokemon/0/level"}},
+		{name: "other type", types: []string{"split_event"}, message: diff},
+		{name: "subscribed type", types: []string{"pokemon_diff"}, message: diff, deliver: true, paths: []string{"/money", "/pokemon/0/current_hp", "/pokemon/0/level"}},
+		{name: "narrowed to matching changes", properties: []string{"pokemon.*.current_hp"}, message: diff, deliver: true, narrowed: true, paths: []string{"/pokemon/0/current_hp"}},
+		{name: "prefix covers every change", properties: []string{"money", "pokemon"}, message: diff, deliver: true, paths: []string{"/money", "/pokemon/0/current_hp", "/pokemon/0/level"}},
+		{name: "no matching change", properties: []string{"badges"}, message: diff},
+		{name: "matching property message", properties: []string{"money"}, message: property, deliver: true},
+		{name: "other property message", properties: []string{"badges"}, message: property},
+		{name: "message without a property", properties: []string{"badges"}, message: event, deliver: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subs := newSubscription()
+			subs.add(test.types, test.properties)
+
+			routed, deliver, narrowed := subs.route(test.message)
+			if deliver != test.deliver || narrowed != test.narrowed {
+				t.Fatalf("deliver %v narrowed %v, want %v and %v", deliver, narrowed, test.deliver, test.narrowed)
+			}
+			if test.paths == nil {
+				return
+			}
+			changes := routed.Data.(ChangeSet).Changes
+			if len(changes) != len(test.paths) {
+				t.Fatalf("routed %d changes, want %v", len(changes), test.paths)
+			}
+			for i, change := range changes {
+				if change.Path != test.paths[i] {
+					t.Errorf("change %d is %s, want %s", i, change.Path, test.paths[i])
+				}
+			}
+		})
+	}
+
+	// Narrowing copies the change set rather than editing the broadcast
+	if changes := diff.Data.(ChangeSet).Changes; len(changes) != 3 {
+		t.Errorf("the broadcast holds %d changes after routing, want 3", len(changes))
+	}
+}
+
+func TestSubscriptionRemove(t *testing.T) {
+	subs := newSubscription()
+	subs.add([]string{"pokemon_diff", "split_event"}, []string{"money", "pokemon.*"})
+	subs.remove([]string{"split_event"}, []string{"money"})
+
+	types, properties := subs.list()
+	if len(types) != 1 || types[0] != "pokemon_diff" || len(properties) != 1 || properties[0] != "pokemon.*" {
+		t.Errorf("subscription holds %v and %v", types, properties)
+	}
+
+	subs.clear()
+	if _, deliver, _ := subs.route(Message{Type: "split_event"}); !deliver {
+		t.Error("a cleared subscription filters broadcasts")
+	}
+}
@@ -1,10 +1,16 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -15,76 +21,281 @@ type WebSocketManager struct {
 	clients    map[*Client]bool
 	register   chan *Client
 	unregister chan *Client
-	broadcast  chan []byte
+	broadcast  chan Message
 	mu         sync.RWMutex
+	config     ManagerConfig
+
+	// snapshot produces the full-state message sent to newly connected clients
+	snapshot func() *Message
+
+	// commands holds the handlers for client request/response commands
+	commands map[string]command
+
+	// resumable holds disconnected clients by resume token; replay holds the
+	// recent sequenced broadcasts and is only used by the run loop
+	resumable map[string]*resumable
+	replay    *replayBuffer
+
+	// overflowed is set when a broadcast could not be queued; the run loop
+	// then sends every client a fresh snapshot
+	overflowed atomic.Bool
+
+	// done is closed to stop the manager; stopped is closed once run has returned.
+	// Both are nil while the manager is not running.
+	done    chan struct{}
+	stopped chan struct{}
+
+	// pumps tracks the read and write goroutines of every client
+	pumps sync.WaitGroup
 }
 
 // Client represents a WebSocket client connection
 type Client struct {
 	conn     *websocket.Conn
-	send     chan []byte
+	queue    *sendQueue
 	manager  *WebSocketManager
 	id       string
 	metadata map[string]interface{}
+	subs     *subscription
+	identity Identity
+
+	// encoding and batch are negotiated with query parameters at connect
+	encoding Encoding
+	batch    bool
+
+	// token lets the client resume this session after a disconnect
+	token string
+
+	// resumed is set when the client took over a disconnected session;
+	// lastSeq is the last sequence number it had applied, if it sent one
+	resumed    bool
+	lastSeq    uint64
+	hasLastSeq bool
+
+	// done is the manager's stop channel at the time the client connected
+	done <-chan struct{}
 }
 
 // Message represents a WebSocket message
 type Message struct {
 	Type      string                 `json:"type"`
+	ID        string                 `json:"id,omitempty"`
+	Seq       uint64                 `json:"seq,omitempty"`
 	Data      interface{}            `json:"data,omitempty"`
 	Timestamp time.Time              `json:"timestamp"`
 	ClientID  string                 `json:"client_id,omitempty"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
 }
 
+// ManagerConfig tunes how messages are delivered to clients
+type ManagerConfig struct {
+	// QueueSize is the number of broadcasts queued per client before the
+	// slow-consumer policy applies
+	QueueSize int
+
+	// SlowConsumerPolicy decides what happens to a client whose queue is full
+	SlowConsumerPolicy SlowConsumerPolicy
+
+	// Compression enables permessage-deflate for clients that offer it
+	Compression bool
+
+	// ResumeGrace is how long a disconnected client can resume its session
+	ResumeGrace time.Duration
+
+	// ReplaySize is the number of sequenced broadcasts kept for resumed clients
+	ReplaySize int
+
+	// Access authenticates clients and checks their origin. Nil allows
+	// same-origin clients without a key.
+	Access *AccessControl
+}
+
+const (
+	// compressionThreshold is the smallest frame worth compressing
+	compressionThreshold = 512
+
+	// maxBatchSize is the most messages one batch envelope holds
+	maxBatchSize = 64
+)
+
+// DefaultManagerConfig returns the default delivery settings
+func DefaultManagerConfig() ManagerConfig {
+	return ManagerConfig{
+		QueueSize:          256,
+		SlowConsumerPolicy: PolicyResync,
+		ResumeGrace:        30 * time.Second,
+		ReplaySize:         1024,
+	}
+}
+
 // NewWebSocketManager creates a new WebSocket manager
-func NewWebSocketManager() *WebSocketManager {
+func NewWebSocketManager(config ManagerConfig) *WebSocketManager {
+	defaults := DefaultManagerConfig()
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.SlowConsumerPolicy == "" {
+		config.SlowConsumerPolicy = defaults.SlowConsumerPolicy
+	}
+	if config.ReplaySize <= 0 {
+		config.ReplaySize = defaults.ReplaySize
+	}
+	if config.Access == nil {
+		config.Access, _ = NewAccessControl(nil, nil, "127.0.0.1:0")
+	}
+
 	return &WebSocketManager{
 		clients:    make(map[*Client]bool),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
-		broadcast:  make(chan []byte, 256),
+		broadcast:  make(chan Message, 256),
+		config:     config,
+		commands:   make(map[string]command),
+		resumable:  make(map[string]*resumable),
+		replay:     newReplayBuffer(config.ReplaySize),
 	}
 }
 
-// Start starts the WebSocket manager
+// Config returns the manager's delivery settings
+func (m *WebSocketManager) Config() ManagerConfig {
+	return m.config
+}
+
+// Start starts the WebSocket manager. A stopped manager may be started again.
 func (m *WebSocketManager) Start() {
-	go m.run()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.done != nil {
+		return
+	}
+
+	m.done = make(chan struct{})
+	m.stopped = make(chan struct{})
+	go m.run(m.done, m.stopped)
+}
+
+// Shutdown stops the manager, sends a close frame to every client and waits
+// for their connections to finish until ctx expires
+func (m *WebSocketManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	done, stopped := m.done, m.stopped
+	m.done, m.stopped = nil, nil
+	m.mu.Unlock()
+
+	if done == nil {
+		return nil
+	}
+
+	close(done)
+	<-stopped
+
+	// Pending messages are flushed before the close frame
+	m.mu.Lock()
+	for client := range m.clients {
+		client.queue.close(websocket.CloseGoingAway, "server shutting down", false)
+		delete(m.clients, client)
+	}
+	m.resumable = make(map[string]*resumable)
+	m.replay.reset()
+	m.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		m.pumps.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		log.Println("WebSocket manager stopped")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetSnapshotProvider registers a function that builds the full-state message
+// sent to each client when it connects. Connected clients receive diffs only.
+func (m *WebSocketManager) SetSnapshotProvider(provider func() *Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshot = provider
 }
 
-// run handles the main WebSocket manager loop
-func (m *WebSocketManager) run() {
+// run handles the main WebSocket manager loop until done is closed
+func (m *WebSocketManager) run(done, stopped chan struct{}) {
+	defer close(stopped)
+
+	sweep := time.NewTicker(10 * time.Second)
+	defer sweep.Stop()
+
 	for {
 		select {
+		case <-done:
+			return
+
+		case <-sweep.C:
+			m.expireResumable()
+
 		case client := <-m.register:
 			m.mu.Lock()
 			m.clients[client] = true
 			m.mu.Unlock()
 
-			log.Printf("WebSocket client connected: %s", client.id)
+			log.Printf("WebSocket client connected: %s (resumed: %v)", client.id, client.resumed)
+
+			// A resumed client that knows its last sequence number catches up
+			// from the replay buffer instead of a full snapshot
+			var missed []Message
+			replayed := false
+			if client.resumed && client.hasLastSeq {
+				missed, replayed = m.replay.since(client.lastSeq)
+			}
 
 			// Send welcome message
 			welcome := Message{
-				Type:      "connected",
-				Data:      map[string]interface{}{"message": "WebSocket connection established"},
+				Type: "connected",
+				Data: map[string]interface{}{
+					"message":      "WebSocket connection established",
+					"client_id":    client.id,
+					"resume_token": client.token,
+					"resumed":      client.resumed,
+					"replayed":     len(missed),
+					"encoding":     client.encoding,
+					"batch":        client.batch,
+				},
 				Timestamp: time.Now(),
 			}
 			client.SendMessage(welcome)
 
+			if replayed {
+				m.replayTo(client, missed)
+			} else {
+				// Send the current full state so the client has a base to apply diffs to
+				m.mu.RLock()
+				provider := m.snapshot
+				m.mu.RUnlock()
+				if provider != nil {
+					if snapshot := provider(); snapshot != nil {
+						client.SendMessage(*snapshot)
+					}
+				}
+			}
+
 			// Notify other clients
 			m.BroadcastMessage(Message{
 				Type:      "client_connected",
-				Data:      map[string]interface{}{"client_id": client.id},
+				Data:      map[string]interface{}{"client_id": client.id, "resumed": client.resumed},
 				Timestamp: time.Now(),
 			})
 
 		case client := <-m.unregister:
 			m.mu.Lock()
-			if _, ok := m.clients[client]; ok {
-				delete(m.clients, client)
-				close(client.send)
-			}
+			delete(m.clients, client)
 			m.mu.Unlock()
+			client.queue.close(0, "", true)
+			m.saveResumable(client)
 
 			log.Printf("WebSocket client disconnected: %s", client.id)
 
@@ -96,34 +307,118 @@ func (m *WebSocketManager) run() {
 			})
 
 		case message := <-m.broadcast:
-			m.mu.RLock()
-			for client := range m.clients {
-				select {
-				case client.send <- message:
-				default:
-					// Client's send channel is full, close connection
-					close(client.send)
-					delete(m.clients, client)
-				}
+			m.deliver(message)
+			if m.overflowed.Swap(false) {
+				m.resync()
 			}
-			m.mu.RUnlock()
 		}
 	}
 }
 
-// BroadcastMessage sends a message to all connected clients
-func (m *WebSocketManager) BroadcastMessage(message Message) {
-	data, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("Error marshaling broadcast message: %v", err)
+// deliver routes a broadcast to every client whose subscription matches it
+func (m *WebSocketManager) deliver(message Message) {
+	m.replay.add(message)
+
+	// Clients that receive the message unchanged share one encoding
+	var shared *outbound
+	var slow []*Client
+
+	m.mu.RLock()
+	for client := range m.clients {
+		routed, ok, narrowed := client.subs.route(message)
+		if !ok {
+			continue
+		}
+
+		item := shared
+		if narrowed {
+			item = newOutbound(routed, false)
+		} else if shared == nil {
+			shared = newOutbound(message, false)
+			item = shared
+		}
+
+		if err := client.queue.push(item, m.config.SlowConsumerPolicy, m.snapshot); err == errSlowConsumer {
+			slow = append(slow, client)
+		}
+	}
+	m.mu.RUnlock()
+
+	// The client map is only modified under the write lock
+	for _, client := range slow {
+		m.evict(client)
+	}
+}
+
+// resync sends every client a full-state snapshot after broadcasts were
+// dropped. The replay buffer has a gap too, so it is cleared.
+func (m *WebSocketManager) resync() {
+	m.replay.reset()
+
+	m.mu.RLock()
+	provider := m.snapshot
+	clients := make([]*Client, 0, len(m.clients))
+	for client := range m.clients {
+		clients = append(clients, client)
+	}
+	m.mu.RUnlock()
+
+	if provider == nil {
+		return
+	}
+	snapshot := provider()
+	if snapshot == nil {
 		return
 	}
+	if snapshot.Metadata == nil {
+		snapshot.Metadata = make(map[string]interface{})
+	}
+	snapshot.Metadata["resync"] = true
+
+	log.Printf("Resyncing %d WebSocket clients after dropped broadcasts", len(clients))
+	for _, client := range clients {
+		client.SendMessage(*snapshot)
+	}
+}
 
+// replayTo queues missed broadcasts for a resumed client, filtered by its
+// restored subscription
+func (m *WebSocketManager) replayTo(client *Client, missed []Message) {
+	for _, message := range missed {
+		routed, ok, _ := client.subs.route(message)
+		if !ok {
+			continue
+		}
+		if err := client.queue.push(newOutbound(routed, false), m.config.SlowConsumerPolicy, m.snapshot); err == errSlowConsumer {
+			m.evict(client)
+			return
+		}
+	}
+}
+
+// evict disconnects a client that cannot keep up, discarding its pending messages
+func (m *WebSocketManager) evict(client *Client) {
+	m.mu.Lock()
+	_, ok := m.clients[client]
+	delete(m.clients, client)
+	m.mu.Unlock()
+
+	if ok {
+		log.Printf("Disconnecting slow WebSocket client %s", client.id)
+		client.queue.close(websocket.ClosePolicyViolation, errSlowConsumer.Error(), true)
+	}
+}
+
+// BroadcastMessage sends a message to every client subscribed to it
+func (m *WebSocketManager) BroadcastMessage(message Message) {
 	select {
-	case m.broadcast <- data:
+	case m.broadcast <- message:
 	default:
-		// Broadcast channel is full, skip this message
-		log.Printf("Broadcast channel full, skipping message")
+		// Broadcast channel is full. Clients get a snapshot once it drains,
+		// so their state catches up even though this message is lost.
+		if !m.overflowed.Swap(true) {
+			log.Printf("Broadcast channel full, dropping messages until clients resync")
+		}
 	}
 }
 
@@ -185,14 +480,75 @@ func (m *WebSocketManager) GetClientIDs() []string {
 	return ids
 }
 
-// HandleWebSocket upgrades HTTP connection to WebSocket
+// ClientStats returns the connection and queue metrics of every client
+func (m *WebSocketManager) ClientStats() []ClientStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := make([]ClientStats, 0, len(m.clients))
+	for client := range m.clients {
+		stats = append(stats, client.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].ConnectedAt.Before(stats[j].ConnectedAt)
+	})
+	return stats
+}
+
+// HandleWebSocket upgrades HTTP connection to WebSocket. Clients choose
+// their wire format with ?encoding=json|msgpack and may opt in to batch
+// envelopes with ?batch=true. A client reconnecting within the grace window
+// passes ?resume=<token>&last_seq=<seq> to restore its session.
 func (m *WebSocketManager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	m.mu.RLock()
+	done := m.done
+	m.mu.RUnlock()
+
+	if done == nil {
+		http.Error(w, "WebSocket manager is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	access := m.config.Access
+	if !access.AllowOrigin(r) {
+		http.Error(w, fmt.Sprintf("origin %q is not allowed", r.Header.Get("Origin")), http.StatusForbidden)
+		return
+	}
+	identity, err := access.Authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	encoding, err := ParseEncoding(query.Get("encoding"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	batch := false
+	if value := query.Get("batch"); value != "" {
+		if batch, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, fmt.Sprintf("invalid batch parameter %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var lastSeq uint64
+	hasLastSeq := false
+	if value := query.Get("last_seq"); value != "" {
+		if lastSeq, err = strconv.ParseUint(value, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid last_seq parameter %q", value), http.StatusBadRequest)
+			return
+		}
+		hasLastSeq = true
+	}
+
 	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true // Allow all origins for development
-		},
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
+		CheckOrigin:       access.AllowOrigin,
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		EnableCompression: m.config.Compression,
 	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -204,10 +560,27 @@ func (m *WebSocketManager) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 	// Create client
 	client := &Client{
 		conn:     conn,
-		send:     make(chan []byte, 256),
+		queue:    newSendQueue(m.config.QueueSize),
 		manager:  m,
 		id:       generateClientID(),
 		metadata: make(map[string]interface{}),
+		subs:     newSubscription(),
+		identity: identity,
+		encoding: encoding,
+		batch:    batch,
+		token:    generateToken(),
+		done:     done,
+	}
+
+	// An unknown or expired token starts a fresh session
+	if token := query.Get("resume"); token != "" {
+		if saved, ok := m.takeResumable(token); ok && saved.identity == identity.Name {
+			client.id = saved.id
+			client.subs = saved.subs
+			client.resumed = true
+			client.lastSeq = lastSeq
+			client.hasLastSeq = hasLastSeq
+		}
 	}
 
 	// Extract client metadata from headers
@@ -221,33 +594,64 @@ func (m *WebSocketManager) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 	client.metadata["connected_at"] = time.Now()
 
 	// Register client
-	m.register <- client
+	select {
+	case m.register <- client:
+	case <-done:
+		conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+		conn.Close()
+		return
+	}
 
 	// Start client goroutines
+	m.pumps.Add(2)
 	go client.writePump()
 	go client.readPump()
 }
 
-// SendMessage sends a message to this specific client
+// SendMessage sends a message to this specific client. Direct messages are
+// never dropped by the slow-consumer policy.
 func (c *Client) SendMessage(message Message) error {
-	data, err := json.Marshal(message)
-	if err != nil {
-		return err
+	err := c.queue.push(newOutbound(message, true), c.manager.config.SlowConsumerPolicy, nil)
+	if err == errSlowConsumer {
+		c.manager.evict(c)
 	}
+	return err
+}
 
-	select {
-	case c.send <- data:
-		return nil
-	default:
-		return websocket.ErrCloseSent
+// Identity returns the authenticated caller behind this client
+func (c *Client) Identity() Identity {
+	return c.identity
+}
+
+// Stats returns the client's connection and queue metrics
+func (c *Client) Stats() ClientStats {
+	types, properties := c.subs.list()
+	remoteAddr, _ := c.metadata["remote_addr"].(string)
+	connectedAt, _ := c.metadata["connected_at"].(time.Time)
+
+	return ClientStats{
+		ID:          c.id,
+		RemoteAddr:  remoteAddr,
+		Identity:    c.identity,
+		Encoding:    c.encoding,
+		Batch:       c.batch,
+		ConnectedAt: connectedAt,
+		Types:       types,
+		Properties:  properties,
+		QueueStats:  c.queue.stats(),
 	}
 }
 
 // readPump handles reading messages from the WebSocket connection
 func (c *Client) readPump() {
 	defer func() {
-		c.manager.unregister <- c
+		select {
+		case c.manager.unregister <- c:
+		case <-c.done:
+		}
 		c.conn.Close()
+		c.manager.pumps.Done()
 	}()
 
 	// Set read deadline and pong handler
@@ -258,8 +662,7 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		var rawMessage json.RawMessage
-		err := c.conn.ReadJSON(&rawMessage)
+		frameType, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
@@ -267,6 +670,12 @@ func (c *Client) readPump() {
 			break
 		}
 
+		rawMessage, err := decodeIncoming(frameType, data)
+		if err != nil {
+			log.Printf("Error decoding message from client %s: %v", c.id, err)
+			continue
+		}
+
 		// Handle client message
 		c.handleMessage(rawMessage)
 	}
@@ -278,31 +687,26 @@ func (c *Client) writePump() {
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
+		c.manager.pumps.Done()
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// Add queued messages to the current message
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
+		case <-c.queue.notify:
+			items, closed, code, reason := c.queue.drain()
+			if len(items) > 0 {
+				if err := c.writeMessages(items); err != nil {
+					return
+				}
 			}
 
-			if err := w.Close(); err != nil {
+			if closed {
+				c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				closeMessage := []byte{}
+				if code != 0 {
+					closeMessage = websocket.FormatCloseMessage(code, reason)
+				}
+				c.conn.WriteMessage(websocket.CloseMessage, closeMessage)
 				return
 			}
 
@@ -315,11 +719,66 @@ func (c *Client) writePump() {
 	}
 }
 
+// writeMessages writes queued messages one frame each, or in batch
+// envelopes for clients that opted in
+func (c *Client) writeMessages(items []*outbound) error {
+	encoded := make([][]byte, 0, len(items))
+	for _, item := range items {
+		data, err := item.encode(c.encoding)
+		if err != nil {
+			log.Printf("Error encoding message for client %s: %v", c.id, err)
+			continue
+		}
+		encoded = append(encoded, data)
+	}
+
+	if !c.batch || len(encoded) < 2 {
+		for _, data := range encoded {
+			if err := c.writeFrame(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for start := 0; start < len(encoded); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+
+		data := encoded[start]
+		if end-start > 1 {
+			var err error
+			if data, err = batchEnvelope(c.encoding, encoded[start:end]); err != nil {
+				return err
+			}
+		}
+		if err := c.writeFrame(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFrame writes one encoded message, compressing it if it is large enough
+func (c *Client) writeFrame(data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	c.conn.EnableWriteCompression(c.manager.config.Compression && len(data) >= compressionThreshold)
+	return c.conn.WriteMessage(c.encoding.frameType(), data)
+}
+
 // handleMessage processes incoming messages from the client
 func (c *Client) handleMessage(rawMessage json.RawMessage) {
+	var request incomingMessage
+	if err := json.Unmarshal(rawMessage, &request); err != nil {
+		log.Printf("Error unmarshaling client message: %v", err)
+		return
+	}
+
 	var message Message
 	if err := json.Unmarshal(rawMessage, &message); err != nil {
-		log.Printf("Error unmarshaling client message: %v", err)
+		c.sendCommandError(request, NewCommandError(ErrorInvalidRequest, "malformed message: %v", err))
 		return
 	}
 
@@ -333,17 +792,22 @@ func (c *Client) handleMessage(rawMessage json.RawMessage) {
 		// Respond with pong
 		c.SendMessage(Message{
 			Type:      "pong",
+			ID:        message.ID,
 			Timestamp: time.Now(),
 		})
 
 	case "subscribe":
-		// Handle subscription to specific events
-		c.handleSubscription(message)
+		// Handle subscription to message types and properties
+		c.handleSubscription(message, true)
+
+	case "unsubscribe":
+		c.handleSubscription(message, false)
 
 	case "get_status":
 		// Send current status
 		c.SendMessage(Message{
 			Type: "status",
+			ID:   message.ID,
 			Data: map[string]interface{}{
 				"client_count": c.manager.GetClientCount(),
 				"client_id":    c.id,
@@ -353,31 +817,111 @@ func (c *Client) handleMessage(rawMessage json.RawMessage) {
 		})
 
 	default:
+		if command, ok := c.manager.command(message.Type); ok {
+			if err := Authorize(c.identity, command.role); err != nil {
+				c.sendCommandError(request, err)
+				return
+			}
+			c.handleCommand(request, command.handler)
+			return
+		}
+
+		if request.ID != "" {
+			c.sendCommandError(request, NewCommandError(ErrorUnknownCommand, "unknown command %q", message.Type))
+			return
+		}
 		log.Printf("Unknown message type from client %s: %s", c.id, message.Type)
 	}
 }
 
-// handleSubscription processes subscription requests
-func (c *Client) handleSubscription(message Message) {
-	if data, ok := message.Data.(map[string]interface{}); ok {
-		if events, ok := data["events"].([]interface{}); ok {
-			c.metadata["subscribed_events"] = events
-			log.Printf("Client %s subscribed to events: %v", c.id, events)
+// handleSubscription processes subscribe and unsubscribe requests. Data may
+// list message "types" (or "events") and dotted property "properties" globs
+// such as "pokemon.*.current_hp"; unsubscribe with "all": true clears every
+// filter. Each request is acknowledged with the resulting subscription.
+func (c *Client) handleSubscription(message Message, subscribe bool) {
+	data, _ := message.Data.(map[string]interface{})
+
+	types := stringList(data["types"])
+	types = append(types, stringList(data["events"])...)
+	properties := stringList(data["properties"])
+
+	for _, pattern := range properties {
+		if err := ValidatePropertyPattern(pattern); err != nil {
+			c.SendMessage(Message{
+				Type: "error",
+				ID:   message.ID,
+				Data: map[string]interface{}{
+					"error_type": "invalid_subscription",
+					"message":    err.Error(),
+				},
+				Timestamp: time.Now(),
+			})
+			return
 		}
 	}
+
+	ackType := "subscribed"
+	if subscribe {
+		c.subs.add(types, properties)
+	} else {
+		ackType = "unsubscribed"
+		if all, _ := data["all"].(bool); all {
+			c.subs.clear()
+		} else {
+			c.subs.remove(types, properties)
+		}
+	}
+
+	subscribedTypes, subscribedProperties := c.subs.list()
+	log.Printf("Client %s %s: types=%v properties=%v", c.id, ackType, subscribedTypes, subscribedProperties)
+
+	c.SendMessage(Message{
+		Type: ackType,
+		ID:   message.ID,
+		Data: map[string]interface{}{
+			"types":      subscribedTypes,
+			"properties": subscribedProperties,
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+// stringList converts a decoded JSON array into its string elements
+func stringList(value interface{}) []string {
+	items, _ := value.([]interface{})
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		if text, ok := item.(string); ok && text != "" {
+			result = append(result, text)
+		}
+	}
+	return result
 }
 
 // generateClientID generates a unique client ID
 func generateClientID() string {
-	return time.Now().Format("20060102150405") + "-" + randomString(6)
+	return time.Now().Format("20060102150405") + "-" + randomString(12)
 }
 
 // randomString generates a random string of given length
 func randomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[time.Now().UnixNano()%int64(len(charset))]
+
+	// Bytes at or above the largest multiple of the charset size are
+	// rejected so every character is equally likely
+	const limit = 256 - 256%len(charset)
+
+	b := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(b) < length {
+		if _, err := rand.Read(buf); err != nil {
+			panic("crypto/rand failed: " + err.Error())
+		}
+		for _, r := range buf {
+			if int(r) < limit && len(b) < length {
+				b = append(b, charset[int(r)%len(charset)])
+			}
+		}
 	}
 	return string(b)
 }
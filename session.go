@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"RetroGameAnalysis/connection"
+	"RetroGameAnalysis/history"
+	"RetroGameAnalysis/recording"
+	"RetroGameAnalysis/script"
+	"RetroGameAnalysis/server"
+	"RetroGameAnalysis/splits"
+	"RetroGameAnalysis/state"
+	"github.com/gorilla/mux"
+)
+
+// DefaultSessionID names the session configured by the top-level settings
+const DefaultSessionID = "default"
+
+// DefaultMapper is the built-in Pokemon Red/Blue memory map
+const DefaultMapper = "pokemon-gen1"
+
+// ErrorConflict reports a session that clashes with an existing one
+const ErrorConflict = "conflict"
+
+var sessionIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Session is one monitored emulator with its own driver, game state, poll
+// loop, freezes and WebSocket clients
+type Session struct {
+	id        string
+	config    SessionConfig
+	createdAt time.Time
+
+	wsManager *server.WebSocketManager
+	driver    connection.Driver
+	gameState *state.Store[GameData]
+	poller    *pollScheduler
+	freezes   *freezeTable
+
+	// history keeps a time series of every numeric game state value
+	history *history.Store
+
+	// searches holds the RAM searches started on this session
+	searches *searchTable
+
+	// memoryWindows holds the memory ranges streamed to WebSocket clients
+	memoryWindows *memoryWindowTable
+
+	// watchpoints holds the watched ranges and their hit logs
+	watchpoints *watchpointTable
+
+	// snapshots holds the named memory snapshots saved on this session
+	snapshots *snapshotTable
+
+	// eventFlags keeps the last read of the event flags to stream flips
+	eventFlags *eventFlagTracker
+
+	// scripts runs the Starlark scripts of the session's scripts directory
+	scripts *script.Runtime
+
+	// splitter times runs against the session's split definition, and
+	// liveSplit mirrors it to LiveSplit when configured
+	splitter    *splits.Timer
+	splitSource *splitSource
+	liveSplit   *splits.LiveSplit
+
+	// capture wraps the driver so its reads can be captured; replay is set
+	// when the session plays back a capture instead of talking to RetroArch
+	capture *connection.CaptureDriver
+	replay  *connection.ReplayDriver
+
+	// recorder is set while the session records its timeline; recordMu
+	// serializes starting and stopping
+	recordings RecordingSettings
+	recordMu   sync.Mutex
+	recorder   atomic.Pointer[recording.Recorder]
+
+	// stopMonitor and monitorDone are set while the session is running
+	mu          sync.Mutex
+	stopMonitor context.CancelFunc
+	monitorDone chan struct{}
+}
+
+// SessionInfo describes a session in the /api/sessions responses
+type SessionInfo struct {
+	SessionConfig
+	Running          bool      `json:"running"`
+	Seq              uint64    `json:"seq"`
+	GameLoaded       bool      `json:"game_loaded"`
+	LastUpdated      time.Time `json:"last_updated"`
+	WebSocketClients int       `json:"websocket_clients"`
+	Recording        bool      `json:"recording"`
+	Capturing        bool      `json:"capturing"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// NewSession creates a stopped session from a validated config
+func NewSession(config SessionConfig, managerConfig server.ManagerConfig, recordings RecordingSettings, historyOptions history.Options) (*Session, error) {
+	priorities, err := config.PollPriorities()
+	if err != nil {
+		return nil, err
+	}
+
+	definition, err := loadSplitDefinition(config.Splits)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := history.NewStore(historyOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var driver connection.Driver
+	var replay *connection.ReplayDriver
+	if config.Replay != "" {
+		replay = connection.NewReplayDriver(config.Replay)
+		if err := replay.SetSpeed(config.ReplaySpeed); err != nil {
+			return nil, err
+		}
+		replay.SetLoop(config.ReplayLoop)
+		driver = replay
+	} else {
+		retroarch := connection.NewAdaptiveRetroArchDriver(config.RetroArchHost, config.RetroArchPort, time.Duration(config.RequestTimeout))
+		retroarch.SetPlatform(config.Platform)
+		driver = retroarch
+	}
+	capture := connection.NewCaptureDriver(driver)
+
+	s := &Session{
+		id:            config.ID,
+		config:        config,
+		createdAt:     time.Now(),
+		wsManager:     server.NewWebSocketManager(managerConfig),
+		driver:        capture,
+		gameState:     state.NewStore(&GameData{}),
+		poller:        newPollScheduler(time.Duration(config.UpdateInterval), priorities),
+		freezes:       newFreezeTable(),
+		recordings:    recordings,
+		capture:       capture,
+		replay:        replay,
+		history:       values,
+		searches:      newSearchTable(),
+		memoryWindows: newMemoryWindowTable(),
+		watchpoints:   newWatchpointTable(),
+		snapshots:     newSnapshotTable(),
+		eventFlags:    &eventFlagTracker{},
+	}
+	s.scripts = s.newScriptRuntime()
+	s.newSplitter(definition)
+	s.registerCommands()
+	s.registerRecordingCommands()
+	s.registerReplayCommands()
+	s.registerSearchCommands()
+	s.registerMemoryCommands()
+	s.registerWatchpointCommands()
+	s.registerScriptCommands()
+	s.registerSplitCommands()
+	s.registerProgressCommands()
+
+	return s, nil
+}
+
+// Start connects to RetroArch or loads the replay, starts the WebSocket manager and begins polling
+func (s *Session) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopMonitor != nil {
+		return nil
+	}
+
+	if err := s.driver.Connect(); err != nil {
+		return server.NewCommandError(ErrorDriver, "session %s: failed to connect to %s: %v", s.id, s.config.Source(), err)
+	}
+	log.Printf("✅ [%s] Connected to %s", s.id, s.config.Source())
+
+	s.wsManager.SetSnapshotProvider(s.snapshotMessage)
+	s.wsManager.Start()
+
+	log.Printf("⏱️  [%s] Poll priorities: %s", s.id, formatPriorities(s.poller.priorities))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var workers sync.WaitGroup
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			s.scripts.Run(ctx)
+		}()
+		if s.liveSplit != nil {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				s.liveSplit.Run(ctx)
+			}()
+		}
+		s.monitorPokemonData(ctx)
+		workers.Wait()
+	}()
+
+	s.stopMonitor = cancel
+	s.monitorDone = done
+	return nil
+}
+
+// Stop ends polling, finishes any recording or capture, releases every
+// freeze and closes WebSocket clients. The driver stays open so in-flight
+// requests can finish; Close releases it.
+func (s *Session) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	stopMonitor, done := s.stopMonitor, s.monitorDone
+	s.stopMonitor, s.monitorDone = nil, nil
+	s.mu.Unlock()
+
+	if stopMonitor == nil {
+		return nil
+	}
+
+	// Stop polling before anything else so no new state is published,
+	// then release freezes so nothing is held once the session is gone
+	stopMonitor()
+	<-done
+	if s.recorder.Load() != nil {
+		s.stopRecording()
+	}
+	if _, capturing := s.capture.CaptureStats(); capturing {
+		s.stopCapture()
+	}
+	s.releaseFreezes()
+
+	return s.wsManager.Shutdown(ctx)
+}
+
+// Close closes the RetroArch connection and the on-disk history
+func (s *Session) Close() error {
+	if err := s.history.Close(); err != nil {
+		log.Printf("⚠️  [%s] Failed to close property history: %v", s.id, err)
+	}
+	return s.driver.Close()
+}
+
+// Running reports whether the session is polling
+func (s *Session) Running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopMonitor != nil
+}
+
+// Info returns the session's configuration and current status
+func (s *Session) Info() SessionInfo {
+	snapshot := s.gameState.Load()
+	_, capturing := s.capture.CaptureStats()
+	return SessionInfo{
+		SessionConfig:    s.config,
+		Running:          s.Running(),
+		Seq:              snapshot.Seq,
+		GameLoaded:       snapshot.Data.PlayerName != "",
+		LastUpdated:      snapshot.Data.LastUpdated,
+		WebSocketClients: s.wsManager.GetClientCount(),
+		Recording:        s.recorder.Load() != nil,
+		Capturing:        capturing,
+		CreatedAt:        s.createdAt,
+	}
+}
+
+// session returns the session with the given ID
+func (s *PokemonWebServer) session(id string) (*Session, error) {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, server.NewCommandError(server.ErrorNotFound, "unknown session %q", id)
+	}
+	return session, nil
+}
+
+// sortedSessions returns every session ordered by ID, default first
+func (s *PokemonWebServer) sortedSessions() []*Session {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].id == DefaultSessionID || sessions[j].id == DefaultSessionID {
+			return sessions[i].id == DefaultSessionID
+		}
+		return sessions[i].id < sessions[j].id
+	})
+	return sessions
+}
+
+// addSession creates a session and, while the server is serving, starts it
+func (s *PokemonWebServer) addSession(config SessionConfig) (*Session, error) {
+	config = s.config.WithSessionDefaults(config)
+	if err := config.Validate(); err != nil {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "%v", err)
+	}
+
+	s.sessionsMu.RLock()
+	err := s.checkSessionConflictLocked(config)
+	serving := s.serving
+	s.sessionsMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := NewSession(config, s.managerConfig, s.recordings, s.config.HistoryOptions(config.ID))
+	if err != nil {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "%v", err)
+	}
+
+	// Connecting can take up to the request timeout, so it happens without
+	// holding the lock; conflicts are checked again before the session is added
+	if serving {
+		if err := session.Start(); err != nil {
+			session.Close()
+			return nil, err
+		}
+	}
+
+	s.sessionsMu.Lock()
+	err = s.checkSessionConflictLocked(config)
+	if err == nil && serving != s.serving {
+		err = server.NewCommandError(ErrorConflict, "the server is starting or shutting down, try again")
+	}
+	if err == nil {
+		s.sessions[config.ID] = session
+	}
+	s.sessionsMu.Unlock()
+
+	if err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		session.Stop(ctx)
+		session.Close()
+		return nil, err
+	}
+
+	log.Printf("➕ Session %s added (%s)", config.ID, config.Source())
+	return session, nil
+}
+
+// checkSessionConflictLocked reports an existing session with the same ID or
+// RetroArch endpoint; sessionsMu must be held
+func (s *PokemonWebServer) checkSessionConflictLocked(config SessionConfig) error {
+	if _, exists := s.sessions[config.ID]; exists {
+		return server.NewCommandError(ErrorConflict, "session %q already exists", config.ID)
+	}
+	if config.Replay != "" {
+		return nil
+	}
+	for _, other := range s.sessions {
+		if other.config.Endpoint() == config.Endpoint() {
+			return server.NewCommandError(ErrorConflict, "RetroArch at %s is already used by session %q", config.Endpoint(), other.id)
+		}
+	}
+	return nil
+}
+
+// removeSession stops and removes a session. The default session backs the
+// unscoped routes and cannot be removed.
+func (s *PokemonWebServer) removeSession(ctx context.Context, id string) error {
+	if id == DefaultSessionID {
+		return server.NewCommandError(server.ErrorInvalidRequest, "the default session cannot be removed")
+	}
+
+	s.sessionsMu.Lock()
+	session, ok := s.sessions[id]
+	delete(s.sessions, id)
+	s.sessionsMu.Unlock()
+
+	if !ok {
+		return server.NewCommandError(server.ErrorNotFound, "unknown session %q", id)
+	}
+
+	if err := session.Stop(ctx); err != nil {
+		log.Printf("⚠️  [%s] WebSocket clients did not close cleanly: %v", id, err)
+	}
+	if err := session.Close(); err != nil {
+		log.Printf("⚠️  [%s] Failed to close RetroArch connection: %v", id, err)
+	}
+
+	log.Printf("➖ Session %s removed", id)
+	return nil
+}
+
+// withSession wraps a session-scoped handler. The session comes from the
+// {session} route variable; unscoped routes use the default session.
+func (s *PokemonWebServer) withSession(role server.Role, handler func(*Session, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return s.access.Require(role, func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["session"]
+		if id == "" {
+			id = DefaultSessionID
+		}
+
+		session, err := s.session(id)
+		if err != nil {
+			writeCommandError(w, err)
+			return
+		}
+		handler(session, w, r)
+	})
+}
+
+func (s *PokemonWebServer) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := s.sortedSessions()
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, session.Info())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"sessions": infos})
+}
+
+func (s *PokemonWebServer) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	var config SessionConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "invalid request body: %v", err))
+		return
+	}
+
+	session, err := s.addSession(config)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/api/sessions/%s", session.id))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(session.Info())
+}
+
+func (s *PokemonWebServer) handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), shutdownTimeout)
+	defer cancel()
+
+	if err := s.removeSession(ctx, mux.Vars(r)["session"]); err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Session) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Info())
+}
+
+func (s *Session) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	s.wsManager.HandleWebSocket(w, r)
+}
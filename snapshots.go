@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"RetroGameAnalysis/connection"
+	"RetroGameAnalysis/server"
+	"github.com/gorilla/mux"
+)
+
+// maxSnapshots bounds the number of memory snapshots a session keeps
+const maxSnapshots = 64
+
+// SnapshotRequest saves the session's memory blocks under a name
+type SnapshotRequest struct {
+	Name string `json:"name"`
+}
+
+// SnapshotBlock describes one memory block of a snapshot
+type SnapshotBlock struct {
+	Name  string `json:"name"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Size  int    `json:"size"`
+}
+
+// MemorySnapshot describes a saved snapshot
+type MemorySnapshot struct {
+	Name    string          `json:"name"`
+	Created time.Time       `json:"created"`
+	Blocks  []SnapshotBlock `json:"blocks"`
+	Bytes   int             `json:"bytes"`
+}
+
+// SnapshotDiff lists what changed between two snapshots, grouped by the named
+// address each changed byte belongs to
+type SnapshotDiff struct {
+	From         string      `json:"from"`
+	To           string      `json:"to"`
+	ChangedBytes int         `json:"changed_bytes"`
+	Groups       []DiffGroup `json:"groups"`
+}
+
+// DiffGroup is the changed bytes of one named address, or the unnamed changes
+// of a memory block when Known is false
+type DiffGroup struct {
+	Label       string           `json:"label"`
+	Known       bool             `json:"known"`
+	Description string           `json:"description,omitempty"`
+	Block       string           `json:"block"`
+	Ranges      []DiffRange      `json:"ranges"`
+	Properties  []PropertyChange `json:"properties,omitempty"`
+
+	// runs are the changed bytes, encoded into Ranges once the group is complete
+	runs []diffRun
+}
+
+// diffRun is a run of changed bytes, sliced from the two snapshots' data
+type diffRun struct {
+	start    uint32
+	old, new []byte
+}
+
+// DiffRange is a run of changed bytes
+type DiffRange struct {
+	Address string `json:"address"`
+	Length  int    `json:"length"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+}
+
+// memorySnapshot holds the contents of each memory block, keyed by start address
+type memorySnapshot struct {
+	info   MemorySnapshot
+	blocks []connection.MemoryBlock
+	data   map[uint32][]byte
+}
+
+// bytes returns length bytes at address if one block holds all of them
+func (m *memorySnapshot) bytes(address, length uint32) []byte {
+	for _, block := range m.blocks {
+		if address >= block.Start && uint64(address)+uint64(length)-1 <= uint64(block.End) {
+			offset := address - block.Start
+			return m.data[block.Start][offset : offset+length]
+		}
+	}
+	return nil
+}
+
+// snapshotTable holds a session's memory snapshots by name
+type snapshotTable struct {
+	mu        sync.Mutex
+	snapshots map[string]*memorySnapshot
+}
+
+func newSnapshotTable() *snapshotTable {
+	return &snapshotTable{snapshots: make(map[string]*memorySnapshot)}
+}
+
+func (t *snapshotTable) get(name string) (*memorySnapshot, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snapshot, ok := t.snapshots[name]
+	if !ok {
+		return nil, server.NewCommandError(server.ErrorNotFound, "unknown snapshot %q", name)
+	}
+	return snapshot, nil
+}
+
+// list describes every snapshot, oldest first
+func (t *snapshotTable) list() []MemorySnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	infos := make([]MemorySnapshot, 0, len(t.snapshots))
+	for _, snapshot := range t.snapshots {
+		infos = append(infos, snapshot.info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Created.Before(infos[j].Created) })
+	return infos
+}
+
+// saveSnapshot reads every memory block and stores the result under a name
+func (s *Session) saveSnapshot(request SnapshotRequest) (*MemorySnapshot, error) {
+	name := request.Name
+	if name == "" {
+		name = time.Now().Format("20060102-150405.000")
+	}
+	if !safeNamePattern.MatchString(name) {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "invalid snapshot name %q: use up to 64 letters, digits, '.', '_' or '-'", name)
+	}
+
+	blocks, err := s.memoryBlocks()
+	if err != nil {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "%v", err)
+	}
+
+	// Fail fast on a taken name before reading memory; the table is checked
+	// again when the snapshot is stored
+	s.snapshots.mu.Lock()
+	_, exists := s.snapshots.snapshots[name]
+	s.snapshots.mu.Unlock()
+	if exists {
+		return nil, server.NewCommandError(ErrorConflict, "snapshot %q already exists", name)
+	}
+
+	data, err := s.driver.ReadMemoryBlocks(blocks)
+	if err != nil {
+		return nil, server.NewCommandError(ErrorDriver, "failed to read memory blocks: %v", err)
+	}
+
+	snapshot := &memorySnapshot{
+		info:   MemorySnapshot{Name: name, Created: time.Now(), Blocks: make([]SnapshotBlock, len(blocks))},
+		blocks: blocks,
+		data:   data,
+	}
+	for i, block := range blocks {
+		size := int(block.End-block.Start) + 1
+		if len(data[block.Start]) < size {
+			return nil, server.NewCommandError(ErrorDriver, "short read of memory block %s", block.Name)
+		}
+		snapshot.info.Blocks[i] = SnapshotBlock{
+			Name:  block.Name,
+			Start: fmt.Sprintf("0x%04X", block.Start),
+			End:   fmt.Sprintf("0x%04X", block.End),
+			Size:  size,
+		}
+		snapshot.info.Bytes += size
+	}
+
+	s.snapshots.mu.Lock()
+	defer s.snapshots.mu.Unlock()
+	if _, exists := s.snapshots.snapshots[name]; exists {
+		return nil, server.NewCommandError(ErrorConflict, "snapshot %q already exists", name)
+	}
+	if len(s.snapshots.snapshots) >= maxSnapshots {
+		return nil, server.NewCommandError(ErrorConflict, "session %s already has %d snapshots; delete one first", s.id, maxSnapshots)
+	}
+	s.snapshots.snapshots[name] = snapshot
+	return &snapshot.info, nil
+}
+
+// deleteSnapshot discards a snapshot
+func (s *Session) deleteSnapshot(name string) error {
+	s.snapshots.mu.Lock()
+	defer s.snapshots.mu.Unlock()
+
+	if _, ok := s.snapshots.snapshots[name]; !ok {
+		return server.NewCommandError(server.ErrorNotFound, "unknown snapshot %q", name)
+	}
+	delete(s.snapshots.snapshots, name)
+	return nil
+}
+
+// diffSnapshots compares the blocks two snapshots have in common. Each
+// changed byte is grouped under the first layout constant that covers it,
+// else the first property, else its block.
+func (s *Session) diffSnapshots(fromName, toName string) (*SnapshotDiff, error) {
+	from, err := s.snapshots.get(fromName)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.snapshots.get(toName)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &SnapshotDiff{From: fromName, To: toName, Groups: []DiffGroup{}}
+	for _, block := range from.blocks {
+		if !containsBlock(to.blocks, block) {
+			continue
+		}
+		old, new := from.data[block.Start], to.data[block.Start]
+		annotations := memoryAnnotations(block.Start, uint64(block.End-block.Start)+1)
+
+		var group *DiffGroup
+		for i := 0; i <= int(block.End-block.Start); i++ {
+			if old[i] == new[i] {
+				continue
+			}
+			diff.ChangedBytes++
+
+			address := block.Start + uint32(i)
+			label, annotation := diffLabel(annotations, address, block)
+			if group == nil || group.Label != label {
+				diff.Groups = append(diff.Groups, DiffGroup{Label: label, Block: block.Name})
+				group = &diff.Groups[len(diff.Groups)-1]
+				if annotation != nil {
+					group.Known = true
+					group.Description = annotation.Description
+				}
+			}
+
+			// Extend the group's last run when this byte follows it
+			if n := len(group.runs); n > 0 {
+				last := &group.runs[n-1]
+				if last.start+uint32(len(last.old)) == address {
+					offset := last.start - block.Start
+					last.old, last.new = old[offset:i+1], new[offset:i+1]
+					continue
+				}
+			}
+			group.runs = append(group.runs, diffRun{start: address, old: old[i : i+1], new: new[i : i+1]})
+		}
+	}
+
+	// Groups of the same label split by another label's bytes are merged
+	diff.Groups = mergeDiffGroups(diff.Groups)
+	for i := range diff.Groups {
+		group := &diff.Groups[i]
+		group.Ranges = make([]DiffRange, len(group.runs))
+		for j, run := range group.runs {
+			group.Ranges[j] = DiffRange{
+				Address: fmt.Sprintf("0x%04X", run.start),
+				Length:  len(run.old),
+				Old:     hex.EncodeToString(run.old),
+				New:     hex.EncodeToString(run.new),
+			}
+		}
+		group.Properties = decodeDiffGroup(from, to, *group)
+	}
+	return diff, nil
+}
+
+// diffLabel names the group a changed byte belongs to
+func diffLabel(annotations []MemoryAnnotation, address uint32, block connection.MemoryBlock) (string, *MemoryAnnotation) {
+	var property *MemoryAnnotation
+	for i := range annotations {
+		annotation := &annotations[i]
+		if address < annotation.Address || address >= annotation.Address+annotation.Length {
+			continue
+		}
+		if annotation.Source == "constant" {
+			return annotation.Name, annotation
+		}
+		if property == nil {
+			property = annotation
+		}
+	}
+	if property != nil {
+		return property.Name, property
+	}
+	return block.Name, nil
+}
+
+// mergeDiffGroups combines groups with the same label and block, keeping the
+// order in which each label first appears
+func mergeDiffGroups(groups []DiffGroup) []DiffGroup {
+	merged := make([]DiffGroup, 0, len(groups))
+	index := make(map[string]int, len(groups))
+	for _, group := range groups {
+		key := group.Block + "\x00" + group.Label
+		if i, ok := index[key]; ok {
+			merged[i].runs = append(merged[i].runs, group.runs...)
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, group)
+	}
+	return merged
+}
+
+// decodeDiffGroup decodes the old and new value of every property touched by
+// a group's runs
+func decodeDiffGroup(from, to *memorySnapshot, group DiffGroup) []PropertyChange {
+	var changes []PropertyChange
+	for _, property := range properties {
+		touched := false
+		for _, run := range group.runs {
+			if property.Address < run.start+uint32(len(run.old)) && property.Address+property.Length > run.start {
+				touched = true
+				break
+			}
+		}
+		if !touched {
+			continue
+		}
+
+		old := from.bytes(property.Address, property.Length)
+		new := to.bytes(property.Address, property.Length)
+		if old == nil || new == nil {
+			continue
+		}
+		changes = append(changes, PropertyChange{Name: property.Name, Old: property.Decode(old), New: property.Decode(new)})
+	}
+	return changes
+}
+
+// containsBlock reports whether blocks has one with the same name and range
+func containsBlock(blocks []connection.MemoryBlock, block connection.MemoryBlock) bool {
+	for _, other := range blocks {
+		if other == block {
+			return true
+		}
+	}
+	return false
+}
+
+// REST handlers for memory snapshots
+
+func (s *Session) handleListSnapshots(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"snapshots": s.snapshots.list()})
+}
+
+func (s *Session) handleSaveSnapshot(w http.ResponseWriter, r *http.Request) {
+	var request SnapshotRequest
+	if err := decodeOptionalBody(r, &request); err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	info, err := s.saveSnapshot(request)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(info)
+}
+
+func (s *Session) handleGetSnapshot(w http.ResponseWriter, r *http.Request) {
+	snapshot, err := s.snapshots.get(mux.Vars(r)["name"])
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot.info)
+}
+
+func (s *Session) handleDeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	if err := s.deleteSnapshot(mux.Vars(r)["name"]); err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Session) handleDiffSnapshots(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	diff, err := s.diffSnapshots(vars["name"], vars["other"])
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
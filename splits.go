@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"RetroGameAnalysis/server"
+	"RetroGameAnalysis/splits"
+	"github.com/gorilla/mux"
+)
+
+// SplitsInfo is the autosplitter's definition and run, and its LiveSplit
+// connection when one is configured
+type SplitsInfo struct {
+	Definition *splits.Definition      `json:"definition"`
+	Run        splits.Run              `json:"run"`
+	LiveSplit  *splits.LiveSplitStatus `json:"livesplit,omitempty"`
+}
+
+// splitSource gives split conditions the game state's values by dotted
+// path, e.g. badges.0.obtained, named event flags such as
+// event_flags.beat_brock, and reads properties the state does not hold, such
+// as badge_flags, from memory. Only the monitor goroutine uses it.
+type splitSource struct {
+	session *Session
+	seq     uint64
+	tree    map[string]interface{}
+
+	// flags is read from memory at most once per update, when a condition
+	// names an event flag
+	flags *EventFlags
+}
+
+// refresh converts the current game state into a tree of JSON values when it
+// has changed since the last update
+func (src *splitSource) refresh() {
+	src.flags = nil
+	snapshot := src.session.gameState.Load()
+	if src.tree != nil && snapshot.Seq == src.seq {
+		return
+	}
+	src.seq, src.tree = snapshot.Seq, gameStateTree(snapshot.Data)
+}
+
+func (src *splitSource) Value(name string) (interface{}, bool) {
+	if value, ok := lookupStatePath(src.tree, name); ok {
+		return value, true
+	}
+	if index, ok := lookupEventFlag(name); ok {
+		if src.flags == nil {
+			flags, err := src.session.readEventFlags()
+			if err != nil {
+				return nil, false
+			}
+			src.flags = flags
+		}
+		return flagSet(src.flags.Events, index), true
+	}
+	if _, err := lookupProperty(name); err != nil {
+		return nil, false
+	}
+	value, err := src.session.readProperty(name)
+	if err != nil {
+		return nil, false
+	}
+	return value.Value, true
+}
+
+// gameStateTree converts game data to its JSON form
+func gameStateTree(data *GameData) map[string]interface{} {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	var tree map[string]interface{}
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		return map[string]interface{}{}
+	}
+	return tree
+}
+
+// lookupStatePath finds a dotted path such as pokemon.0.level in a tree
+func lookupStatePath(tree map[string]interface{}, path string) (interface{}, bool) {
+	var node interface{} = tree
+	for _, key := range strings.Split(path, ".") {
+		switch v := node.(type) {
+		case map[string]interface{}:
+			child, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			node = child
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+			node = v[index]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// knownSplitProperty reports whether a condition can name a property: any
+// property, a named event flag, or a path into one of the game state's fields
+func knownSplitProperty(name string) bool {
+	if _, err := lookupProperty(name); err == nil {
+		return true
+	}
+	if _, ok := lookupEventFlag(name); ok {
+		return true
+	}
+	_, ok := gameStateTree(&GameData{})[strings.SplitN(name, ".", 2)[0]]
+	return ok
+}
+
+// loadSplitDefinition reads and validates a split definition file; no file
+// gives an empty definition
+func loadSplitDefinition(path string) (*splits.Definition, error) {
+	if path == "" {
+		return &splits.Definition{}, nil
+	}
+	definition, err := splits.LoadDefinition(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := definition.Validate(knownSplitProperty); err != nil {
+		return nil, err
+	}
+	return definition, nil
+}
+
+// newSplitter creates the session's autosplitter. Its events are logged,
+// broadcast as split_event messages and sent to LiveSplit.
+func (s *Session) newSplitter(definition *splits.Definition) {
+	if s.config.LiveSplit != "" {
+		s.liveSplit = splits.NewLiveSplit(s.config.LiveSplit, func(format string, args ...interface{}) {
+			log.Printf("🏁 [%s] "+format, append([]interface{}{s.id}, args...)...)
+		})
+	}
+	s.splitSource = &splitSource{session: s}
+	s.splitter = splits.NewTimer(definition, func(event splits.Event) {
+		if event.Split != "" {
+			log.Printf("🏁 [%s] %s %q at %s", s.id, event.Type, event.Split, event.Run.Duration)
+		} else {
+			log.Printf("🏁 [%s] %s at %s", s.id, event.Type, event.Run.Duration)
+		}
+		if s.liveSplit != nil {
+			s.liveSplit.Send(event)
+		}
+		s.wsManager.BroadcastMessage(server.Message{
+			Type:      "split_event",
+			Data:      event,
+			Timestamp: time.Now(),
+		})
+	})
+}
+
+// updateSplits checks the split conditions after a poll
+func (s *Session) updateSplits() {
+	definition := s.splitter.Definition()
+	if definition.Start == nil && definition.Reset == nil && definition.Pause == nil && len(definition.Splits) == 0 {
+		return
+	}
+	s.splitSource.refresh()
+	s.splitter.Update(s.splitSource)
+}
+
+func (s *Session) splitsInfo(run splits.Run) *SplitsInfo {
+	info := &SplitsInfo{Definition: s.splitter.Definition(), Run: run}
+	if s.liveSplit != nil {
+		status := s.liveSplit.Status()
+		info.LiveSplit = &status
+	}
+	return info
+}
+
+// controlSplits performs a timer action such as split or reset
+func (s *Session) controlSplits(action string) (*SplitsInfo, error) {
+	run, err := s.splitter.Do(action)
+	switch {
+	case errors.Is(err, splits.ErrUnknownAction):
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "%v", err)
+	case errors.Is(err, splits.ErrState):
+		return nil, server.NewCommandError(ErrorConflict, "%v", err)
+	case err != nil:
+		return nil, err
+	}
+	return s.splitsInfo(run), nil
+}
+
+// loadSplits replaces the split definition and resets the run
+func (s *Session) loadSplits(data []byte) (*SplitsInfo, error) {
+	definition, err := splits.ParseDefinition(data)
+	if err != nil {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "%v", err)
+	}
+	if err := definition.Validate(knownSplitProperty); err != nil {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "invalid definition: %v", err)
+	}
+	return s.splitsInfo(s.splitter.Load(definition)), nil
+}
+
+func (s *Session) registerSplitCommands() {
+	s.wsManager.RegisterCommand("splits_get", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		return s.splitsInfo(s.splitter.Run()), nil
+	})
+
+	s.wsManager.RegisterCommand("splits_control", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request struct {
+			Action string `json:"action"`
+		}
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		return s.controlSplits(request.Action)
+	})
+
+	s.wsManager.RegisterCommand("splits_load", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request struct {
+			Definition json.RawMessage `json:"definition"`
+		}
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		return s.loadSplits(request.Definition)
+	})
+}
+
+// REST handlers for the autosplitter
+
+func (s *Session) handleGetSplits(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.splitsInfo(s.splitter.Run()))
+}
+
+// handleLoadSplits takes a definition as JSON or YAML
+func (s *Session) handleLoadSplits(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "invalid request body: %v", err))
+		return
+	}
+
+	info, err := s.loadSplits(data)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func (s *Session) handleControlSplits(w http.ResponseWriter, r *http.Request) {
+	info, err := s.controlSplits(mux.Vars(r)["action"])
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
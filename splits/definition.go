@@ -0,0 +1,281 @@
+// Package splits implements an autosplitter: a speedrun timer whose start,
+// splits, reset and load pauses follow declarative conditions on the game
+// state, and a client for the LiveSplit Server protocol that mirrors the
+// timer into LiveSplit.
+package splits
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Definition describes a run: when it starts, resets and pauses, and the
+// conditions that complete each split in order. Every condition is optional.
+type Definition struct {
+	Name   string     `json:"name" yaml:"name"`
+	Start  *Condition `json:"start,omitempty" yaml:"start,omitempty"`
+	Reset  *Condition `json:"reset,omitempty" yaml:"reset,omitempty"`
+	Pause  *Condition `json:"pause,omitempty" yaml:"pause,omitempty"`
+	Splits []Split    `json:"splits" yaml:"splits"`
+}
+
+// Split is one segment of a run, completed when When becomes true
+type Split struct {
+	Name string    `json:"name" yaml:"name"`
+	When Condition `json:"when" yaml:"when"`
+}
+
+// Condition tests a property, or combines other conditions. Bit tests one
+// bit of a numeric value, which then compares as a bool. The comparisons
+// that are set must all hold; with none, the value must be truthy: true, a
+// number other than zero or a string other than "". Numbers may be written
+// as strings such as "0x76".
+type Condition struct {
+	Property string      `json:"property,omitempty" yaml:"property,omitempty"`
+	Bit      *int        `json:"bit,omitempty" yaml:"bit,omitempty"`
+	Eq       interface{} `json:"eq,omitempty" yaml:"eq,omitempty"`
+	Ne       interface{} `json:"ne,omitempty" yaml:"ne,omitempty"`
+	Gt       interface{} `json:"gt,omitempty" yaml:"gt,omitempty"`
+	Ge       interface{} `json:"ge,omitempty" yaml:"ge,omitempty"`
+	Lt       interface{} `json:"lt,omitempty" yaml:"lt,omitempty"`
+	Le       interface{} `json:"le,omitempty" yaml:"le,omitempty"`
+
+	// All holds when every one of its conditions does, Any when one does
+	All []Condition `json:"all,omitempty" yaml:"all,omitempty"`
+	Any []Condition `json:"any,omitempty" yaml:"any,omitempty"`
+}
+
+// Source gives conditions the current value of a property. ok is false when
+// the property has no value, which fails every condition on it.
+type Source interface {
+	Value(name string) (value interface{}, ok bool)
+}
+
+// LoadDefinition reads a YAML or JSON definition file
+func LoadDefinition(path string) (*Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	definition, err := ParseDefinition(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return definition, nil
+}
+
+// ParseDefinition parses a YAML or JSON definition. JSON is valid YAML.
+func ParseDefinition(data []byte) (*Definition, error) {
+	var definition Definition
+	decoder := yaml.NewDecoder(strings.NewReader(string(data)))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&definition); err != nil {
+		return nil, fmt.Errorf("invalid definition: %w", err)
+	}
+	return &definition, nil
+}
+
+// Validate checks the definition's conditions. known reports whether a
+// property name can have a value.
+func (d *Definition) Validate(known func(name string) bool) error {
+	for _, named := range []struct {
+		name      string
+		condition *Condition
+	}{{"start", d.Start}, {"reset", d.Reset}, {"pause", d.Pause}} {
+		if named.condition == nil {
+			continue
+		}
+		if err := named.condition.validate(known); err != nil {
+			return fmt.Errorf("%s: %w", named.name, err)
+		}
+	}
+
+	seen := make(map[string]bool, len(d.Splits))
+	for i, split := range d.Splits {
+		if split.Name == "" {
+			return fmt.Errorf("split %d has no name", i+1)
+		}
+		if seen[split.Name] {
+			return fmt.Errorf("duplicate split %q", split.Name)
+		}
+		seen[split.Name] = true
+		if err := split.When.validate(known); err != nil {
+			return fmt.Errorf("split %q: %w", split.Name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Condition) validate(known func(name string) bool) error {
+	nested := len(c.All) + len(c.Any)
+	switch {
+	case c.Property == "" && nested == 0:
+		return fmt.Errorf("condition needs a property, all or any")
+	case c.Property != "" && nested > 0:
+		return fmt.Errorf("condition on %q cannot also have all or any", c.Property)
+	case c.Property != "" && !known(c.Property):
+		return fmt.Errorf("unknown property %q", c.Property)
+	case c.Bit != nil && (*c.Bit < 0 || *c.Bit > 63):
+		return fmt.Errorf("bit %d out of range 0-63", *c.Bit)
+	}
+
+	for _, target := range []interface{}{c.Gt, c.Ge, c.Lt, c.Le} {
+		if target == nil {
+			continue
+		}
+		if _, ok := toNumber(target); !ok {
+			return fmt.Errorf("ordered comparison on %q needs a number, got %v", c.Property, target)
+		}
+	}
+
+	for i := range c.All {
+		if err := c.All[i].validate(known); err != nil {
+			return err
+		}
+	}
+	for i := range c.Any {
+		if err := c.Any[i].validate(known); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Eval reports whether the condition holds for the source's current values
+func (c *Condition) Eval(source Source) bool {
+	if len(c.All) > 0 {
+		for i := range c.All {
+			if !c.All[i].Eval(source) {
+				return false
+			}
+		}
+		return true
+	}
+	if len(c.Any) > 0 {
+		for i := range c.Any {
+			if c.Any[i].Eval(source) {
+				return true
+			}
+		}
+		return false
+	}
+
+	value, ok := source.Value(c.Property)
+	if !ok {
+		return false
+	}
+	if c.Bit != nil {
+		n, ok := toNumber(value)
+		if !ok || n < 0 {
+			return false
+		}
+		value = uint64(n)>>uint(*c.Bit)&1 == 1
+	}
+
+	compared := false
+	for _, test := range []struct {
+		target  interface{}
+		ordered bool
+		holds   func(cmp int) bool
+	}{
+		{c.Eq, false, func(cmp int) bool { return cmp == 0 }},
+		{c.Ne, false, func(cmp int) bool { return cmp != 0 }},
+		{c.Gt, true, func(cmp int) bool { return cmp > 0 }},
+		{c.Ge, true, func(cmp int) bool { return cmp >= 0 }},
+		{c.Lt, true, func(cmp int) bool { return cmp < 0 }},
+		{c.Le, true, func(cmp int) bool { return cmp <= 0 }},
+	} {
+		if test.target == nil {
+			continue
+		}
+		compared = true
+		cmp, ordered := compare(value, test.target)
+		if (test.ordered && !ordered) || !test.holds(cmp) {
+			return false
+		}
+	}
+	return compared || truthy(value)
+}
+
+// compare returns how a value orders against a condition's target. Numbers
+// order numerically; other values are only equal (0) or not (1), and
+// ordered is false.
+func compare(value, target interface{}) (cmp int, ordered bool) {
+	if n, ok := toNumber(value); ok {
+		t, ok := toNumber(target)
+		switch {
+		case !ok:
+			return 1, false
+		case n < t:
+			return -1, true
+		case n > t:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch v := value.(type) {
+	case bool:
+		if t, ok := target.(bool); ok && v == t {
+			return 0, false
+		}
+	case string:
+		if t, ok := target.(string); ok && v == t {
+			return 0, false
+		}
+	}
+	return 1, false
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+	if n, ok := toNumber(value); ok {
+		return n != 0
+	}
+	return true
+}
+
+// toNumber converts a numeric value, or a string holding a decimal or
+// 0x-prefixed number, to a float64
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, !math.IsNaN(v)
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		if n, err := strconv.ParseUint(v, 0, 64); err == nil {
+			return float64(n), true
+		}
+		if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(f) {
+			return f, true
+		}
+	}
+	return 0, false
+}
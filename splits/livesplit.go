@@ -0,0 +1,149 @@
+package splits
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLiveSplitPort is the port LiveSplit Server listens on by default
+const DefaultLiveSplitPort = 16834
+
+const (
+	liveSplitQueue        = 64
+	liveSplitDialTimeout  = 2 * time.Second
+	liveSplitWriteTimeout = 2 * time.Second
+)
+
+// LiveSplit sends timer events to a LiveSplit Server component over TCP.
+// Commands are queued so the poll loop never waits on the network. The
+// connection is made on demand; commands that cannot be sent are dropped
+// rather than delivered late, since a late split would have the wrong time.
+type LiveSplit struct {
+	addr      string
+	queue     chan string
+	connected atomic.Bool
+	dropped   atomic.Uint64
+	logf      func(format string, args ...interface{})
+}
+
+// LiveSplitStatus describes the connection to LiveSplit
+type LiveSplitStatus struct {
+	Address   string `json:"address"`
+	Connected bool   `json:"connected"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// NewLiveSplit creates a client for the LiveSplit Server at addr. logf
+// reports connection changes.
+func NewLiveSplit(addr string, logf func(format string, args ...interface{})) *LiveSplit {
+	return &LiveSplit{addr: addr, queue: make(chan string, liveSplitQueue), logf: logf}
+}
+
+// Status reports the connection state
+func (l *LiveSplit) Status() LiveSplitStatus {
+	return LiveSplitStatus{Address: l.addr, Connected: l.connected.Load(), Dropped: l.dropped.Load()}
+}
+
+// Commands returns the LiveSplit Server commands for a timer event. Pauses
+// caused by the definition's pause condition pause game time, as load
+// removal does; requested pauses pause the timer.
+func Commands(event Event) []string {
+	switch event.Type {
+	case ActionStart:
+		return []string{"starttimer", "initgametime"}
+	case ActionSplit, EventFinish:
+		return []string{"split"}
+	case ActionSkip:
+		return []string{"skipsplit"}
+	case ActionUndo:
+		return []string{"unsplit"}
+	case ActionReset:
+		return []string{"reset"}
+	case ActionPause:
+		if event.Auto {
+			return []string{"pausegametime"}
+		}
+		return []string{"pause"}
+	case ActionResume:
+		if event.Auto {
+			return []string{"unpausegametime"}
+		}
+		return []string{"resume"}
+	}
+	return nil
+}
+
+// Send queues the commands for an event
+func (l *LiveSplit) Send(event Event) {
+	for _, command := range Commands(event) {
+		select {
+		case l.queue <- command:
+		default:
+			l.dropped.Add(1)
+		}
+	}
+}
+
+// Run delivers queued commands until ctx is cancelled
+func (l *LiveSplit) Run(ctx context.Context) {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+		l.connected.Store(false)
+	}()
+
+	// failing is set after a failed attempt, so an absent LiveSplit is
+	// logged once rather than on every command
+	failing := false
+	connect := func() {
+		dialer := net.Dialer{Timeout: liveSplitDialTimeout}
+		c, err := dialer.DialContext(ctx, "tcp", l.addr)
+		if err != nil {
+			if !failing && ctx.Err() == nil {
+				l.logf("cannot connect to LiveSplit at %s: %v", l.addr, err)
+			}
+			failing = true
+			return
+		}
+		conn, failing = c, false
+		l.connected.Store(true)
+		l.logf("connected to LiveSplit at %s", l.addr)
+	}
+	connect()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case command := <-l.queue:
+			// A connection LiveSplit has closed may only fail on write, so
+			// a failed write reconnects and tries once more
+			sent := false
+			for attempt := 0; attempt < 2 && !sent; attempt++ {
+				if conn == nil {
+					connect()
+				}
+				if conn == nil {
+					break
+				}
+				conn.SetWriteDeadline(time.Now().Add(liveSplitWriteTimeout))
+				if _, err := io.WriteString(conn, command+"\r\n"); err != nil {
+					l.logf("lost LiveSplit at %s: %v", l.addr, err)
+					conn.Close()
+					conn = nil
+					l.connected.Store(false)
+					continue
+				}
+				sent = true
+			}
+			if !sent {
+				l.dropped.Add(1)
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package splits
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		event Event
+		want  []string
+	}{
+		{Event{Type: ActionStart}, []string{"starttimer", "initgametime"}},
+		{Event{Type: ActionSplit}, []string{"split"}},
+		{Event{Type: EventFinish}, []string{"split"}},
+		{Event{Type: ActionSkip}, []string{"skipsplit"}},
+		{Event{Type: ActionUndo}, []string{"unsplit"}},
+		{Event{Type: ActionReset}, []string{"reset"}},
+		{Event{Type: ActionPause}, []string{"pause"}},
+		{Event{Type: ActionPause, Auto: true}, []string{"pausegametime"}},
+		{Event{Type: ActionResume}, []string{"resume"}},
+		{Event{Type: ActionResume, Auto: true}, []string{"unpausegametime"}},
+	}
+	for _, test := range tests {
+		got := Commands(test.event)
+		if len(got) != len(test.want) {
+			t.Errorf("Commands(%s, auto %v) = %v, want %v", test.event.Type, test.event.Auto, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("Commands(%s, auto %v) = %v, want %v", test.event.Type, test.event.Auto, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+// runLiveSplit runs a client for addr until the test ends
+func runLiveSplit(t *testing.T, addr string) *LiveSplit {
+	t.Helper()
+
+	client := NewLiveSplit(addr, t.Logf)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return client
+}
+
+func TestLiveSplitSendsCommands(t *testing.T) {
+	// A listener stands in for LiveSplit Server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	client := runLiveSplit(t, listener.Addr().String())
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	client.Send(Event{Type: ActionStart})
+	client.Send(Event{Type: ActionPause, Auto: true})
+	client.Send(Event{Type: EventFinish})
+
+	reader := bufio.NewReader(conn)
+	for _, want := range []string{"starttimer", "initgametime", "pausegametime", "split"} {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read %q: %v", want, err)
+		}
+		if line != want+"\r\n" {
+			t.Fatalf("received %q, want %q", line, want+"\r\n")
+		}
+	}
+
+	status := client.Status()
+	if !status.Connected || status.Dropped != 0 {
+		t.Errorf("status %+v, want connected with nothing dropped", status)
+	}
+}
+
+func TestLiveSplitDropsWithoutServer(t *testing.T) {
+	// Take a free port and close it so nothing is listening there
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	client := runLiveSplit(t, addr)
+	client.Send(Event{Type: ActionSplit})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for client.Status().Dropped == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("command was not dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if client.Status().Connected {
+		t.Error("client reports a connection with no server")
+	}
+}
@@ -0,0 +1,326 @@
+package splits
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Run states
+const (
+	StateIdle     = "idle"
+	StateRunning  = "running"
+	StatePaused   = "paused"
+	StateFinished = "finished"
+)
+
+// Timer actions, which are also the types of the events they cause
+const (
+	ActionStart  = "start"
+	ActionSplit  = "split"
+	ActionUndo   = "undo"
+	ActionSkip   = "skip"
+	ActionReset  = "reset"
+	ActionPause  = "pause"
+	ActionResume = "resume"
+)
+
+// EventFinish is the event of the last split
+const EventFinish = "finish"
+
+var (
+	// ErrUnknownAction reports an action that is not one of the Action constants
+	ErrUnknownAction = errors.New("unknown action")
+
+	// ErrState reports an action the run's state does not allow, such as
+	// splitting before the start
+	ErrState = errors.New("not allowed now")
+)
+
+// SplitTime is a split with the run time it was completed at, if it has been
+type SplitTime struct {
+	Name     string  `json:"name"`
+	Done     bool    `json:"done"`
+	Skipped  bool    `json:"skipped,omitempty"`
+	Seconds  float64 `json:"seconds,omitempty"`
+	Duration string  `json:"duration,omitempty"`
+}
+
+// Run is the state of the current run. Its time leaves out pauses.
+type Run struct {
+	Name     string      `json:"name"`
+	State    string      `json:"state"`
+	Started  *time.Time  `json:"started,omitempty"`
+	Seconds  float64     `json:"seconds"`
+	Duration string      `json:"duration"`
+	Current  int         `json:"current"`
+	Splits   []SplitTime `json:"splits"`
+}
+
+// Event reports a change to the run. Auto is set when a condition caused
+// it rather than a request.
+type Event struct {
+	Type  string `json:"type"`
+	Split string `json:"split,omitempty"`
+	Auto  bool   `json:"auto"`
+	Run   Run    `json:"run"`
+}
+
+// Timer keeps a run's state and moves it along as the definition's
+// conditions become true, or as actions ask
+type Timer struct {
+	mu         sync.Mutex
+	definition *Definition
+	onEvent    func(Event)
+
+	state   string
+	started time.Time
+	elapsed time.Duration // run time up to resumed
+	resumed time.Time     // when the run last started or resumed
+	times   []SplitTime
+	current int
+	// autoPaused is set while the definition's pause condition holds
+	autoPaused bool
+
+	// Conditions act when they become true, so the timer keeps what each
+	// evaluated to on the previous update; primed is set once it has
+	primed    bool
+	lastStart bool
+	lastReset bool
+	lastSplit []bool
+}
+
+// NewTimer creates an idle timer. onEvent is called for every change to the
+// run, without the timer's lock held.
+func NewTimer(definition *Definition, onEvent func(Event)) *Timer {
+	t := &Timer{onEvent: onEvent}
+	t.load(definition)
+	return t
+}
+
+// Load replaces the definition and resets the run without an event
+func (t *Timer) Load(definition *Definition) Run {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.load(definition)
+	return t.snapshot(time.Now())
+}
+
+func (t *Timer) load(definition *Definition) {
+	if definition == nil {
+		definition = &Definition{}
+	}
+	t.definition = definition
+	t.primed = false
+	t.lastSplit = make([]bool, len(definition.Splits))
+	t.resetRun()
+}
+
+func (t *Timer) resetRun() {
+	t.state = StateIdle
+	t.started = time.Time{}
+	t.elapsed = 0
+	t.autoPaused = false
+	t.current = 0
+	t.times = make([]SplitTime, len(t.definition.Splits))
+	for i, split := range t.definition.Splits {
+		t.times[i] = SplitTime{Name: split.Name}
+	}
+}
+
+// Definition returns the timer's definition
+func (t *Timer) Definition() *Definition {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.definition
+}
+
+// Run returns the current run
+func (t *Timer) Run() Run {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshot(time.Now())
+}
+
+// Update evaluates the definition's conditions against the source and acts
+// on those that have become true since the previous update: start, reset
+// and the current split. The pause condition pauses the run for as long as
+// it holds. The first update after a definition is loaded only records the
+// conditions, so a game that is already past a split does not trigger it.
+func (t *Timer) Update(source Source) {
+	t.mu.Lock()
+	now := time.Now()
+
+	start := t.definition.Start != nil && t.definition.Start.Eval(source)
+	reset := t.definition.Reset != nil && t.definition.Reset.Eval(source)
+	pause := t.definition.Pause != nil && t.definition.Pause.Eval(source)
+	splits := make([]bool, len(t.definition.Splits))
+	for i := range t.definition.Splits {
+		splits[i] = t.definition.Splits[i].When.Eval(source)
+	}
+
+	var events []Event
+	if t.primed {
+		rose := func(now, last bool) bool { return now && !last }
+		switch {
+		case t.state != StateIdle && rose(reset, t.lastReset):
+			events = t.apply(ActionReset, true, now)
+		case t.state == StateIdle && rose(start, t.lastStart):
+			events = t.apply(ActionStart, true, now)
+		case t.running() && t.current < len(splits) && rose(splits[t.current], t.lastSplit[t.current]):
+			events = t.apply(ActionSplit, true, now)
+		case t.state == StateRunning && pause:
+			events = t.apply(ActionPause, true, now)
+		case t.state == StatePaused && t.autoPaused && !pause:
+			events = t.apply(ActionResume, true, now)
+		}
+	}
+	t.primed = true
+	t.lastStart, t.lastReset, t.lastSplit = start, reset, splits
+	t.mu.Unlock()
+
+	t.emit(events)
+}
+
+// Do performs an action requested by a client
+func (t *Timer) Do(action string) (Run, error) {
+	t.mu.Lock()
+	now := time.Now()
+	if err := t.check(action); err != nil {
+		t.mu.Unlock()
+		return Run{}, err
+	}
+	events := t.apply(action, false, now)
+	run := t.snapshot(now)
+	t.mu.Unlock()
+
+	t.emit(events)
+	return run, nil
+}
+
+// check reports whether the run's state allows an action
+func (t *Timer) check(action string) error {
+	allowed := false
+	switch action {
+	case ActionStart:
+		allowed = t.state == StateIdle
+	case ActionSplit, ActionSkip:
+		allowed = t.running() && t.current < len(t.times)
+		if action == ActionSkip && t.current == len(t.times)-1 {
+			return fmt.Errorf("%w: cannot skip the last split", ErrState)
+		}
+	case ActionUndo:
+		allowed = (t.running() || t.state == StateFinished) && t.current > 0
+	case ActionReset:
+		allowed = t.state != StateIdle
+	case ActionPause:
+		allowed = t.state == StateRunning
+	case ActionResume:
+		allowed = t.state == StatePaused
+	default:
+		return fmt.Errorf("%w %q (expected start, split, undo, skip, reset, pause or resume)", ErrUnknownAction, action)
+	}
+	if !allowed {
+		return fmt.Errorf("%w: cannot %s while %s", ErrState, action, t.state)
+	}
+	return nil
+}
+
+// apply performs an action the state allows and returns its events
+func (t *Timer) apply(action string, auto bool, now time.Time) []Event {
+	event := Event{Type: action, Auto: auto}
+	switch action {
+	case ActionStart:
+		t.resetRun()
+		t.state = StateRunning
+		t.started = now
+		t.resumed = now
+	case ActionSplit, ActionSkip:
+		elapsed := t.runTime(now)
+		split := &t.times[t.current]
+		split.Done = true
+		split.Skipped = action == ActionSkip
+		if !split.Skipped {
+			split.Seconds = elapsed.Seconds()
+			split.Duration = formatRunTime(elapsed)
+		}
+		event.Split = split.Name
+		t.current++
+		if t.current == len(t.times) {
+			t.elapsed = elapsed
+			t.state = StateFinished
+			event.Type = EventFinish
+		}
+	case ActionUndo:
+		if t.state == StateFinished {
+			t.state = StateRunning
+			t.resumed = now
+			t.autoPaused = false
+		}
+		t.current--
+		t.times[t.current] = SplitTime{Name: t.times[t.current].Name}
+		event.Split = t.times[t.current].Name
+	case ActionReset:
+		t.resetRun()
+	case ActionPause:
+		t.elapsed = t.runTime(now)
+		t.state = StatePaused
+		t.autoPaused = auto
+	case ActionResume:
+		t.state = StateRunning
+		t.resumed = now
+		t.autoPaused = false
+	}
+	event.Run = t.snapshot(now)
+	return []Event{event}
+}
+
+// running reports whether a run is under way, paused or not
+func (t *Timer) running() bool {
+	return t.state == StateRunning || t.state == StatePaused
+}
+
+// runTime is the run's time, leaving out pauses
+func (t *Timer) runTime(now time.Time) time.Duration {
+	if t.state == StateRunning {
+		return t.elapsed + now.Sub(t.resumed)
+	}
+	return t.elapsed
+}
+
+func (t *Timer) snapshot(now time.Time) Run {
+	elapsed := t.runTime(now)
+	run := Run{
+		Name:     t.definition.Name,
+		State:    t.state,
+		Seconds:  elapsed.Seconds(),
+		Duration: formatRunTime(elapsed),
+		Current:  t.current,
+		Splits:   append([]SplitTime(nil), t.times...),
+	}
+	if !t.started.IsZero() {
+		started := t.started
+		run.Started = &started
+	}
+	return run
+}
+
+func (t *Timer) emit(events []Event) {
+	if t.onEvent == nil {
+		return
+	}
+	for _, event := range events {
+		t.onEvent(event)
+	}
+}
+
+// formatRunTime formats a run time as LiveSplit does, e.g. 1:02:03.45
+func formatRunTime(d time.Duration) string {
+	d = d.Round(10 * time.Millisecond)
+	hours := int(d / time.Hour)
+	minutes := int(d/time.Minute) % 60
+	seconds := int(d/time.Second) % 60
+	hundredths := int(d/(10*time.Millisecond)) % 100
+	return fmt.Sprintf("%d:%02d:%02d.%02d", hours, minutes, seconds, hundredths)
+}
@@ -0,0 +1,194 @@
+package splits
+
+import (
+	"errors"
+	"testing"
+)
+
+// values is a Source backed by a map
+type values map[string]interface{}
+
+func (v values) Value(name string) (interface{}, bool) {
+	value, ok := v[name]
+	return value, ok
+}
+
+// newTestTimer creates a timer that records the types of its events
+func newTestTimer(definition *Definition) (*Timer, *[]Event) {
+	var events []Event
+	timer := NewTimer(definition, func(event Event) {
+		events = append(events, event)
+	})
+	return timer, &events
+}
+
+func threeSplits() *Definition {
+	return &Definition{
+		Name:  "test",
+		Start: &Condition{Property: "start"},
+		Reset: &Condition{Property: "reset"},
+		Pause: &Condition{Property: "loading"},
+		Splits: []Split{
+			{Name: "one", When: Condition{Property: "one"}},
+			{Name: "two", When: Condition{Property: "two"}},
+			{Name: "three", When: Condition{Property: "three"}},
+		},
+	}
+}
+
+func eventTypes(events []Event) []string {
+	types := make([]string, len(events))
+	for i, event := range events {
+		types[i] = event.Type
+	}
+	return types
+}
+
+func checkEvents(t *testing.T, events []Event, want ...string) {
+	t.Helper()
+	got := eventTypes(events)
+	if len(got) != len(want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("events %v, want %v", got, want)
+		}
+	}
+}
+
+func TestUpdateActsOnRisingEdges(t *testing.T) {
+	timer, events := newTestTimer(threeSplits())
+
+	// Conditions that hold when the definition is loaded do not act
+	state := values{"start": true, "one": true}
+	timer.Update(state)
+	timer.Update(state)
+	checkEvents(t, *events)
+
+	state["start"] = false
+	timer.Update(state)
+	state["start"] = true
+	timer.Update(state)
+	checkEvents(t, *events, ActionStart)
+
+	// The first split has held all along, so it needs to turn false first
+	timer.Update(state)
+	checkEvents(t, *events, ActionStart)
+
+	state["one"] = false
+	timer.Update(state)
+	state["one"] = true
+	timer.Update(state)
+	timer.Update(state)
+	checkEvents(t, *events, ActionStart, ActionSplit)
+	if split := (*events)[1]; split.Split != "one" || !split.Auto {
+		t.Errorf("split event %+v, want an automatic split of one", split)
+	}
+
+	// Only the current split is checked
+	state["three"] = true
+	timer.Update(state)
+	checkEvents(t, *events, ActionStart, ActionSplit)
+
+	state["reset"] = true
+	timer.Update(state)
+	checkEvents(t, *events, ActionStart, ActionSplit, ActionReset)
+	if run := timer.Run(); run.State != StateIdle || run.Current != 0 {
+		t.Errorf("run after reset is %s at split %d, want idle at 0", run.State, run.Current)
+	}
+}
+
+func TestUpdatePausesWhileConditionHolds(t *testing.T) {
+	timer, events := newTestTimer(threeSplits())
+
+	state := values{}
+	timer.Update(state)
+	state["start"] = true
+	timer.Update(state)
+
+	state["loading"] = true
+	timer.Update(state)
+	timer.Update(state)
+	if run := timer.Run(); run.State != StatePaused {
+		t.Fatalf("run is %s while loading, want paused", run.State)
+	}
+
+	// A paused run can still split
+	state["one"] = true
+	timer.Update(state)
+	if run := timer.Run(); run.Current != 1 || run.State != StatePaused {
+		t.Fatalf("run is %s at split %d, want paused at 1", run.State, run.Current)
+	}
+
+	state["loading"] = false
+	timer.Update(state)
+	checkEvents(t, *events, ActionStart, ActionPause, ActionSplit, ActionResume)
+	for _, event := range (*events)[1:] {
+		if !event.Auto {
+			t.Errorf("%s event is not automatic", event.Type)
+		}
+	}
+
+	// A requested pause is not resumed by the condition
+	if _, err := timer.Do(ActionPause); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	timer.Update(state)
+	if run := timer.Run(); run.State != StatePaused {
+		t.Errorf("requested pause ended by the condition: run is %s", run.State)
+	}
+}
+
+func TestUndoAfterFinish(t *testing.T) {
+	timer, events := newTestTimer(threeSplits())
+
+	for _, action := range []string{ActionStart, ActionSplit, ActionSplit, ActionSplit} {
+		if _, err := timer.Do(action); err != nil {
+			t.Fatalf("%s: %v", action, err)
+		}
+	}
+	checkEvents(t, *events, ActionStart, ActionSplit, ActionSplit, EventFinish)
+	if _, err := timer.Do(ActionSplit); !errors.Is(err, ErrState) {
+		t.Errorf("split after finish: %v, want ErrState", err)
+	}
+
+	run, err := timer.Do(ActionUndo)
+	if err != nil {
+		t.Fatalf("undo: %v", err)
+	}
+	if run.State != StateRunning || run.Current != 2 {
+		t.Errorf("run after undo is %s at split %d, want running at 2", run.State, run.Current)
+	}
+	if last := run.Splits[2]; last.Done || last.Duration != "" {
+		t.Errorf("undone split %+v still has a time", last)
+	}
+	if _, err := timer.Do(ActionSplit); err != nil {
+		t.Errorf("split after undo: %v", err)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	timer, _ := newTestTimer(threeSplits())
+
+	if _, err := timer.Do(ActionStart); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	run, err := timer.Do(ActionSkip)
+	if err != nil {
+		t.Fatalf("skip: %v", err)
+	}
+	if first := run.Splits[0]; !first.Done || !first.Skipped || first.Duration != "" {
+		t.Errorf("skipped split %+v, want done and skipped without a time", first)
+	}
+
+	if _, err := timer.Do(ActionSplit); err != nil {
+		t.Fatalf("split: %v", err)
+	}
+	if _, err := timer.Do(ActionSkip); !errors.Is(err, ErrState) {
+		t.Errorf("skip of the last split: %v, want ErrState", err)
+	}
+	if run := timer.Run(); run.State != StateRunning || run.Current != 2 {
+		t.Errorf("run is %s at split %d after a refused skip, want running at 2", run.State, run.Current)
+	}
+}
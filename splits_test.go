@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestSplitSourceEventFlags(t *testing.T) {
+	if !knownSplitProperty("event_flags.beat_brock") {
+		t.Error("event_flags.beat_brock is not a known split property")
+	}
+	if knownSplitProperty("event_flags.beat_nobody") {
+		t.Error("event_flags.beat_nobody is a known split property")
+	}
+
+	driver := newFakeDriver()
+	srv := newTestServer(t, driver)
+	session, err := srv.session(DefaultSessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &splitSource{session: session}
+
+	source.refresh()
+	if value, ok := source.Value("event_flags.beat_brock"); !ok || value != false {
+		t.Errorf("beat_brock before the flag is set = %v, %v; want false", value, ok)
+	}
+
+	// Brock's flag is 0x077: bit 7 of the event flags' byte 0x0E
+	driver.WriteBytes(EVENT_FLAGS_ADDR+0x0E, []byte{0x80})
+	if value, _ := source.Value("event_flags.beat_brock"); value != false {
+		t.Error("flags were read again within one update")
+	}
+	source.refresh()
+	if value, ok := source.Value("event_flags.beat_brock"); !ok || value != true {
+		t.Errorf("beat_brock after the flag is set = %v, %v; want true", value, ok)
+	}
+}
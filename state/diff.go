@@ -0,0 +1,157 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change operations, named after their JSON Patch (RFC 6902) equivalents
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+)
+
+// Change describes a single field-level difference between two snapshots.
+// Path is a JSON Pointer (RFC 6901) into the snapshot's JSON representation.
+// Old and Value are always encoded, since null, false, 0 and "" are valid
+// values; Old is null for add operations and Value for remove operations.
+type Change struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Old   interface{} `json:"old"`
+	Value interface{} `json:"value"`
+}
+
+// Diff computes the structural difference between two values by comparing their
+// JSON representations. Paths listed in ignore (and everything below them) are skipped.
+func Diff(oldValue, newValue interface{}, ignore ...string) ([]Change, error) {
+	oldTree, err := normalize(oldValue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to normalize old value: %w", err)
+	}
+
+	newTree, err := normalize(newValue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to normalize new value: %w", err)
+	}
+
+	d := &differ{ignore: make(map[string]bool, len(ignore))}
+	for _, path := range ignore {
+		d.ignore[path] = true
+	}
+
+	d.walk("", oldTree, newTree)
+	return d.changes, nil
+}
+
+// normalize converts a value into the generic form produced by encoding/json
+func normalize(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree interface{}
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// differ accumulates changes while walking two normalized trees
+type differ struct {
+	ignore  map[string]bool
+	changes []Change
+}
+
+func (d *differ) walk(path string, oldNode, newNode interface{}) {
+	if d.ignore[path] {
+		return
+	}
+
+	switch oldTyped := oldNode.(type) {
+	case map[string]interface{}:
+		if newTyped, ok := newNode.(map[string]interface{}); ok {
+			d.walkObject(path, oldTyped, newTyped)
+			return
+		}
+
+	case []interface{}:
+		if newTyped, ok := newNode.([]interface{}); ok {
+			d.walkArray(path, oldTyped, newTyped)
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(oldNode, newNode) {
+		d.changes = append(d.changes, Change{Op: OpReplace, Path: path, Old: oldNode, Value: newNode})
+	}
+}
+
+func (d *differ) walkObject(path string, oldObj, newObj map[string]interface{}) {
+	keys := make([]string, 0, len(oldObj)+len(newObj))
+	for key := range oldObj {
+		keys = append(keys, key)
+	}
+	for key := range newObj {
+		if _, exists := oldObj[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		childPath := path + "/" + escapePointer(key)
+		if d.ignore[childPath] {
+			continue
+		}
+
+		oldChild, inOld := oldObj[key]
+		newChild, inNew := newObj[key]
+
+		switch {
+		case inOld && inNew:
+			d.walk(childPath, oldChild, newChild)
+		case inNew:
+			d.changes = append(d.changes, Change{Op: OpAdd, Path: childPath, Value: newChild})
+		default:
+			d.changes = append(d.changes, Change{Op: OpRemove, Path: childPath, Old: oldChild})
+		}
+	}
+}
+
+func (d *differ) walkArray(path string, oldArr, newArr []interface{}) {
+	common := len(oldArr)
+	if len(newArr) < common {
+		common = len(newArr)
+	}
+
+	for i := 0; i < common; i++ {
+		d.walk(path+"/"+strconv.Itoa(i), oldArr[i], newArr[i])
+	}
+
+	// Appended elements are added in order
+	for i := common; i < len(newArr); i++ {
+		d.changes = append(d.changes, Change{Op: OpAdd, Path: path + "/" + strconv.Itoa(i), Value: newArr[i]})
+	}
+
+	// Removed elements are emitted from the end so indices stay valid when applied in order
+	for i := len(oldArr) - 1; i >= common; i-- {
+		d.changes = append(d.changes, Change{Op: OpRemove, Path: path + "/" + strconv.Itoa(i), Old: oldArr[i]})
+	}
+}
+
+// escapePointer escapes a key for use as a JSON Pointer reference token
+func escapePointer(key string) string {
+	key = strings.ReplaceAll(key, "~", "~0")
+	return strings.ReplaceAll(key, "/", "~1")
+}
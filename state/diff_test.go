@@ -0,0 +1,145 @@
+package state
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// tree parses a JSON document into the form Diff compares
+func tree(t *testing.T, doc string) interface{} {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(doc), &value); err != nil {
+		t.Fatalf("parse %s: %v", doc, err)
+	}
+	return value
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		ignore   []string
+		want     []Change
+	}{
+		{
+			name: "equal",
+			old:  `{"a": 1, "b": [1, 2]}`,
+			new:  `{"a": 1, "b": [1, 2]}`,
+		},
+		{
+			name: "nested objects",
+			old:  `{"player": {"name": "ASH", "badges": {"boulder": false}}}`,
+			new:  `{"player": {"name": "ASH", "badges": {"boulder": true, "cascade": false}}}`,
+			want: []Change{
+				{Op: OpReplace, Path: "/player/badges/boulder", Old: false, Value: true},
+				{Op: OpAdd, Path: "/player/badges/cascade", Value: false},
+			},
+		},
+		{
+			name: "removed key",
+			old:  `{"a": 1, "b": 2}`,
+			new:  `{"a": 1}`,
+			want: []Change{
+				{Op: OpRemove, Path: "/b", Old: 2.0},
+			},
+		},
+		{
+			name: "array grows",
+			old:  `{"party": [1]}`,
+			new:  `{"party": [1, 2, 3]}`,
+			want: []Change{
+				{Op: OpAdd, Path: "/party/1", Value: 2.0},
+				{Op: OpAdd, Path: "/party/2", Value: 3.0},
+			},
+		},
+		{
+			name: "array shrinks from the end",
+			old:  `{"party": [1, 2, 3]}`,
+			new:  `{"party": [4]}`,
+			want: []Change{
+				{Op: OpReplace, Path: "/party/0", Old: 1.0, Value: 4.0},
+				{Op: OpRemove, Path: "/party/2", Old: 3.0},
+				{Op: OpRemove, Path: "/party/1", Old: 2.0},
+			},
+		},
+		{
+			name: "type changes",
+			old:  `{"a": {"x": 1}, "b": [1], "c": "1", "d": null}`,
+			new:  `{"a": [1], "b": 1, "c": 1, "d": {}}`,
+			want: []Change{
+				{Op: OpReplace, Path: "/a", Old: map[string]interface{}{"x": 1.0}, Value: []interface{}{1.0}},
+				{Op: OpReplace, Path: "/b", Old: []interface{}{1.0}, Value: 1.0},
+				{Op: OpReplace, Path: "/c", Old: "1", Value: 1.0},
+				{Op: OpReplace, Path: "/d", Old: nil, Value: map[string]interface{}{}},
+			},
+		},
+		{
+			name: "zero-valued old values",
+			old:  `{"money": 0, "flag": false, "name": "", "item": null}`,
+			new:  `{"money": 100, "flag": true, "name": "ASH", "item": 4}`,
+			want: []Change{
+				{Op: OpReplace, Path: "/flag", Old: false, Value: true},
+				{Op: OpReplace, Path: "/item", Old: nil, Value: 4.0},
+				{Op: OpReplace, Path: "/money", Old: 0.0, Value: 100.0},
+				{Op: OpReplace, Path: "/name", Old: "", Value: "ASH"},
+			},
+		},
+		{
+			name:   "ignored paths",
+			old:    `{"frame": 1, "timers": {"play": 5}, "money": 1}`,
+			new:    `{"frame": 2, "timers": {"play": 6}, "money": 2}`,
+			ignore: []string{"/frame", "/timers"},
+			want: []Change{
+				{Op: OpReplace, Path: "/money", Old: 1.0, Value: 2.0},
+			},
+		},
+		{
+			name: "escaped keys",
+			old:  `{"a/b": 1, "c~d": 1}`,
+			new:  `{"a/b": 2, "c~d": 2}`,
+			want: []Change{
+				{Op: OpReplace, Path: "/a~1b", Old: 1.0, Value: 2.0},
+				{Op: OpReplace, Path: "/c~0d", Old: 1.0, Value: 2.0},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Diff(tree(t, test.old), tree(t, test.new), test.ignore...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) == 0 && len(test.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Diff =\n%+v\nwant\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChangeEncodesZeroValues(t *testing.T) {
+	changes := []Change{
+		{Op: OpReplace, Path: "/money", Old: 0.0, Value: 100.0},
+		{Op: OpReplace, Path: "/money", Old: 100.0, Value: 0.0},
+		{Op: OpAdd, Path: "/flag", Value: false},
+	}
+	want := []string{
+		`{"op":"replace","path":"/money","old":0,"value":100}`,
+		`{"op":"replace","path":"/money","old":100,"value":0}`,
+		`{"op":"add","path":"/flag","old":null,"value":false}`,
+	}
+	for i, change := range changes {
+		data, err := json.Marshal(change)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want[i] {
+			t.Errorf("encoded %s, want %s", data, want[i])
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package state
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot is an immutable version of shared state. Neither the snapshot nor
+// the data it points to may be modified once published.
+type Snapshot[T any] struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	Data *T        `json:"data"`
+}
+
+// Store holds the current snapshot behind an atomic pointer. Readers never
+// block and always see a complete version; writers are serialized.
+type Store[T any] struct {
+	current atomic.Pointer[Snapshot[T]]
+	mu      sync.Mutex
+}
+
+// NewStore creates a store whose first version (sequence 0) holds initial
+func NewStore[T any](initial *T) *Store[T] {
+	s := &Store[T]{}
+	s.current.Store(&Snapshot[T]{Seq: 0, Time: time.Now(), Data: initial})
+	return s
+}
+
+// Load returns the current snapshot
+func (s *Store[T]) Load() *Snapshot[T] {
+	return s.current.Load()
+}
+
+// Publish makes data the current version and returns its snapshot.
+// Sequence numbers increase by exactly one per published version.
+func (s *Store[T]) Publish(data *T) *Snapshot[T] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := &Snapshot[T]{
+		Seq:  s.current.Load().Seq + 1,
+		Time: time.Now(),
+		Data: data,
+	}
+	s.current.Store(snapshot)
+	return snapshot
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"RetroGameAnalysis/server"
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+)
+
+// webFiles holds the built-in pages and the assets they share. The pages
+// know nothing about any game: they draw whatever a session's UI schema
+// describes.
+//
+//go:embed web
+var webFiles embed.FS
+
+// UI hints: how a field's value is drawn
+const (
+	HintText      = "text"      // the formatted value
+	HintBar       = "bar"       // a meter of the value out of the Max field
+	HintStat      = "stat"      // a compact cell in a two-column grid
+	HintTags      = "tags"      // an array, one chip per element's Item field
+	HintChecklist = "checklist" // an array, one tile per element's Item field, lit by its Check field
+)
+
+// Display formats for number fields
+const (
+	FormatNumber   = "number"   // thousands separators
+	FormatCurrency = "currency" // thousands separators and a currency sign
+	FormatPadded   = "padded"   // at least two digits, as in a clock
+	FormatHex      = "hex"
+	FormatPercent  = "percent"
+)
+
+// Group layouts
+const (
+	LayoutCard    = "card"    // a small card in the summary grid
+	LayoutSection = "section" // a full-width section
+)
+
+// UIField describes how one value of the game state is displayed. Path is
+// the value's dotted JSON path, relative to the element in a list group.
+// A field is editable when a property of the same name exists.
+type UIField struct {
+	Path     string `json:"path" yaml:"path"`
+	Label    string `json:"label" yaml:"label"`
+	Hint     string `json:"ui_hint" yaml:"ui_hint"`
+	Format   string `json:"format,omitempty" yaml:"format,omitempty"`
+	Icon     string `json:"icon,omitempty" yaml:"icon,omitempty"`
+	Max      string `json:"max,omitempty" yaml:"max,omitempty"`
+	Item     string `json:"item,omitempty" yaml:"item,omitempty"`
+	Check    string `json:"check,omitempty" yaml:"check,omitempty"`
+	Editable bool   `json:"editable" yaml:"-"`
+}
+
+// UIGroup is a set of fields shown together. A list group repeats its
+// fields once per element of the array at List, as a card headed by the
+// element's Title field and Badge.
+type UIGroup struct {
+	Name   string    `json:"name" yaml:"name"`
+	Label  string    `json:"label" yaml:"label"`
+	Icon   string    `json:"icon,omitempty" yaml:"icon,omitempty"`
+	Layout string    `json:"layout" yaml:"layout"`
+	List   string    `json:"list,omitempty" yaml:"list,omitempty"`
+	Title  string    `json:"title,omitempty" yaml:"title,omitempty"`
+	Badge  *UIField  `json:"badge,omitempty" yaml:"badge,omitempty"`
+	Fields []UIField `json:"fields" yaml:"fields"`
+}
+
+// UISchema describes a mapper's game state for the web UI. Ready names the
+// field that stays empty until a game is loaded.
+type UISchema struct {
+	Mapper string    `json:"mapper" yaml:"mapper"`
+	Title  string    `json:"title" yaml:"title"`
+	Ready  string    `json:"ready" yaml:"ready"`
+	Empty  string    `json:"empty" yaml:"empty"`
+	Groups []UIGroup `json:"groups" yaml:"groups"`
+}
+
+// builtinMappers holds the definitions of the built-in mappers, which the
+// mappers directory can replace
+//
+//go:embed mappers
+var builtinMappers embed.FS
+
+// uiSchemaExtension is the file extension of mapper UI schemas, which are
+// named after their mapper, e.g. pokemon-gen1.ui.yaml
+const uiSchemaExtension = ".ui.yaml"
+
+// loadUISchema reads a mapper's UI schema from the mappers directory if it
+// has one, and from the built-in mappers otherwise. Schemas are read on
+// every request, so edits show on reload.
+func loadUISchema(dir, mapper string) (*UISchema, error) {
+	data, err := os.ReadFile(filepath.Join(dir, mapper+uiSchemaExtension))
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = builtinMappers.ReadFile("mappers/" + mapper + uiSchemaExtension)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, server.NewCommandError(server.ErrorNotFound, "mapper %q has no UI schema", mapper)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var schema UISchema
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&schema); err != nil {
+		return nil, fmt.Errorf("UI schema of mapper %q: %w", mapper, err)
+	}
+	if schema.Mapper == "" {
+		schema.Mapper = mapper
+	}
+	return newUISchema(&schema), nil
+}
+
+// newUISchema fills in a schema's defaults and marks the fields that a
+// property can write
+func newUISchema(schema *UISchema) *UISchema {
+	for i := range schema.Groups {
+		group := &schema.Groups[i]
+		if group.Layout == "" {
+			group.Layout = LayoutCard
+			if group.List != "" {
+				group.Layout = LayoutSection
+			}
+		}
+
+		// List elements share their properties' names, e.g. pokemon.0.level
+		prefix := ""
+		if group.List != "" {
+			prefix = group.List + ".0."
+		}
+		mark := func(field *UIField) {
+			if field.Hint == "" {
+				field.Hint = HintText
+			}
+			_, field.Editable = propertyIndex[prefix+field.Path]
+		}
+		if group.Badge != nil {
+			mark(group.Badge)
+		}
+		for j := range group.Fields {
+			mark(&group.Fields[j])
+		}
+	}
+	return schema
+}
+
+// pageHandler serves one of the built-in pages
+func pageHandler(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, webFiles, "web/"+name)
+	}
+}
+
+// assetHandler serves the built-in pages' shared scripts and styles, which
+// custom UIs may use too
+func assetHandler() http.Handler {
+	root, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(root))
+}
+
+func (s *Session) handleGetUI(w http.ResponseWriter, r *http.Request) {
+	schema, err := loadUISchema(s.config.MappersDir, s.config.Mapper)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schema)
+}
+
+// customUIs lists the directories of the UIs directory, each of which is a
+// UI served at /ui/{name}/
+func (s *PokemonWebServer) customUIs() ([]string, error) {
+	entries, err := os.ReadDir(s.config.UIsDir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && safeNamePattern.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *PokemonWebServer) handleListUIs(w http.ResponseWriter, r *http.Request) {
+	names, err := s.customUIs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	uis := make([]map[string]string, len(names))
+	for i, name := range names {
+		uis[i] = map[string]string{"name": name, "url": "/ui/" + name + "/"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"dir": s.config.UIsDir, "uis": uis})
+}
+
+// handleCustomUI serves the files of a custom UI from the UIs directory
+func (s *PokemonWebServer) handleCustomUI(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	dir := filepath.Join(s.config.UIsDir, name)
+	if info, err := os.Stat(dir); !safeNamePattern.MatchString(name) || err != nil || !info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Relative links in the UI's pages need the trailing slash
+	if r.URL.Path == "/ui/"+name {
+		target := r.URL.Path + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+	http.StripPrefix("/ui/"+name, http.FileServer(http.Dir(dir))).ServeHTTP(w, r)
+}
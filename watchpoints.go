@@ -0,0 +1,417 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"RetroGameAnalysis/connection"
+	"RetroGameAnalysis/server"
+	"github.com/gorilla/mux"
+)
+
+// Watchpoint limits
+const (
+	maxWatchpoints      = 64     // Watchpoints per session
+	maxWatchpointLength = 0x1000 // Bytes watched by one watchpoint
+	maxWatchpointLog    = 1000   // Hits kept per watchpoint
+)
+
+// WatchpointRequest watches a range of memory for changes
+type WatchpointRequest struct {
+	Address Address `json:"address"`
+	Length  int     `json:"length"`
+	Name    string  `json:"name,omitempty"`
+}
+
+// Watchpoint describes a watched range
+type Watchpoint struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name,omitempty"`
+	Address    string    `json:"address"`
+	Length     int       `json:"length"`
+	Properties []string  `json:"properties"`
+	Hits       uint64    `json:"hits"`
+	Created    time.Time `json:"created"`
+}
+
+// WatchpointHit is one transition of a run of watched bytes between two polls
+type WatchpointHit struct {
+	Seq        uint64           `json:"seq"`
+	Watchpoint string           `json:"watchpoint"`
+	Name       string           `json:"name,omitempty"`
+	Time       time.Time        `json:"time"`
+	Address    string           `json:"address"`
+	Length     int              `json:"length"`
+	Old        string           `json:"old"`
+	New        string           `json:"new"`
+	Properties []PropertyChange `json:"properties,omitempty"`
+}
+
+// PropertyChange is the decoded before and after value of a property touched by a hit
+type PropertyChange struct {
+	Name string      `json:"name"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// watchpoint is a watched range. The read covers every property that
+// overlaps it, so a hit on part of a property can still decode its value.
+type watchpoint struct {
+	info       Watchpoint
+	address    uint32
+	length     int
+	properties []*Property
+	block      connection.MemoryBlock
+	last       []byte
+	hits       []WatchpointHit
+}
+
+// watchpointTable holds a session's watchpoints and their hit logs
+type watchpointTable struct {
+	mu      sync.Mutex
+	points  map[string]*watchpoint
+	nextID  uint64
+	nextSeq uint64
+}
+
+func newWatchpointTable() *watchpointTable {
+	return &watchpointTable{points: make(map[string]*watchpoint)}
+}
+
+// newWatchpoint validates a request and finds the properties it overlaps
+func newWatchpoint(request WatchpointRequest) (*watchpoint, error) {
+	if request.Length <= 0 || request.Length > maxWatchpointLength {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "length must be between 1 and %d", maxWatchpointLength)
+	}
+
+	address := uint32(request.Address)
+	if uint64(address)+uint64(request.Length) > 1<<32 {
+		return nil, server.NewCommandError(server.ErrorInvalidRequest, "range 0x%X+%d exceeds the address space", address, request.Length)
+	}
+	end := address + uint32(request.Length) - 1
+
+	w := &watchpoint{
+		address: address,
+		length:  request.Length,
+		block:   connection.MemoryBlock{Start: address, End: end},
+		info: Watchpoint{
+			Name:       request.Name,
+			Address:    fmt.Sprintf("0x%04X", address),
+			Length:     request.Length,
+			Properties: []string{},
+			Created:    time.Now(),
+		},
+	}
+	for i := range properties {
+		property := &properties[i]
+		if property.Address <= end && property.Address+property.Length-1 >= address {
+			w.properties = append(w.properties, property)
+			w.info.Properties = append(w.info.Properties, property.Name)
+			w.block.Start = min(w.block.Start, property.Address)
+			w.block.End = max(w.block.End, property.Address+property.Length-1)
+		}
+	}
+	return w, nil
+}
+
+// add registers a watchpoint, or returns false when the table is full
+func (t *watchpointTable) add(w *watchpoint) (Watchpoint, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.points) >= maxWatchpoints {
+		return Watchpoint{}, false
+	}
+	t.nextID++
+	w.info.ID = strconv.FormatUint(t.nextID, 10)
+	t.points[w.info.ID] = w
+	return w.info, true
+}
+
+func (t *watchpointTable) remove(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.points[id]
+	delete(t.points, id)
+	return ok
+}
+
+// list describes every watchpoint in creation order
+func (t *watchpointTable) list() []Watchpoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	infos := make([]Watchpoint, 0, len(t.points))
+	for _, w := range t.points {
+		infos = append(infos, w.info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Created.Before(infos[j].Created) })
+	return infos
+}
+
+// hits returns a watchpoint's logged hits with a sequence number above since
+func (t *watchpointTable) hits(id string, since uint64) (Watchpoint, []WatchpointHit, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, ok := t.points[id]
+	if !ok {
+		return Watchpoint{}, nil, server.NewCommandError(server.ErrorNotFound, "unknown watchpoint %q", id)
+	}
+
+	first := sort.Search(len(w.hits), func(i int) bool { return w.hits[i].Seq > since })
+	return w.info, append([]WatchpointHit{}, w.hits[first:]...), nil
+}
+
+// blocks returns the ranges read by each watchpoint, keyed by ID
+func (t *watchpointTable) blocks() map[string]connection.MemoryBlock {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	blocks := make(map[string]connection.MemoryBlock, len(t.points))
+	for id, w := range t.points {
+		blocks[id] = w.block
+	}
+	return blocks
+}
+
+// update compares a fresh read with the previous one and logs a hit for
+// every run of changed bytes. The first read only sets the baseline.
+func (t *watchpointTable) update(id string, data []byte, at time.Time) []WatchpointHit {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, ok := t.points[id]
+	if !ok {
+		return nil
+	}
+	previous := w.last
+	w.last = data
+	if previous == nil {
+		return nil
+	}
+
+	offset := int(w.address - w.block.Start)
+	var hits []WatchpointHit
+	for i := offset; i < offset+w.length; {
+		if previous[i] == data[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < offset+w.length && previous[i] != data[i] {
+			i++
+		}
+
+		t.nextSeq++
+		hit := WatchpointHit{
+			Seq:        t.nextSeq,
+			Watchpoint: id,
+			Name:       w.info.Name,
+			Time:       at,
+			Address:    fmt.Sprintf("0x%04X", w.block.Start+uint32(start)),
+			Length:     i - start,
+			Old:        hex.EncodeToString(previous[start:i]),
+			New:        hex.EncodeToString(data[start:i]),
+			Properties: w.decodeChanges(previous, data, start, i),
+		}
+		hits = append(hits, hit)
+	}
+
+	w.info.Hits += uint64(len(hits))
+	w.hits = append(w.hits, hits...)
+	if excess := len(w.hits) - maxWatchpointLog; excess > 0 {
+		w.hits = append(w.hits[:0], w.hits[excess:]...)
+	}
+	return hits
+}
+
+// decodeChanges decodes the properties overlapping [start, end) of the read
+func (w *watchpoint) decodeChanges(old, new []byte, start, end int) []PropertyChange {
+	var changes []PropertyChange
+	for _, property := range w.properties {
+		from := int(property.Address - w.block.Start)
+		to := from + int(property.Length)
+		if from >= end || to <= start {
+			continue
+		}
+		changes = append(changes, PropertyChange{
+			Name: property.Name,
+			Old:  property.Decode(old[from:to]),
+			New:  property.Decode(new[from:to]),
+		})
+	}
+	return changes
+}
+
+// pollWatchpoints reads every watchpoint in one request on each poll tick,
+// logs the transitions and broadcasts them as watchpoint_hit messages
+func (s *Session) pollWatchpoints() {
+	watched := s.watchpoints.blocks()
+	if len(watched) == 0 {
+		return
+	}
+
+	// Reads are keyed by start address, so watchpoints starting at the same
+	// address share the longest of their reads
+	ends := make(map[uint32]uint32, len(watched))
+	for _, block := range watched {
+		ends[block.Start] = max(ends[block.Start], block.End)
+	}
+	blocks := make([]connection.MemoryBlock, 0, len(ends))
+	for start, end := range ends {
+		blocks = append(blocks, connection.MemoryBlock{Name: fmt.Sprintf("watch 0x%04X", start), Start: start, End: end})
+	}
+
+	data, err := s.driver.ReadMemoryBlocks(blocks)
+	if err != nil {
+		return
+	}
+	at := time.Now()
+
+	for id, block := range watched {
+		size := int(block.End-block.Start) + 1
+		read, ok := data[block.Start]
+		if !ok || len(read) < size {
+			continue
+		}
+		for _, hit := range s.watchpoints.update(id, read[:size], at) {
+			s.wsManager.BroadcastMessage(server.Message{
+				Type:      "watchpoint_hit",
+				Data:      hit,
+				Timestamp: at,
+			})
+		}
+	}
+}
+
+// addWatchpoint starts watching a range
+func (s *Session) addWatchpoint(request WatchpointRequest) (*Watchpoint, error) {
+	w, err := newWatchpoint(request)
+	if err != nil {
+		return nil, err
+	}
+
+	info, ok := s.watchpoints.add(w)
+	if !ok {
+		return nil, server.NewCommandError(ErrorConflict, "session %s already has %d watchpoints", s.id, maxWatchpoints)
+	}
+	return &info, nil
+}
+
+// removeWatchpoint stops watching a range and discards its log
+func (s *Session) removeWatchpoint(id string) error {
+	if !s.watchpoints.remove(id) {
+		return server.NewCommandError(server.ErrorNotFound, "unknown watchpoint %q", id)
+	}
+	return nil
+}
+
+// watchpointLog returns a watchpoint with the hits logged after since
+func (s *Session) watchpointLog(id string, since uint64) (map[string]interface{}, error) {
+	info, hits, err := s.watchpoints.hits(id, since)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"watchpoint": info, "hits": hits}, nil
+}
+
+// registerWatchpointCommands exposes watchpoints over WebSocket
+func (s *Session) registerWatchpointCommands() {
+	s.wsManager.RegisterCommand("watchpoint_list", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{"watchpoints": s.watchpoints.list()}, nil
+	})
+
+	s.wsManager.RegisterCommand("watchpoint_add", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request WatchpointRequest
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		return s.addWatchpoint(request)
+	})
+
+	s.wsManager.RegisterCommand("watchpoint_remove", server.RoleWrite, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request struct {
+			ID string `json:"id"`
+		}
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		if err := s.removeWatchpoint(request.ID); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"id": request.ID, "removed": true}, nil
+	})
+
+	s.wsManager.RegisterCommand("watchpoint_hits", server.RoleRead, func(client *server.Client, params json.RawMessage) (interface{}, error) {
+		var request struct {
+			ID    string `json:"id"`
+			Since uint64 `json:"since"`
+		}
+		if err := decodeParams(params, &request); err != nil {
+			return nil, err
+		}
+		return s.watchpointLog(request.ID, request.Since)
+	})
+}
+
+// REST handlers for watchpoints
+
+func (s *Session) handleListWatchpoints(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"watchpoints": s.watchpoints.list()})
+}
+
+func (s *Session) handleAddWatchpoint(w http.ResponseWriter, r *http.Request) {
+	var request WatchpointRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "invalid request body: %v", err))
+		return
+	}
+
+	info, err := s.addWatchpoint(request)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(info)
+}
+
+func (s *Session) handleGetWatchpoint(w http.ResponseWriter, r *http.Request) {
+	var since uint64
+	if value := r.URL.Query().Get("since"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			writeCommandError(w, server.NewCommandError(server.ErrorInvalidRequest, "invalid since %q", value))
+			return
+		}
+		since = parsed
+	}
+
+	result, err := s.watchpointLog(mux.Vars(r)["id"], since)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *Session) handleRemoveWatchpoint(w http.ResponseWriter, r *http.Request) {
+	if err := s.removeWatchpoint(mux.Vars(r)["id"]); err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
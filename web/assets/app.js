@@ -0,0 +1,353 @@
+// Client shared by the built-in dashboard and custom UIs. It loads a
+// session's UI schema from /api/ui, keeps the game state current over the
+// WebSocket and draws the schema's groups, so no page needs to know which
+// game is running. Pass ?session=... and ?api_key=... on the page URL as
+// for the API.
+(function () {
+    const query = new URLSearchParams(window.location.search);
+    const session = query.get('session');
+    const apiKey = query.get('api_key');
+    const base = session ? `/api/sessions/${encodeURIComponent(session)}` : '/api';
+
+    // apiURL returns the URL of a session-scoped API path
+    function apiURL(path, params) {
+        return withKey(base + path, params);
+    }
+
+    // globalURL returns the URL of an API path shared by all sessions
+    function globalURL(path, params) {
+        return withKey('/api' + path, params);
+    }
+
+    function withKey(url, params) {
+        const search = new URLSearchParams(params || {});
+        if (apiKey) {
+            search.set('api_key', apiKey);
+        }
+        const rest = search.toString();
+        return url + (rest ? '?' + rest : '');
+    }
+
+    async function api(path, options) {
+        const response = await fetch(apiURL(path), options);
+        const body = await response.json().catch(() => null);
+        if (!response.ok) {
+            throw new Error(body && body.message ? body.message : response.statusText);
+        }
+        return body;
+    }
+
+    // write sets a property; the value text is parsed as JSON, else sent as a string
+    function write(property, text) {
+        let value = text;
+        try {
+            value = JSON.parse(text);
+        } catch (e) {
+            // not JSON: a string
+        }
+        return api(`/properties/${encodeURIComponent(property)}/value`, {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ value }),
+        });
+    }
+
+    // get looks up a dotted path such as "pokemon.0.level"
+    function get(object, path) {
+        return path.split('.').reduce((value, key) => (value == null ? undefined : value[key]), object);
+    }
+
+    function escape(value) {
+        return String(value).replace(/[&<>"']/g, c => ({
+            '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;',
+        })[c]);
+    }
+
+    // format renders a value in a field's display format
+    function format(value, field) {
+        if (value == null) {
+            return '-';
+        }
+        if (typeof value !== 'number') {
+            return String(value);
+        }
+        switch (field.format) {
+        case 'number':
+            return value.toLocaleString();
+        case 'currency':
+            return '$' + value.toLocaleString();
+        case 'padded':
+            return String(value).padStart(2, '0');
+        case 'hex':
+            return '0x' + value.toString(16).toUpperCase();
+        case 'percent':
+            return value.toFixed(1) + '%';
+        }
+        return String(value);
+    }
+
+    // valueHTML renders a formatted value; editable values carry the
+    // property that writes them
+    function valueHTML(value, field, path) {
+        const text = escape(format(value, field));
+        if (!field.editable) {
+            return text;
+        }
+        return `<span class="editable" data-property="${escape(path)}" data-value="${escape(value == null ? '' : value)}" title="Click to edit">${text}</span>`;
+    }
+
+    function barClass(percent) {
+        if (percent > 75) return 'bar-excellent';
+        if (percent > 50) return 'bar-good';
+        if (percent > 25) return 'bar-okay';
+        if (percent > 0) return 'bar-critical';
+        return 'bar-empty';
+    }
+
+    // fieldHTML renders one field of scope, whose properties are named prefix + path
+    function fieldHTML(field, scope, prefix) {
+        const value = get(scope, field.path);
+        const label = `<strong>${field.icon ? field.icon + ' ' : ''}${escape(field.label)}:</strong>`;
+        const path = prefix + field.path;
+
+        switch (field.ui_hint) {
+        case 'bar': {
+            const max = field.max ? get(scope, field.max) : 100;
+            const percent = max ? Math.max(0, Math.min(100, value / max * 100)) : 0;
+            return `<div class="bar"><div class="bar-fill ${barClass(percent)}" style="width: ${percent}%"></div></div>` +
+                `<p class="field">${label} ${valueHTML(value, field, path)}/${escape(format(max, field))} (${percent.toFixed(1)}%)</p>`;
+        }
+        case 'stat':
+            return `<div class="stat">${label} ${valueHTML(value, field, path)}</div>`;
+        case 'tags':
+            if (!Array.isArray(value) || value.length === 0) {
+                return '';
+            }
+            return `<p class="field">${label}<br>` +
+                value.map(item => `<span class="tag">${escape(field.item ? get(item, field.item) : item)}</span>`).join('') + '</p>';
+        case 'checklist':
+            if (!Array.isArray(value)) {
+                return '';
+            }
+            return '<div class="checklist">' + value.map(item => {
+                const on = Boolean(get(item, field.check));
+                return `<div class="check ${on ? 'on' : ''}">${on ? '✅' : '❌'}<br>${escape(get(item, field.item))}</div>`;
+            }).join('') + '</div>';
+        }
+        return `<p class="field">${label} ${valueHTML(value, field, path)}</p>`;
+    }
+
+    // fieldsHTML renders a group's fields, with consecutive stat fields in a grid
+    function fieldsHTML(fields, scope, prefix) {
+        let html = '';
+        let stats = '';
+        for (const field of fields) {
+            if (field.ui_hint === 'stat') {
+                stats += fieldHTML(field, scope, prefix);
+                continue;
+            }
+            if (stats) {
+                html += `<div class="stats">${stats}</div>`;
+                stats = '';
+            }
+            html += fieldHTML(field, scope, prefix);
+        }
+        if (stats) {
+            html += `<div class="stats">${stats}</div>`;
+        }
+        return html;
+    }
+
+    function heading(group) {
+        return (group.icon ? group.icon + ' ' : '') + escape(group.label);
+    }
+
+    // groupHTML renders a group, one card per element for a list group
+    function groupHTML(group, state) {
+        if (!group.list) {
+            if (group.layout === 'card') {
+                return `<div class="info-card"><h3>${heading(group)}</h3>${fieldsHTML(group.fields, state, '')}</div>`;
+            }
+            return `<div class="section"><h2>${heading(group)}</h2>${fieldsHTML(group.fields, state, '')}</div>`;
+        }
+
+        const items = get(state, group.list);
+        if (!Array.isArray(items) || items.length === 0) {
+            return '';
+        }
+        const cards = items.map((item, index) => {
+            const prefix = `${group.list}.${index}.`;
+            const badge = group.badge
+                ? `<div class="item-badge">${escape(group.badge.label)} ${valueHTML(get(item, group.badge.path), group.badge, prefix + group.badge.path)}</div>`
+                : '';
+            return `<div class="item-card"><div class="item-header">` +
+                `<div class="item-title">${escape(group.title ? get(item, group.title) : '#' + (index + 1))}</div>${badge}</div>` +
+                fieldsHTML(group.fields, item, prefix) + '</div>';
+        }).join('');
+        return `<div class="section"><h2>${heading(group)}</h2><div class="card-grid">${cards}</div></div>`;
+    }
+
+    // render draws every group of schema for state into root. Card groups
+    // are gathered into one summary grid where the first of them appears.
+    function render(schema, state, root) {
+        if (!state || (schema.ready && !get(state, schema.ready))) {
+            root.className = '';
+            root.innerHTML = `<div class="error">${escape(schema.empty || 'No game data yet.')}</div>`;
+            return;
+        }
+
+        let html = '';
+        let summary = null;
+        for (const group of schema.groups) {
+            if (group.layout === 'card' && !group.list) {
+                if (summary === null) {
+                    summary = '';
+                    html += '<!--summary-->';
+                }
+                summary += groupHTML(group, state);
+                continue;
+            }
+            html += groupHTML(group, state);
+        }
+        if (summary !== null) {
+            html = html.replace('<!--summary-->', `<div class="section"><div class="summary">${summary}</div></div>`);
+        }
+        root.className = '';
+        root.innerHTML = html;
+    }
+
+    // applyChanges applies JSON-Patch-like changes ({op, path, value})
+    function applyChanges(target, changes) {
+        for (const change of changes) {
+            const keys = change.path.split('/').slice(1)
+                .map(key => key.replace(/~1/g, '/').replace(/~0/g, '~'));
+            const last = keys.pop();
+            let parent = target;
+            for (const key of keys) {
+                parent = parent[key];
+            }
+
+            if (change.op === 'remove') {
+                if (Array.isArray(parent)) {
+                    parent.splice(Number(last), 1);
+                } else {
+                    delete parent[last];
+                }
+            } else {
+                parent[last] = change.value;
+            }
+        }
+    }
+
+    // connect keeps the game state current and calls onState(state) on
+    // every change, and onStatus(connected) when the stream connects or
+    // drops. A dropped stream reconnects after a pause.
+    function connect(onState, onStatus) {
+        let state = null;
+        let seq = 0;
+
+        function open() {
+            const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+            const ws = new WebSocket(`${protocol}//${window.location.host}${apiURL('/ws')}`);
+
+            ws.onopen = () => onStatus && onStatus(true);
+            ws.onmessage = event => {
+                const message = JSON.parse(event.data);
+                const messages = message.type === 'batch' ? message.data : [message];
+                for (const m of messages) {
+                    if (m.type === 'pokemon_update') {
+                        state = m.data;
+                        seq = m.seq || 0;
+                        onState(state);
+                    } else if (m.type === 'pokemon_diff' && state && m.seq > seq) {
+                        if (m.seq !== seq + 1) {
+                            // A diff went missing; reconnecting fetches a fresh snapshot
+                            state = null;
+                            ws.close();
+                            return;
+                        }
+                        seq = m.seq;
+                        applyChanges(state, m.data.changes);
+                        onState(state);
+                    }
+                }
+            };
+            ws.onclose = () => {
+                onStatus && onStatus(false);
+                setTimeout(open, 2000);
+            };
+        }
+
+        open();
+    }
+
+    // dashboard draws the session's schema into root and keeps it current.
+    // Clicking an editable value edits its property in place.
+    async function dashboard(root, titleElement) {
+        const schema = await api('/ui');
+        if (titleElement) {
+            titleElement.textContent = schema.title;
+        }
+        document.title = schema.title;
+
+        let state = null;
+        let editing = false;
+        let pending = false;
+        const draw = () => {
+            pending = false;
+            if (!editing) {
+                render(schema, state, root);
+            }
+        };
+
+        root.addEventListener('click', event => {
+            const target = event.target.closest('.editable');
+            if (!target || editing) {
+                return;
+            }
+            editing = true;
+            const input = document.createElement('input');
+            input.className = 'edit-input';
+            input.value = target.dataset.value;
+            target.replaceWith(input);
+            input.focus();
+            input.select();
+
+            const finish = async save => {
+                if (!editing) {
+                    return;
+                }
+                editing = false;
+                if (save && input.value !== target.dataset.value) {
+                    try {
+                        await write(target.dataset.property, input.value);
+                    } catch (error) {
+                        alert(`Failed to write ${target.dataset.property}: ${error.message}`);
+                    }
+                }
+                draw();
+            };
+            input.addEventListener('keydown', e => {
+                if (e.key === 'Enter') finish(true);
+                if (e.key === 'Escape') finish(false);
+            });
+            input.addEventListener('blur', () => finish(false));
+        });
+
+        connect(newState => {
+            state = newState;
+            if (!pending) {
+                pending = true;
+                requestAnimationFrame(draw);
+            }
+        }, connected => {
+            const indicator = document.getElementById('status-indicator');
+            if (indicator) {
+                indicator.className = 'status-indicator ' + (connected ? 'connected' : 'disconnected');
+            }
+        });
+        return schema;
+    }
+
+    window.RGA = { base, apiURL, globalURL, api, write, get, escape, format, render, connect, dashboard };
+})();
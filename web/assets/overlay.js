@@ -0,0 +1,47 @@
+// Helpers for overlay templates. Each overlay gets its query parameters as
+// the global `overlay` ({name, layout, theme, slots, params}) and draws the
+// game state it receives from Overlay.start.
+(function () {
+    function barClass(percent) {
+        if (percent > 50) return 'bar-excellent';
+        if (percent > 25) return 'bar-good';
+        if (percent > 10) return 'bar-okay';
+        if (percent > 0) return 'bar-critical';
+        return 'bar-empty';
+    }
+
+    // bar renders a meter of value out of max
+    function bar(value, max) {
+        const percent = max ? Math.max(0, Math.min(100, value / max * 100)) : 0;
+        return `<div class="bar"><div class="bar-fill ${barClass(percent)}" style="width: ${percent}%"></div></div>`;
+    }
+
+    // slots returns the elements of list chosen by ?slots=, or all of them
+    function slots(list) {
+        if (!Array.isArray(list)) {
+            return [];
+        }
+        if (!overlay.slots || overlay.slots.length === 0) {
+            return list.map((item, index) => ({ item, index }));
+        }
+        return overlay.slots.filter(index => index < list.length).map(index => ({ item: list[index], index }));
+    }
+
+    // start calls draw(state) for every game state, at most once a frame
+    function start(root, draw) {
+        let state = null;
+        let pending = false;
+        RGA.connect(newState => {
+            state = newState;
+            if (!pending) {
+                pending = true;
+                requestAnimationFrame(() => {
+                    pending = false;
+                    root.innerHTML = draw(state);
+                });
+            }
+        });
+    }
+
+    window.Overlay = { bar, slots, start, escape: RGA.escape };
+})();